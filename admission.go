@@ -0,0 +1,115 @@
+package proclimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultAdmissionPollInterval is how often usage is checked while a command waits to be admitted
+const defaultAdmissionPollInterval = 100 * time.Millisecond
+
+// AdmissionPolicy controls when a Cmd may be started within a limiter (see WithAdmission).
+// A command is admitted once all of the configured thresholds are met.
+type AdmissionPolicy struct {
+	// MaxMemory admits commands only while the memory usage of the limiter is below it
+	// (Linux only). Note that the usage of a command is only counted once it has allocated
+	// memory, so commands that are started in quick succession are admitted based on the
+	// usage before any of them started.
+	MaxMemory Memory
+	// MaxProcesses admits commands only while fewer than MaxProcesses processes are running
+	// within the limiter
+	MaxProcesses int
+	// Wait causes Cmd.Start to block until the command is admitted, or the Cmd's context is
+	// done. Otherwise, Cmd.Start returns an *AdmissionError immediately.
+	Wait bool
+	// PollInterval is how often usage is checked while waiting. Defaults to 100ms.
+	PollInterval time.Duration
+}
+
+// AdmissionError is returned by Cmd.Start when a command was not admitted by the
+// AdmissionPolicy of its limiter
+type AdmissionError struct {
+	// Reason describes the threshold that was not met
+	Reason string
+}
+
+func (e *AdmissionError) Error() string {
+	return fmt.Sprintf("command not admitted: %s", e.Reason)
+}
+
+// admitter is implemented by Limiters that have an admission policy
+type admitter interface {
+	// admit returns once the command may be started, and a function that must be called
+	// once it has been started (or has failed to start)
+	admit(ctx context.Context) (release func(), err error)
+}
+
+// admission enforces an AdmissionPolicy. Commands are admitted one at a time, so that
+// each admitted command is counted before the next is considered.
+type admission struct {
+	policy AdmissionPolicy
+	// turn holds a token while a command is being admitted and started
+	turn chan struct{}
+}
+
+func newAdmission(policy AdmissionPolicy) *admission {
+	return &admission{
+		policy: policy,
+		turn:   make(chan struct{}, 1),
+	}
+}
+
+func (a *admission) admit(ctx context.Context, stats func() (*Stats, error)) (func(), error) {
+	select {
+	case a.turn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-a.turn }
+
+	interval := a.policy.PollInterval
+	if interval <= 0 {
+		interval = defaultAdmissionPollInterval
+	}
+	var ticker *time.Ticker
+	for {
+		reason, err := a.check(stats)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if reason == "" {
+			return release, nil
+		}
+		if !a.policy.Wait {
+			release()
+			return nil, &AdmissionError{Reason: reason}
+		}
+		if ticker == nil {
+			ticker = time.NewTicker(interval)
+			defer ticker.Stop()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// check returns the reason the policy does not currently admit a command, or "" if it does
+func (a *admission) check(stats func() (*Stats, error)) (string, error) {
+	s, err := stats()
+	if err != nil {
+		return "", err
+	}
+	if a.policy.MaxMemory > 0 && s.MemoryUsage >= a.policy.MaxMemory {
+		return fmt.Sprintf("memory usage %s is not below %s", s.MemoryUsage, a.policy.MaxMemory), nil
+	}
+	if a.policy.MaxProcesses > 0 && s.Processes >= a.policy.MaxProcesses {
+		return fmt.Sprintf("%d processes are running (maximum %d)", s.Processes, a.policy.MaxProcesses), nil
+	}
+	return "", nil
+}
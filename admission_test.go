@@ -0,0 +1,142 @@
+package proclimit
+
+import (
+	"context"
+	"github.com/friendsofgo/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStats returns stats that can be changed concurrently
+type fakeStats struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (f *fakeStats) set(s Stats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats = s
+}
+
+func (f *fakeStats) Stats() (*Stats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.stats
+	return &s, nil
+}
+
+func TestAdmissionFailFast(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		policy      AdmissionPolicy
+		stats       Stats
+		admitted    bool
+	}{
+		{"no thresholds", AdmissionPolicy{}, Stats{MemoryUsage: Gigabyte, Processes: 100}, true},
+		{"memory below threshold", AdmissionPolicy{MaxMemory: Gigabyte}, Stats{MemoryUsage: 512 * Megabyte}, true},
+		{"memory at threshold", AdmissionPolicy{MaxMemory: Gigabyte}, Stats{MemoryUsage: Gigabyte}, false},
+		{"processes below threshold", AdmissionPolicy{MaxProcesses: 2}, Stats{Processes: 1}, true},
+		{"processes at threshold", AdmissionPolicy{MaxProcesses: 2}, Stats{Processes: 2}, false},
+	} {
+		stats := &fakeStats{stats: tt.stats}
+		release, err := newAdmission(tt.policy).admit(context.Background(), stats.Stats)
+		if tt.admitted {
+			if err != nil {
+				t.Errorf("%s: expected to be admitted, but got: %v", tt.description, err)
+				continue
+			}
+			release()
+			continue
+		}
+		if _, ok := err.(*AdmissionError); !ok {
+			t.Errorf("%s: expected an *AdmissionError, but got: %v", tt.description, err)
+		}
+	}
+}
+
+func TestAdmissionWait(t *testing.T) {
+	stats := &fakeStats{stats: Stats{Processes: 2}}
+	a := newAdmission(AdmissionPolicy{MaxProcesses: 2, Wait: true, PollInterval: time.Millisecond})
+
+	admitted := make(chan error)
+	go func() {
+		release, err := a.admit(context.Background(), stats.Stats)
+		if err == nil {
+			release()
+		}
+		admitted <- err
+	}()
+	select {
+	case err := <-admitted:
+		t.Fatalf("expected admission to wait, but got: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	stats.set(Stats{Processes: 1})
+	select {
+	case err := <-admitted:
+		if err != nil {
+			t.Fatalf("expected to be admitted, but got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected to be admitted once processes exited")
+	}
+}
+
+func TestAdmissionWaitContextDone(t *testing.T) {
+	stats := &fakeStats{stats: Stats{MemoryUsage: Gigabyte}}
+	a := newAdmission(AdmissionPolicy{MaxMemory: Gigabyte, Wait: true, PollInterval: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := a.admit(ctx, stats.Stats); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, but got: %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestAdmissionOneAtATime(t *testing.T) {
+	stats := &fakeStats{}
+	a := newAdmission(AdmissionPolicy{MaxProcesses: 1, Wait: true, PollInterval: time.Millisecond})
+	release, err := a.admit(context.Background(), stats.Stats)
+	if err != nil {
+		t.Fatalf("expected to be admitted, but got: %v", err)
+	}
+
+	// The first command has not been released (i.e. started) yet, so the second must wait
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := a.admit(ctx, stats.Stats); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, but got: %v", context.DeadlineExceeded, err)
+	}
+
+	release()
+	release, err = a.admit(context.Background(), stats.Stats)
+	if err != nil {
+		t.Fatalf("expected to be admitted after release, but got: %v", err)
+	}
+	release()
+}
+
+type admittingSpyLimiter struct {
+	spyLimiter
+	err error
+}
+
+func (a *admittingSpyLimiter) admit(ctx context.Context) (func(), error) {
+	return func() {}, a.err
+}
+
+func TestCmdStartNotAdmitted(t *testing.T) {
+	admissionErr := &AdmissionError{Reason: "test"}
+	sl := &admittingSpyLimiter{err: admissionErr}
+	cmd := &Cmd{Cmd: echo(), Limiter: sl}
+	if err := cmd.Start(); errors.Cause(err) != admissionErr {
+		t.Fatalf("expected admission error, but got: %v", err)
+	}
+	if cmd.Process != nil {
+		t.Errorf("expected the command not to be started")
+	}
+	if sl.calledWithPid != 0 {
+		t.Errorf("expected no process to be limited, but got %d", sl.calledWithPid)
+	}
+}
@@ -0,0 +1,199 @@
+package proclimit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/friendsofgo/errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSkipped is the error of jobs that a Batch with FailFast did not run, because another
+// job failed
+var ErrSkipped = errors.New("skipped after another job failed")
+
+// Job is a command run by a Batch within its own limiter
+type Job struct {
+	// Name identifies the job in its prefixed output and in its JobResult
+	Name string
+	// Command is the program to run, followed by its arguments
+	Command []string
+	// Options configure the limiter of the job. They are applied after the JobOptions
+	// of the Batch. WithName has no effect.
+	Options []Option
+}
+
+// JobResult describes how a Job exited
+type JobResult struct {
+	Job Job
+	// Err is the error returned when running the job, or nil if it exited successfully
+	Err error
+	// ProcessState is the state of the job's process after it exited, or nil if it did not start
+	ProcessState *os.ProcessState
+	// Usage is the resource usage of the job, or nil if it did not start
+	Usage *Usage
+}
+
+// OOMKilled reports whether any process of the job was killed for exceeding its memory limit
+func (r *JobResult) OOMKilled() bool {
+	return r.Usage != nil && r.Usage.OOMKills > 0
+}
+
+// Failed reports whether the job did not exit successfully
+func (r *JobResult) Failed() bool {
+	return r.Err != nil || r.OOMKilled()
+}
+
+// Batch runs many jobs in parallel. The batch creates a limiter that is shared by all
+// jobs, and each job runs within its own child of that limiter - so jobs are subject both
+// to their own limits and to the combined limits of the batch.
+type Batch struct {
+	// Options configure the limiter shared by all jobs. The limiter is owned by the batch
+	// (see WithOwned).
+	Options []Option
+	// JobOptions configure the limiter of every job
+	JobOptions []Option
+	// Parallel is the maximum number of jobs to run at once. Defaults to the number of CPUs.
+	Parallel int
+	// Stdout and Stderr receive the output of all jobs. Each line is prefixed by the name of
+	// the job that wrote it, e.g. "[job-1] ". If nil, the output is discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+	// FailFast stops the batch from starting jobs once a job has failed. Jobs that are
+	// already running are left to finish, and the results of the jobs that were not
+	// started contain ErrSkipped.
+	FailFast bool
+}
+
+// Run runs jobs, and returns their results in the same order. Jobs that had not started
+// when ctx is done are not run, and their results contain ctx.Err(). An error is only
+// returned if the limiter of the batch could not be created.
+func (b *Batch) Run(ctx context.Context, jobs []Job) ([]JobResult, error) {
+	parent, err := New(append(append([]Option{}, b.Options...), WithOwned())...)
+	if err != nil {
+		return nil, err
+	}
+	defer parent.Close()
+
+	parallel := b.Parallel
+	if parallel <= 0 {
+		parallel = runtime.NumCPU()
+	}
+	results := make([]JobResult, len(jobs))
+	sem := make(chan struct{}, parallel)
+	var (
+		wg       sync.WaitGroup
+		outputMu sync.Mutex
+		// failed is set to 1 once a job has failed
+		failed int32
+	)
+	for i, job := range jobs {
+		results[i].Job = job
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		// ctx may have been done, or a job may have failed, while waiting for a slot
+		if err := ctx.Err(); err != nil {
+			<-sem
+			results[i].Err = err
+			continue
+		}
+		if b.FailFast && atomic.LoadInt32(&failed) != 0 {
+			<-sem
+			results[i].Err = ErrSkipped
+			continue
+		}
+		wg.Add(1)
+		go func(i int, job Job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			options := append(append([]Option{}, b.JobOptions...), job.Options...)
+			options = append(options, WithName(fmt.Sprintf("job-%d", i)))
+			child, err := parent.NewChild(options...)
+			if err != nil {
+				results[i].Err = errors.Wrapf(err, "failed to create limiter for job %s", job.Name)
+				atomic.StoreInt32(&failed, 1)
+				return
+			}
+			defer child.Close()
+			results[i] = runJob(ctx, child.CommandContext, job, b.Stdout, b.Stderr, &outputMu)
+			if results[i].Failed() {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i, job)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func runJob(ctx context.Context, commandContext func(context.Context, string, ...string) *Cmd, job Job, stdout, stderr io.Writer, outputMu *sync.Mutex) JobResult {
+	result := JobResult{Job: job}
+	if len(job.Command) == 0 {
+		result.Err = errors.Errorf("job %s has no command", job.Name)
+		return result
+	}
+	cmd := commandContext(ctx, job.Command[0], job.Command[1:]...)
+	prefix := fmt.Sprintf("[%s] ", job.Name)
+	if stdout != nil {
+		w := &prefixWriter{w: stdout, prefix: prefix, mu: outputMu}
+		defer w.Flush()
+		cmd.Stdout = w
+	}
+	if stderr != nil {
+		w := &prefixWriter{w: stderr, prefix: prefix, mu: outputMu}
+		defer w.Flush()
+		cmd.Stderr = w
+	}
+	result.Err = cmd.Run()
+	result.ProcessState = cmd.ProcessState
+	result.Usage = cmd.Usage()
+	return result
+}
+
+// prefixWriter writes each line written to it to w, preceded by prefix. Lines are only
+// written once complete, so that lines written by concurrent jobs are not interleaved.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any incomplete line that remains
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := io.WriteString(p.w, p.prefix+string(line))
+	return err
+}
@@ -0,0 +1,178 @@
+// +build linux
+
+package proclimit_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/proclimittest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchRunParallel(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	var jobs []proclimit.Job
+	for i := 0; i < 6; i++ {
+		jobs = append(jobs, proclimit.Job{
+			Name:    fmt.Sprintf("job%d", i),
+			Command: []string{"sh", "-c", "echo start; sleep 0.1; echo end"},
+		})
+	}
+	jobs[2].Command = []string{"sh", "-c", "echo start; echo end; exit 3"}
+	var out bytes.Buffer
+	b := &proclimit.Batch{Parallel: 2, Stdout: &out}
+	results, err := b.Run(context.Background(), jobs)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for i, result := range results {
+		if result.Job.Name != jobs[i].Name {
+			t.Errorf("expected result %d to be for %s, but got %s", i, jobs[i].Name, result.Job.Name)
+		}
+		if failed := i == 2; result.Failed() != failed {
+			t.Errorf("expected %s to have failed: %v, but got: %v", result.Job.Name, failed, result.Err)
+		}
+		if result.ProcessState == nil {
+			t.Errorf("expected %s to have run", result.Job.Name)
+		}
+	}
+	if code := results[2].ProcessState.ExitCode(); code != 3 {
+		t.Errorf("expected job2 to exit with code 3, but got %d", code)
+	}
+
+	// Every job writes a start and an end line, so the number of jobs running at once is
+	// the number that have started, but not ended
+	running, maxRunning, lines := 0, 0, 0
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		lines++
+		switch {
+		case strings.HasSuffix(scanner.Text(), "] start"):
+			running++
+		case strings.HasSuffix(scanner.Text(), "] end"):
+			running--
+		default:
+			t.Errorf("unexpected output line %q", scanner.Text())
+		}
+		if running > maxRunning {
+			maxRunning = running
+		}
+	}
+	if lines != 2*len(jobs) {
+		t.Errorf("expected %d lines of output, but got %d:\n%s", 2*len(jobs), lines, out.String())
+	}
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 jobs to run at once, but %d did", maxRunning)
+	}
+}
+
+func TestBatchRunBudgetExceeded(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	// The wall time budget of the batch kills the processes of every job
+	b := &proclimit.Batch{
+		Options:  []proclimit.Option{proclimit.WithWallTimeout(100 * time.Millisecond)},
+		Parallel: 2,
+	}
+	results, err := b.Run(context.Background(), []proclimit.Job{
+		{Name: "a", Command: []string{"sleep", "10"}},
+		{Name: "b", Command: []string{"sleep", "10"}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	for _, result := range results {
+		budgetErr, ok := result.Err.(*proclimit.BudgetExceededError)
+		if !ok {
+			t.Errorf("expected %s to fail with a *BudgetExceededError, but got: %v", result.Job.Name, result.Err)
+			continue
+		}
+		if budgetErr.Budget != "wall time" {
+			t.Errorf("expected the wall time budget to be exceeded, but got %q", budgetErr.Budget)
+		}
+	}
+}
+
+func TestBatchRunFailFast(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	jobs := []proclimit.Job{
+		{Name: "fails", Command: []string{"false"}},
+		{Name: "skipped-1", Command: []string{"true"}},
+		{Name: "skipped-2", Command: []string{"true"}},
+	}
+	b := &proclimit.Batch{Parallel: 1, FailFast: true}
+	results, err := b.Run(context.Background(), jobs)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if results[0].Err == nil || results[0].ProcessState == nil {
+		t.Errorf("expected the first job to run and fail, but got: %v", results[0].Err)
+	}
+	for _, result := range results[1:] {
+		if result.Err != proclimit.ErrSkipped || result.ProcessState != nil {
+			t.Errorf("expected %s to be skipped, but got: %v", result.Job.Name, result.Err)
+		}
+	}
+
+	// Without FailFast, every job runs
+	b.FailFast = false
+	results, err = b.Run(context.Background(), jobs)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	for _, result := range results[1:] {
+		if result.Err != nil {
+			t.Errorf("expected %s to succeed, but got: %v", result.Job.Name, result.Err)
+		}
+	}
+}
+
+func TestBatchRunContextCancelled(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	jobs := []proclimit.Job{
+		{Name: "running", Command: []string{"sleep", "10"}},
+		{Name: "not-started", Command: []string{"true"}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	b := &proclimit.Batch{Parallel: 1}
+	results, err := b.Run(ctx, jobs)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the running job to be stopped, but Run took %s", elapsed)
+	}
+	if results[0].Err == nil || results[0].ProcessState == nil {
+		t.Errorf("expected the running job to be stopped, but got: %v", results[0].Err)
+	}
+	if results[1].Err != context.DeadlineExceeded || results[1].ProcessState != nil {
+		t.Errorf("expected the second job not to start, but got: %v", results[1].Err)
+	}
+}
@@ -0,0 +1,43 @@
+package proclimit
+
+import (
+	"bytes"
+	"github.com/friendsofgo/errors"
+	"sync"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	mu := &sync.Mutex{}
+	a := &prefixWriter{w: &out, prefix: "[a] ", mu: mu}
+	b := &prefixWriter{w: &out, prefix: "[b] ", mu: mu}
+
+	a.Write([]byte("hello "))
+	b.Write([]byte("one\ntw"))
+	a.Write([]byte("world\nfoo"))
+	b.Write([]byte("o\n"))
+	a.Flush()
+	b.Flush()
+
+	expected := "[b] one\n[a] hello world\n[b] two\n[a] foo\n"
+	if out.String() != expected {
+		t.Errorf("expected output %q, but got %q", expected, out.String())
+	}
+}
+
+func TestJobResultFailed(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		result      JobResult
+		failed      bool
+	}{
+		{"succeeded", JobResult{Usage: &Usage{}}, false},
+		{"error", JobResult{Err: errors.New("exit status 1")}, true},
+		{"oom killed", JobResult{Usage: &Usage{OOMKills: 1}}, true},
+	} {
+		if tt.result.Failed() != tt.failed {
+			t.Errorf("%s: expected Failed() to be %v", tt.description, tt.failed)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package proclimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BudgetExceededError is returned by Cmd.Wait when the processes within a Limiter
+// were killed because one of its budgets (see WithCPUTimeBudget and WithWallTimeout)
+// was exhausted.
+type BudgetExceededError struct {
+	// Budget is the budget that was exhausted, either "cpu time" or "wall time"
+	Budget string
+	// Limit is the size of the budget
+	Limit time.Duration
+	// Used is the amount of the budget that had been used when it was enforced
+	Used time.Duration
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s budget of %s exceeded (used %s)", e.Budget, e.Limit, e.Used)
+}
+
+// budgetChecker is implemented by Limiters that can enforce budgets
+type budgetChecker interface {
+	budgetExceeded() error
+}
+
+// maxBudgetPollInterval is the maximum time between checks of the CPU time budget
+const maxBudgetPollInterval = 100 * time.Millisecond
+
+// budget enforces absolute limits on the resources consumed by a limiter. Once
+// started, a watcher goroutine kills all processes within the limiter when the
+// CPU time or wall time budget is exhausted.
+type budget struct {
+	cpuTime  time.Duration
+	wallTime time.Duration
+
+	mu       sync.Mutex
+	started  bool
+	done     chan struct{}
+	exceeded *BudgetExceededError
+}
+
+func (b *budget) enabled() bool {
+	return b.cpuTime > 0 || b.wallTime > 0
+}
+
+// start begins enforcing the budget, unless it is already being enforced. The wall
+// time budget is measured from the first call to start.
+func (b *budget) start(stats func() (*Stats, error), kill func() error) {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started {
+		return
+	}
+	b.started = true
+	b.done = make(chan struct{})
+	go b.watch(b.done, time.Now(), stats, kill)
+}
+
+func (b *budget) watch(done <-chan struct{}, startTime time.Time, stats func() (*Stats, error), kill func() error) {
+	var wallTimeout <-chan time.Time
+	if b.wallTime > 0 {
+		timer := time.NewTimer(b.wallTime)
+		defer timer.Stop()
+		wallTimeout = timer.C
+	}
+	var poll <-chan time.Time
+	if b.cpuTime > 0 {
+		interval := b.cpuTime / 10
+		if interval > maxBudgetPollInterval {
+			interval = maxBudgetPollInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-wallTimeout:
+			b.exceed(&BudgetExceededError{Budget: "wall time", Limit: b.wallTime, Used: time.Since(startTime)}, kill)
+			return
+		case <-poll:
+			s, err := stats()
+			if err != nil || s.CPUUsage < b.cpuTime {
+				continue
+			}
+			b.exceed(&BudgetExceededError{Budget: "cpu time", Limit: b.cpuTime, Used: s.CPUUsage}, kill)
+			return
+		}
+	}
+}
+
+func (b *budget) exceed(err *BudgetExceededError, kill func() error) {
+	b.mu.Lock()
+	b.exceeded = err
+	b.mu.Unlock()
+	kill()
+}
+
+// err returns a *BudgetExceededError if the budget has been exhausted
+func (b *budget) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exceeded == nil {
+		return nil
+	}
+	return b.exceeded
+}
+
+// stop stops enforcing the budget
+func (b *budget) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done != nil {
+		close(b.done)
+		b.done = nil
+	}
+}
@@ -0,0 +1,76 @@
+package proclimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetCPUTimeExceeded(t *testing.T) {
+	b := &budget{cpuTime: 50 * time.Millisecond}
+	usage := make(chan time.Duration, 3)
+	usage <- 10 * time.Millisecond
+	usage <- 40 * time.Millisecond
+	usage <- 60 * time.Millisecond
+	killed := make(chan struct{})
+	b.start(func() (*Stats, error) {
+		return &Stats{CPUUsage: <-usage}, nil
+	}, func() error {
+		close(killed)
+		return nil
+	})
+	defer b.stop()
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("expected processes to be killed")
+	}
+	err, ok := b.err().(*BudgetExceededError)
+	if !ok {
+		t.Fatalf("expected *BudgetExceededError, but got: %v", b.err())
+	}
+	expected := BudgetExceededError{Budget: "cpu time", Limit: 50 * time.Millisecond, Used: 60 * time.Millisecond}
+	if *err != expected {
+		t.Errorf("expected %+v, but got %+v", expected, *err)
+	}
+	if err.Error() != "cpu time budget of 50ms exceeded (used 60ms)" {
+		t.Errorf("unexpected error message: %s", err.Error())
+	}
+}
+
+func TestBudgetWallTimeExceeded(t *testing.T) {
+	b := &budget{wallTime: 20 * time.Millisecond}
+	killed := make(chan struct{})
+	start := time.Now()
+	b.start(nil, func() error {
+		close(killed)
+		return nil
+	})
+	defer b.stop()
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("expected processes to be killed")
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected processes to be killed after 20ms, but were killed after %s", elapsed)
+	}
+	err, ok := b.err().(*BudgetExceededError)
+	if !ok || err.Budget != "wall time" {
+		t.Errorf("expected wall time *BudgetExceededError, but got: %v", b.err())
+	}
+}
+
+func TestBudgetStopped(t *testing.T) {
+	b := &budget{wallTime: 20 * time.Millisecond}
+	b.start(nil, func() error {
+		t.Error("expected processes not to be killed")
+		return nil
+	})
+	b.stop()
+	time.Sleep(40 * time.Millisecond)
+	if b.err() != nil {
+		t.Errorf("expected no error, but got: %v", b.err())
+	}
+}
@@ -3,11 +3,23 @@
 package proclimit
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/aoldershaw/proclimit/internal/cgroupfs"
 	"github.com/containerd/cgroups"
 	"github.com/friendsofgo/errors"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"io/ioutil"
+	"log"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // Option allows for customizing the behaviour of the Cgroup limiter.
@@ -15,14 +27,65 @@ import (
 // Options will typically perform operations on cgroup.LinuxResources.
 type Option func(cgroup *Cgroup)
 
-// WithName sets the name of the Cgroup. If not specified, a random UUID will
-// be generated as the name
+// WithName sets the name of the Cgroup. If not specified, a random name will be
+// generated ("proclimit-" followed by a UUID), and the Cgroup is owned by its creator
+// (see WithOwned), as nothing else can know its name to close it.
 func WithName(name string) Option {
 	return func(cgroup *Cgroup) {
 		cgroup.Name = name
 	}
 }
 
+// WithParentCgroups creates the Cgroup within the given cgroups, by controller (e.g. the
+// cgroups of a process, as read from /proc/<pid>/cgroup), rather than at the root of each
+// hierarchy, so that their limits (e.g. those set by systemd) still apply to its processes.
+// Like children, such Cgroups are not recorded in the state directory: they are not
+// included in List, and cannot be loaded with Existing. WithPressure has no effect on them.
+func WithParentCgroups(paths map[string]string) Option {
+	return func(cgroup *Cgroup) {
+		cgroup.parents = map[string]string{}
+		for controller, p := range paths {
+			cgroup.parents[controller] = p
+		}
+	}
+}
+
+// WithLabels adds labels to the Cgroup, which are recorded with its metadata. Cgroups can
+// be selected by their labels with List. Labels of children (see NewChild) are not recorded.
+func WithLabels(labels map[string]string) Option {
+	return func(cgroup *Cgroup) {
+		if cgroup.labels == nil {
+			cgroup.labels = map[string]string{}
+		}
+		for key, value := range labels {
+			cgroup.labels[key] = value
+		}
+	}
+}
+
+// WithOwned marks the Cgroup as owned by the process that creates it, which closes it
+// before exiting. If the process exits without closing it (e.g. because it crashed), the
+// Cgroup is stale: it is reported by FindStale, and removed by CleanupStale once no
+// processes remain within it. Cgroups that are not owned may outlive their creator, and
+// are never collected. Only Cgroups created with a name (see WithName) may be not owned.
+func WithOwned() Option {
+	return func(cgroup *Cgroup) {
+		cgroup.owned = true
+	}
+}
+
+// WithPressure makes the Pressure of the Cgroup available, by also creating it within the
+// cgroup v2 hierarchy on hybrid systems (see Pressure). Processes added to the Cgroup are
+// moved into it there, which detaches them from the cgroup of their systemd unit (or
+// container) within the v2 hierarchy - e.g. systemd no longer accounts for them, or
+// stops them along with their unit. Children of such a Cgroup are also created within
+// the v2 hierarchy.
+func WithPressure() Option {
+	return func(cgroup *Cgroup) {
+		cgroup.pressure = true
+	}
+}
+
 // WithCPULimit sets the maximum CPU limit (as a percentage) allowed for all processes within the Cgroup.
 // The percentage is based on a single CPU core. That is to say, 50 allows for the use of half of a core,
 // 200 allows for the use of two cores, etc.
@@ -47,17 +110,118 @@ func WithCPULimit(cpuLimit Percent) Option {
 
 // WithMemoryLimit sets the maximum amount of virtual memory allowed for all processes within the Cgroup.
 //
-// `memory.max_usage_in_bytes` is set to memory
+// `memory.max_usage_in_bytes` is set to memory. Values above MaxMemory are clamped to it, as
+// they would otherwise wrap around to a negative (unlimited) limit.
 func WithMemoryLimit(memory Memory) Option {
 	return func(cgroup *Cgroup) {
 		if cgroup.LinuxResources.Memory == nil {
 			cgroup.LinuxResources.Memory = &specs.LinuxMemory{}
 		}
+		if memory > MaxMemory {
+			memory = MaxMemory
+		}
 		cgroup.LinuxResources.Memory.Limit = new(int64)
 		*cgroup.LinuxResources.Memory.Limit = int64(memory)
 	}
 }
 
+// WithPidsLimit sets the maximum number of processes (and threads) allowed within the Cgroup.
+//
+// `pids.max` is set to limit
+func WithPidsLimit(limit int64) Option {
+	return func(cgroup *Cgroup) {
+		cgroup.LinuxResources.Pids = &specs.LinuxPids{Limit: limit}
+	}
+}
+
+// WithCPUSet restricts all processes within the Cgroup to run on the given CPUs. cpus is
+// a comma separated list of CPU numbers or ranges (e.g. "0-3,6").
+//
+// `cpuset.cpus` is set to cpus
+func WithCPUSet(cpus string) Option {
+	return func(cgroup *Cgroup) {
+		if cgroup.LinuxResources.CPU == nil {
+			cgroup.LinuxResources.CPU = &specs.LinuxCPU{}
+		}
+		cgroup.LinuxResources.CPU.Cpus = cpus
+	}
+}
+
+// IOLimit describes the maximum rate of IO allowed to a single block device, identified
+// by its major and minor numbers. Rates that are 0 are not limited.
+type IOLimit struct {
+	Major     int64
+	Minor     int64
+	ReadBPS   Memory
+	WriteBPS  Memory
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+// WithIOLimit throttles IO to a block device for all processes within the Cgroup.
+// It can be specified multiple times to throttle multiple devices.
+//
+// `blkio.throttle.{read,write}_{bps,iops}_device` are set for the device
+func WithIOLimit(limit IOLimit) Option {
+	return func(cgroup *Cgroup) {
+		if cgroup.LinuxResources.BlockIO == nil {
+			cgroup.LinuxResources.BlockIO = &specs.LinuxBlockIO{}
+		}
+		blkio := cgroup.LinuxResources.BlockIO
+		throttle := func(devices *[]specs.LinuxThrottleDevice, rate uint64) {
+			if rate == 0 {
+				return
+			}
+			device := specs.LinuxThrottleDevice{Rate: rate}
+			device.Major = limit.Major
+			device.Minor = limit.Minor
+			*devices = append(*devices, device)
+		}
+		throttle(&blkio.ThrottleReadBpsDevice, uint64(limit.ReadBPS))
+		throttle(&blkio.ThrottleWriteBpsDevice, uint64(limit.WriteBPS))
+		throttle(&blkio.ThrottleReadIOPSDevice, limit.ReadIOPS)
+		throttle(&blkio.ThrottleWriteIOPSDevice, limit.WriteIOPS)
+	}
+}
+
+// WithLinuxResources sets all of the resources of the Cgroup, replacing any resources set
+// by previous Options. Options specified afterwards modify resources.
+func WithLinuxResources(resources *specs.LinuxResources) Option {
+	return func(cgroup *Cgroup) {
+		cgroup.LinuxResources = resources
+	}
+}
+
+// WithCPUTimeBudget sets the total CPU time that all processes within the Cgroup may consume.
+// Once the budget is exhausted, all processes within the Cgroup are killed, and Cmd.Wait
+// returns a *BudgetExceededError.
+//
+// Unlike WithCPULimit, which limits the rate of CPU usage, this is an absolute budget.
+// CPU usage (`cpuacct.usage`) is polled, so processes may slightly exceed the budget
+// before they are killed.
+func WithCPUTimeBudget(budget time.Duration) Option {
+	return func(cgroup *Cgroup) {
+		cgroup.budget.cpuTime = budget
+	}
+}
+
+// WithAdmission sets the policy that decides when commands may be started within the
+// Cgroup. See AdmissionPolicy.
+func WithAdmission(policy AdmissionPolicy) Option {
+	return func(cgroup *Cgroup) {
+		cgroup.admission = newAdmission(policy)
+	}
+}
+
+// WithWallTimeout sets the maximum wall time that processes may run within the Cgroup,
+// measured from when the first process is limited. Once the timeout elapses, all
+// processes within the Cgroup are killed, and Cmd.Wait returns a *BudgetExceededError.
+func WithWallTimeout(timeout time.Duration) Option {
+	return func(cgroup *Cgroup) {
+		cgroup.budget.wallTime = timeout
+	}
+}
+
 // Cgroup represents a cgroup in a Linux system. Resource limits can be
 // configured by modifying LinuxResources through Options. Modifying
 // LinuxResources after calling New(...) will have no effect.
@@ -65,6 +229,49 @@ type Cgroup struct {
 	Name           string
 	LinuxResources *specs.LinuxResources
 	cgroup         cgroups.Cgroup
+	budget         budget
+	parent         *Cgroup
+	admission      *admission
+	metadata       *Metadata
+	// recorded is whether the metadata of the cgroup was recorded in the state directory
+	// by this Cgroup (see New)
+	recorded bool
+	// owned is set by WithOwned
+	owned bool
+	// pressure is set by WithPressure
+	pressure bool
+	// parents are set by WithParentCgroups
+	parents map[string]string
+	labels  map[string]string
+	// unified is the directory of the cgroup within the cgroup v2 hierarchy, if any
+	unified string
+}
+
+// hierarchy returns the cgroup subsystems in which Cgroups are created. Tests may point
+// proclimit at another hierarchy (see proclimittest.CgroupFS), which contains a directory
+// per subsystem like /sys/fs/cgroup; subsystems without a directory are not used.
+func hierarchy() ([]cgroups.Subsystem, error) {
+	root := cgroupfs.Root()
+	if root == "" {
+		return cgroups.V1()
+	}
+	var enabled []cgroups.Subsystem
+	for _, s := range []cgroups.Subsystem{
+		cgroups.NewFreezer(root),
+		cgroups.NewPids(root),
+		cgroups.NewCputset(root),
+		cgroups.NewCpu(root),
+		cgroups.NewCpuacct(root),
+		cgroups.NewMemory(root),
+		cgroups.NewBlkio(root),
+	} {
+		if p, ok := s.(interface{ Path(string) string }); ok {
+			if _, err := os.Lstat(p.Path("/")); err == nil {
+				enabled = append(enabled, s)
+			}
+		}
+	}
+	return enabled, nil
 }
 
 // New creates a new Cgroup. Resource limits and the name of the Cgroup can be defined
@@ -76,33 +283,130 @@ func New(options ...Option) (*Cgroup, error) {
 	for _, opt := range options {
 		opt(c)
 	}
+	if err := validateLabels(c.labels); err != nil {
+		return nil, err
+	}
 	var err error
 	if c.Name == "" {
 		c.Name, err = randomName()
 		if err != nil {
 			return nil, err
 		}
+		c.owned = true
+	}
+	// Record the owner before creating the cgroup, so that CleanupStale never mistakes
+	// a cgroup that is being created for a leaked one. Recording is best-effort: if the
+	// state directory is not writable, the Cgroup still works, but it is not included in
+	// List, and is never collected by CleanupStale. The metadata of an existing cgroup of
+	// the same name is kept, and so is not removed if creating the cgroup fails.
+	c.metadata = &Metadata{
+		Name:          c.Name,
+		Creator:       currentCreator(),
+		CreatedAt:     time.Now(),
+		Resources:     c.LinuxResources,
+		CPUTimeBudget: c.budget.cpuTime,
+		WallTimeout:   c.budget.wallTime,
+		Labels:        c.labels,
+		Owned:         c.owned,
+	}
+	if c.parents == nil {
+		c.recorded = createMetadata(c.metadata) == nil
 	}
-	c.cgroup, err = cgroups.New(cgroups.V1, cgroups.StaticPath(fmt.Sprintf("/%s", c.Name)), c.LinuxResources)
+	c.cgroup, err = cgroups.New(hierarchy, c.path(), c.LinuxResources)
 	if err != nil {
+		if c.recorded {
+			removeMetadata(c.Name)
+		}
 		return nil, errors.Wrap(err, "failed to create cgroup")
 	}
+	if c.pressure && c.parents == nil {
+		c.createUnified()
+	}
 	return c, nil
 }
 
-// New loads an existing Cgroup by name
+// path returns the path of the Cgroup within each hierarchy: /<name>, or <name> within
+// its parent cgroup in the hierarchy if it has parents (see WithParentCgroups).
+// Hierarchies in which it has no parent are not used.
+func (c *Cgroup) path() cgroups.Path {
+	if c.parents == nil {
+		return cgroups.StaticPath(fmt.Sprintf("/%s", c.Name))
+	}
+	return func(subsystem cgroups.Name) (string, error) {
+		parent, ok := c.parents[string(subsystem)]
+		if !ok {
+			return "", cgroups.ErrControllerNotActive
+		}
+		return path.Join(parent, c.Name), nil
+	}
+}
+
+// Existing loads an existing Cgroup by name. Its LinuxResources are read back from the
+// cgroup, and reflect any changes made by Update. Budgets and admission policies are
+// not restored - they are only enforced by the process that set them.
+//
+// Only failing to load the cgroup is an error. If its resources cannot be read, a warning
+// is logged and its LinuxResources are empty; if its metadata cannot be read, Metadata
+// returns nil.
 func Existing(name string) (*Cgroup, error) {
 	c := &Cgroup{
 		Name: name,
 	}
 	var err error
-	c.cgroup, err = cgroups.Load(cgroups.V1, cgroups.StaticPath(fmt.Sprintf("/%s", name)))
+	c.cgroup, err = cgroups.Load(hierarchy, cgroups.StaticPath(fmt.Sprintf("/%s", name)))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load cgroup")
 	}
+	if c.LinuxResources, err = c.readResources(); err != nil {
+		log.Printf("proclimit: %s: %v", name, err)
+		c.LinuxResources = &specs.LinuxResources{}
+	}
+	// Metadata that is missing (e.g. because the cgroup was not created by New) or
+	// unreadable is treated alike
+	c.metadata, _ = readMetadata(name)
+	c.recorded = c.metadata != nil
+	c.loadUnified()
 	return c, nil
 }
 
+// Metadata describes how the Cgroup was created. It is nil if the Cgroup was not created
+// by New (e.g. for children).
+func (c *Cgroup) Metadata() *Metadata {
+	return c.metadata
+}
+
+// NewChild creates a Cgroup nested within c. Processes within the child are subject to the
+// limits (and budgets) of both Cgroups, and are included in the Processes and Stats of c.
+//
+// The name of the child (see WithName) is relative to c - the Name of the returned Cgroup
+// is "<parent name>/<child name>". Children are not included in List, and are deleted
+// along with c.
+func (c *Cgroup) NewChild(options ...Option) (*Cgroup, error) {
+	child := &Cgroup{
+		LinuxResources: &specs.LinuxResources{},
+		parent:         c,
+	}
+	for _, opt := range options {
+		opt(child)
+	}
+	var err error
+	if child.Name == "" {
+		child.Name, err = randomName()
+		if err != nil {
+			return nil, err
+		}
+	}
+	child.cgroup, err = c.cgroup.New(child.Name, child.LinuxResources)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create child cgroup")
+	}
+	child.Name = c.Name + "/" + child.Name
+	if child.pressure || c.unified != "" {
+		child.createUnified()
+	}
+	return child, nil
+}
+
 // Command constructs a wrapped Cmd struct to execute the named program with the given arguments.
 // This wrapped Cmd will be added to the Cgroup when it is started.
 func (c *Cgroup) Command(name string, arg ...string) *Cmd {
@@ -124,12 +428,230 @@ func (c *Cgroup) Wrap(cmd *exec.Cmd) *Cmd {
 	}
 }
 
+// CommandContext is like Command, but includes a context. When the context is done, all processes
+// within the Cgroup are sent the Cmd's CancelSignal.
+func (c *Cgroup) CommandContext(ctx context.Context, name string, arg ...string) *Cmd {
+	cmd := c.Command(name, arg...)
+	cmd.ctx = ctx
+	return cmd
+}
+
 // Limit applies Cgroup resource limits to a running process by its pid.
 func (c *Cgroup) Limit(pid int) error {
-	return c.cgroup.Add(cgroups.Process{Pid: pid})
+	if err := c.cgroup.Add(cgroups.Process{Pid: pid}); err != nil {
+		return err
+	}
+	c.limitUnified(pid)
+	c.startBudget()
+	return nil
+}
+
+// LimitProcess is like Limit, but checks that the process of h has not exited (and its pid
+// has not been reused by another process) before and after it is added to the Cgroup. If
+// it has, a *ProcessGoneError is returned. If the pid was reused, the process that reused
+// it may have been added in its place, and is not moved back out of the Cgroup.
+func (c *Cgroup) LimitProcess(h *ProcessHandle) error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	if err := c.Limit(h.Pid); err != nil {
+		if goneErr := h.check(); goneErr != nil {
+			return goneErr
+		}
+		return err
+	}
+	return h.check()
 }
 
-// Close deletes the Cgroup definition from the filesystem.
+// startBudget starts enforcing the budgets of the Cgroup and its ancestors
+func (c *Cgroup) startBudget() {
+	c.budget.start(c.Stats, func() error {
+		return c.Signal(os.Kill)
+	})
+	if c.parent != nil {
+		c.parent.startBudget()
+	}
+}
+
+// Processes returns the pids of all processes within the Cgroup (including nested cgroups).
+func (c *Cgroup) Processes() ([]int, error) {
+	subsystems := c.cgroup.Subsystems()
+	if len(subsystems) == 0 {
+		return nil, errors.New("cgroup has no subsystems")
+	}
+	procs, err := c.cgroup.Processes(subsystems[0].Name(), true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list processes")
+	}
+	pids := make([]int, len(procs))
+	for i, p := range procs {
+		pids[i] = p.Pid
+	}
+	return pids, nil
+}
+
+// Signal sends sig to all processes within the Cgroup. If the freezer subsystem is available,
+// the Cgroup is frozen while the signals are sent, so that processes cannot escape by forking.
+func (c *Cgroup) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.Errorf("unsupported signal %v", sig)
+	}
+	if err := c.cgroup.Freeze(); err == nil {
+		defer c.cgroup.Thaw()
+	}
+	pids, err := c.Processes()
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, s); err != nil && err != syscall.ESRCH {
+			return errors.Wrapf(err, "failed to signal process %d", pid)
+		}
+	}
+	return nil
+}
+
+// unlimitedMemoryThreshold is the memory limit at or above which a cgroup is considered unlimited
+const unlimitedMemoryThreshold = 1 << 62
+
+// Stats returns the combined resource usage of all processes within the Cgroup.
+func (c *Cgroup) Stats() (*Stats, error) {
+	metrics, err := c.cgroup.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read cgroup stats")
+	}
+	stats := &Stats{}
+	if cpu := metrics.CPU; cpu != nil {
+		if cpu.Usage != nil {
+			stats.CPUUsage = time.Duration(cpu.Usage.Total)
+			stats.UserCPU = time.Duration(cpu.Usage.User)
+			stats.SystemCPU = time.Duration(cpu.Usage.Kernel)
+		}
+		if cpu.Throttling != nil {
+			stats.ThrottledTime = time.Duration(cpu.Throttling.ThrottledTime)
+			stats.CPUPeriods = cpu.Throttling.Periods
+			stats.ThrottledPeriods = cpu.Throttling.ThrottledPeriods
+		}
+	}
+	if metrics.Memory != nil && metrics.Memory.Usage != nil {
+		stats.MemoryUsage = Memory(metrics.Memory.Usage.Usage)
+		stats.MemoryMaxUsage = Memory(metrics.Memory.Usage.Max)
+		// An unlimited cgroup reports a limit close to the maximum int64, rounded to the page size
+		if limit := metrics.Memory.Usage.Limit; limit < unlimitedMemoryThreshold {
+			stats.MemoryLimit = Memory(limit)
+		}
+	}
+	if metrics.Blkio != nil {
+		for _, entry := range metrics.Blkio.IoServiceBytesRecursive {
+			switch entry.Op {
+			case "Read":
+				stats.IOReadBytes += entry.Value
+			case "Write":
+				stats.IOWriteBytes += entry.Value
+			}
+		}
+	}
+	if oomKills, err := c.oomKills(); err == nil {
+		stats.OOMKills = oomKills
+	}
+	if metrics.Pids != nil {
+		stats.Processes = int(metrics.Pids.Current)
+	} else if pids, err := c.Processes(); err == nil {
+		stats.Processes = len(pids)
+	}
+	return stats, nil
+}
+
+// subsystemPath returns the directory of the Cgroup within the hierarchy of the
+// named subsystem, if that subsystem is mounted.
+func (c *Cgroup) subsystemPath(name cgroups.Name) (string, bool) {
+	for _, s := range c.cgroup.Subsystems() {
+		if s.Name() != name {
+			continue
+		}
+		if p, ok := s.(interface{ Path(string) string }); ok {
+			return p.Path("/" + c.Name), true
+		}
+	}
+	return "", false
+}
+
+// oomKills reads the number of processes killed by the OOM killer from memory.oom_control.
+// The counter is only reported by Linux 4.13 and later.
+func (c *Cgroup) oomKills() (uint64, error) {
+	dir, ok := c.subsystemPath(cgroups.Memory)
+	if !ok {
+		return 0, errors.New("memory subsystem is not mounted")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, errors.New("memory.oom_control does not report oom_kill")
+}
+
+// Update changes the resource limits of the Cgroup while it is running. Options that do
+// not modify resources (such as WithName or WithCPUTimeBudget) have no effect.
+func (c *Cgroup) Update(options ...Option) error {
+	resources := &specs.LinuxResources{}
+	if c.LinuxResources != nil {
+		// Copy the current resources, so they are unchanged if the update fails
+		data, err := json.Marshal(c.LinuxResources)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, resources); err != nil {
+			return err
+		}
+	}
+	updated := &Cgroup{LinuxResources: resources}
+	for _, opt := range options {
+		opt(updated)
+	}
+	if err := c.cgroup.Update(updated.LinuxResources); err != nil {
+		return errors.Wrap(err, "failed to update cgroup")
+	}
+	c.LinuxResources = updated.LinuxResources
+	return nil
+}
+
+// Close deletes the Cgroup definition (including any children) from the filesystem.
 func (c *Cgroup) Close() error {
-	return c.cgroup.Delete()
+	c.budget.stop()
+	if err := c.cgroup.Delete(); err != nil {
+		return err
+	}
+	unifiedErr := c.removeUnified()
+	if c.parent != nil || !c.recorded {
+		return unifiedErr
+	}
+	if err := removeMetadata(c.Name); err != nil {
+		return err
+	}
+	return unifiedErr
+}
+
+func (c *Cgroup) admit(ctx context.Context) (func(), error) {
+	if c.admission == nil {
+		return func() {}, nil
+	}
+	return c.admission.admit(ctx, c.Stats)
+}
+
+// budgetExceeded returns the error of the first exhausted budget of the Cgroup or its
+// ancestors, as the budgets of an ancestor also kill the processes of its children
+func (c *Cgroup) budgetExceeded() error {
+	for g := c; g != nil; g = g.parent {
+		if err := g.budget.err(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -3,8 +3,17 @@
 package proclimit_test
 
 import (
+	"context"
 	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/proclimittest"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
 )
 
 // TODO: add integration tests
@@ -27,3 +36,394 @@ func ExampleNew() {
 		// handle err
 	}
 }
+
+func TestExistingRestoresResources(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	created, err := proclimit.New(
+		proclimit.WithName("test"),
+		proclimit.WithCPULimit(50),
+		proclimit.WithMemoryLimit(proclimit.Gigabyte),
+		proclimit.WithPidsLimit(10),
+		proclimit.WithCPUSet("0-1"),
+		proclimit.WithIOLimit(proclimit.IOLimit{Major: 8, Minor: 0, ReadBPS: proclimit.Megabyte}),
+		proclimit.WithCPUTimeBudget(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer created.Close()
+	if err := created.Update(proclimit.WithMemoryLimit(2 * proclimit.Gigabyte)); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := proclimit.Existing("test")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if !reflect.DeepEqual(existing.LinuxResources, created.LinuxResources) {
+		t.Errorf("expected resources %+v, but got %+v", created.LinuxResources, existing.LinuxResources)
+	}
+
+	m := existing.Metadata()
+	if m == nil {
+		t.Fatalf("expected metadata to be recorded")
+	}
+	if m.Creator == nil || m.Creator.Pid != os.Getpid() {
+		t.Errorf("expected the creator to be pid %d, but got %+v", os.Getpid(), m.Creator)
+	}
+	if time.Since(m.CreatedAt) > time.Minute {
+		t.Errorf("expected the creation time to be recorded, but got %v", m.CreatedAt)
+	}
+	if m.CPUTimeBudget != time.Minute {
+		t.Errorf("expected a CPU time budget of 1m, but got %v", m.CPUTimeBudget)
+	}
+	if *m.Resources.Memory.Limit != int64(proclimit.Gigabyte) {
+		t.Errorf("expected the original memory limit of 1Gi, but got %d", *m.Resources.Memory.Limit)
+	}
+}
+
+func TestListSelector(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	for name, labels := range map[string]map[string]string{
+		"infra-nightly": {"team": "infra", "pipeline": "nightly"},
+		"infra-adhoc":   {"team": "infra"},
+		"web-nightly":   {"team": "web", "pipeline": "nightly"},
+		"unlabelled":    nil,
+	} {
+		cgroup, err := proclimit.New(proclimit.WithName(name), proclimit.WithLabels(labels))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cgroup.Close()
+	}
+
+	for _, tt := range []struct {
+		selector proclimit.Selector
+		expected []string
+	}{
+		{"", []string{"infra-adhoc", "infra-nightly", "unlabelled", "web-nightly"}},
+		{"team=infra", []string{"infra-adhoc", "infra-nightly"}},
+		{"team=infra,pipeline", []string{"infra-nightly"}},
+		{"!team", []string{"unlabelled"}},
+		{"team!=infra", []string{"unlabelled", "web-nightly"}},
+		{"team=ops", nil},
+	} {
+		t.Run(string(tt.selector), func(t *testing.T) {
+			names, err := proclimit.List(tt.selector)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, names)
+			}
+		})
+	}
+
+	if _, err := proclimit.New(proclimit.WithLabels(map[string]string{"team": "a,b"})); err == nil {
+		t.Errorf("expected invalid labels to be rejected")
+	}
+}
+
+func TestLimitProcess(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cgroup, err := proclimit.New(proclimit.WithName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cgroup.Close()
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	h, err := proclimit.OpenProcess(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Release()
+	if err := cgroup.LimitProcess(h); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	pids, err := fs.Processes("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pids, []int{cmd.Process.Pid}) {
+		t.Errorf("expected process %d to be limited, but got %v", cmd.Process.Pid, pids)
+	}
+
+	// An exited process that has not been waited for is a zombie
+	cmd.Process.Kill()
+	time.Sleep(100 * time.Millisecond)
+	err = cgroup.LimitProcess(h)
+	if goneErr, ok := err.(*proclimit.ProcessGoneError); !ok || goneErr.Pid != cmd.Process.Pid || goneErr.Reused {
+		t.Errorf("expected a *ProcessGoneError for a zombie, but got: %v", err)
+	}
+	if _, err := proclimit.OpenProcess(cmd.Process.Pid); err == nil {
+		t.Errorf("expected an error opening a zombie")
+	}
+
+	cmd.Wait()
+	err = cgroup.LimitProcess(h)
+	if _, ok := err.(*proclimit.ProcessGoneError); !ok {
+		t.Errorf("expected a *ProcessGoneError for a reaped process, but got: %v", err)
+	}
+	_, err = proclimit.OpenProcess(cmd.Process.Pid)
+	if _, ok := err.(*proclimit.ProcessGoneError); !ok {
+		t.Errorf("expected a *ProcessGoneError opening a reaped process, but got: %v", err)
+	}
+}
+
+func TestPressure(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cgroup, err := proclimit.New(proclimit.WithName("test"), proclimit.WithPressure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cgroup.Close()
+
+	memory := proclimit.ResourcePressure{
+		Some: proclimit.PressureAverages{Avg10: 12.5, Avg60: 4.25, Avg300: 1, Total: 3 * time.Second},
+		Full: proclimit.PressureAverages{Avg10: 2, Total: 500 * time.Millisecond},
+	}
+	for _, resource := range []proclimit.PressureResource{proclimit.PressureCPU, proclimit.PressureMemory, proclimit.PressureIO} {
+		pressure := proclimit.ResourcePressure{}
+		if resource == proclimit.PressureMemory {
+			pressure = memory
+		}
+		if err := fs.SetPressure("test", resource, pressure); err != nil {
+			t.Fatal(err)
+		}
+	}
+	existing, err := proclimit.Existing("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pressure, err := existing.Pressure()
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if expected := (proclimit.Pressure{Memory: memory}); *pressure != expected {
+		t.Errorf("expected %+v, but got %+v", expected, *pressure)
+	}
+
+	trigger := proclimit.PressureTrigger{Resource: proclimit.PressureMemory, Stall: 100 * time.Millisecond, Window: time.Second}
+	if s := trigger.String(); s != "some 100000 1000000" {
+		t.Errorf("expected trigger \"some 100000 1000000\", but got %q", s)
+	}
+	for _, trigger := range []proclimit.PressureTrigger{
+		{Resource: "disk", Stall: 100 * time.Millisecond, Window: time.Second},
+		{Resource: proclimit.PressureMemory, Stall: 100 * time.Millisecond, Window: 100 * time.Millisecond},
+		{Resource: proclimit.PressureMemory, Stall: 2 * time.Second, Window: time.Second},
+	} {
+		if _, err := cgroup.WatchPressure(context.Background(), trigger); err == nil {
+			t.Errorf("expected trigger %+v to be rejected", trigger)
+		}
+	}
+}
+
+func TestPressureIsOptIn(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cgroup, err := proclimit.New(proclimit.WithName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cgroup.Close()
+	if _, err := os.Stat(fs.Path("unified", "test")); !os.IsNotExist(err) {
+		t.Errorf("expected no cgroup v2 directory without WithPressure, but got: %v", err)
+	}
+	if _, err := cgroup.Pressure(); err == nil {
+		t.Error("expected Pressure to fail without WithPressure")
+	}
+}
+
+func TestPressureRemovesNestedCgroups(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cgroup, err := proclimit.New(proclimit.WithName("test"), proclimit.WithPressure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cgroup.NewChild(proclimit.WithName("child")); err != nil {
+		cgroup.Close()
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fs.Path("unified", "test/child")); err != nil {
+		t.Errorf("expected children to be created in the cgroup v2 hierarchy, but got: %v", err)
+	}
+	if err := cgroup.Close(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if _, err := os.Stat(fs.Path("unified", "test")); !os.IsNotExist(err) {
+		t.Errorf("expected the cgroup v2 directory to be removed, but got: %v", err)
+	}
+}
+
+func TestWithMemoryLimitClampsOverflow(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cgroup, err := proclimit.New(proclimit.WithName("test"), proclimit.WithMemoryLimit(1<<64-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cgroup.Close()
+	if limit, _ := fs.ReadFile("memory", "test", "memory.limit_in_bytes"); limit != "9223372036854775807" {
+		t.Errorf("expected memory.limit_in_bytes 9223372036854775807, but got %q", limit)
+	}
+}
+
+func TestNewWithUnwritableStateDir(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	// A file in place of the state directory cannot be written to, even by root
+	if err := ioutil.WriteFile(fs.StateDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cgroup, err := proclimit.New(proclimit.WithName("test"))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if _, err := os.Stat(fs.Path("memory", "test")); err != nil {
+		t.Errorf("expected the cgroup to be created, but got: %v", err)
+	}
+	if err := cgroup.Close(); err != nil {
+		t.Errorf("expected no error, but got: %v", err)
+	}
+}
+
+func TestNewExistingNameKeepsMetadata(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	live, err := proclimit.New(proclimit.WithName("test"), proclimit.WithLabels(map[string]string{"app": "live"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	// A directory in place of a controller file cannot be written to, even by root
+	if err := os.Mkdir(filepath.Join(fs.Path("memory", "test"), "memory.limit_in_bytes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := proclimit.New(proclimit.WithName("test"), proclimit.WithMemoryLimit(proclimit.Gigabyte)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	names, err := proclimit.List("app=live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "test" {
+		t.Errorf("expected the metadata of the live cgroup to be kept, but got %v", names)
+	}
+}
+
+func TestWithParentCgroups(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	cgroup, err := proclimit.New(proclimit.WithName("test"), proclimit.WithPidsLimit(10), proclimit.WithParentCgroups(map[string]string{
+		"pids":   "/user.slice/user-1000.slice",
+		"memory": "/user.slice",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := fs.ReadFile("pids", "user.slice/user-1000.slice/test", "pids.max"); limit != "10" {
+		t.Errorf("expected the cgroup to be created within its parent, but got pids.max %q", limit)
+	}
+	if _, err := os.Stat(fs.Path("memory", "user.slice/test")); err != nil {
+		t.Errorf("expected the cgroup to be created within its parent, but got: %v", err)
+	}
+	// Hierarchies in which the cgroup has no parent are not used
+	if _, err := os.Stat(fs.Path("cpu", "test")); !os.IsNotExist(err) {
+		t.Errorf("expected no cgroup at the root of the hierarchy, but got: %v", err)
+	}
+	names, err := proclimit.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected the cgroup not to be recorded, but got %v", names)
+	}
+	if err := cgroup.Close(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if _, err := os.Stat(fs.Path("pids", "user.slice/user-1000.slice/test")); !os.IsNotExist(err) {
+		t.Errorf("expected the cgroup to be removed, but got: %v", err)
+	}
+}
+
+func TestExistingWithUnreadableState(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	created, err := proclimit.New(proclimit.WithName("test"), proclimit.WithMemoryLimit(proclimit.Gigabyte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer created.Close()
+	if err := ioutil.WriteFile(filepath.Join(fs.StateDir, "test.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	limit := filepath.Join(fs.Path("memory", "test"), "memory.limit_in_bytes")
+	if err := os.Remove(limit); err != nil {
+		t.Fatal(err)
+	}
+	// A directory in place of a controller file cannot be read, even by root
+	if err := os.Mkdir(limit, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, err := proclimit.Existing("test")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if existing.Metadata() != nil {
+		t.Errorf("expected no metadata, but got %+v", existing.Metadata())
+	}
+	if existing.LinuxResources == nil || existing.LinuxResources.Memory != nil {
+		t.Errorf("expected no resources, but got %+v", existing.LinuxResources)
+	}
+}
@@ -0,0 +1,168 @@
+// Package client provides a client for the limiters managed by a proclimit daemon (see
+// the daemon package, and proclimit serve). It allows unprivileged processes to limit
+// their commands.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/daemon"
+	"github.com/friendsofgo/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Client is a client for a proclimit daemon
+type Client struct {
+	http *http.Client
+}
+
+// New creates a Client that connects to the daemon listening on the Unix socket at
+// socketPath (typically daemon.DefaultSocketPath)
+func New(socketPath string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// Create creates a limiter within the daemon
+func (c *Client) Create(req daemon.CreateRequest) (*Limiter, error) {
+	var resp daemon.CreateResponse
+	if err := c.do(http.MethodPost, "/v1/limiters", req, &resp); err != nil {
+		return nil, errors.Wrap(err, "failed to create limiter")
+	}
+	return c.Limiter(resp.Name), nil
+}
+
+// List returns the names of the limiters that the caller may manage
+func (c *Client) List() ([]string, error) {
+	var resp daemon.ListResponse
+	if err := c.do(http.MethodGet, "/v1/limiters", nil, &resp); err != nil {
+		return nil, errors.Wrap(err, "failed to list limiters")
+	}
+	return resp.Names, nil
+}
+
+// Limiter returns the named limiter of the daemon. It is not checked whether the
+// limiter exists.
+func (c *Client) Limiter(name string) *Limiter {
+	return &Limiter{Name: name, client: c}
+}
+
+func (c *Client) do(method, path string, reqBody, respBody interface{}) error {
+	var body bytes.Buffer
+	if reqBody != nil {
+		if err := json.NewEncoder(&body).Encode(reqBody); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, "http://proclimit"+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var errResp daemon.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return errors.Errorf("unexpected response: %s", resp.Status)
+		}
+		return errors.New(errResp.Error)
+	}
+	if respBody == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(respBody)
+}
+
+// Limiter is a limiter managed by a proclimit daemon. It implements proclimit.Limiter,
+// and can be used as the Limiter of a proclimit.Cmd.
+type Limiter struct {
+	Name   string
+	client *Client
+}
+
+func (l *Limiter) path(action string) string {
+	p := "/v1/limiters/" + url.PathEscape(l.Name)
+	if action != "" {
+		p += "/" + action
+	}
+	return p
+}
+
+// Command constructs a wrapped Cmd struct to execute the named program with the given
+// arguments. The Cmd will be added to the Limiter when it is started.
+func (l *Limiter) Command(name string, arg ...string) *proclimit.Cmd {
+	return &proclimit.Cmd{
+		Cmd:     exec.Command(name, arg...),
+		Limiter: l,
+	}
+}
+
+// Limit applies the limits of the Limiter to a running process by its pid. Unless the
+// caller is root, the process must belong to the caller.
+func (l *Limiter) Limit(pid int) error {
+	if err := l.client.do(http.MethodPost, l.path("limit"), daemon.LimitRequest{Pid: pid}, nil); err != nil {
+		return errors.Wrapf(err, "failed to limit process %d", pid)
+	}
+	return nil
+}
+
+// Update changes the limits of the Limiter. Limits that are not specified are left unchanged.
+func (l *Limiter) Update(limits proclimit.Profile) error {
+	if err := l.client.do(http.MethodPost, l.path("update"), daemon.UpdateRequest{Limits: limits}, nil); err != nil {
+		return errors.Wrap(err, "failed to update limiter")
+	}
+	return nil
+}
+
+// Stats returns the combined resource usage of all processes within the Limiter
+func (l *Limiter) Stats() (*proclimit.Stats, error) {
+	var stats proclimit.Stats
+	if err := l.client.do(http.MethodGet, l.path("stats"), nil, &stats); err != nil {
+		return nil, errors.Wrap(err, "failed to get stats")
+	}
+	return &stats, nil
+}
+
+// Signal sends sig to all processes within the Limiter
+func (l *Limiter) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.Errorf("unsupported signal %v", sig)
+	}
+	if err := l.client.do(http.MethodPost, l.path("signal"), daemon.SignalRequest{Signal: int(s)}, nil); err != nil {
+		return errors.Wrap(err, "failed to signal processes")
+	}
+	return nil
+}
+
+// KillAll kills all processes within the Limiter
+func (l *Limiter) KillAll() error {
+	return l.Signal(os.Kill)
+}
+
+// Close deletes the Limiter from the daemon
+func (l *Limiter) Close() error {
+	if err := l.client.do(http.MethodDelete, l.path(""), nil, nil); err != nil {
+		return errors.Wrap(err, "failed to close limiter")
+	}
+	return nil
+}
@@ -6,75 +6,109 @@ import (
 	"github.com/aoldershaw/proclimit"
 	"github.com/friendsofgo/errors"
 	"runtime"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 )
 
 type cmdArgs struct {
 	Name        string
 	CPULimit    uint
 	MemoryLimit proclimit.Memory
+	ConfigFile  string
+	Profile     string
+	OCIConfig   string
+	Timeout     time.Duration
+	KillAfter   time.Duration
+	CPUTime     time.Duration
+	WallTime    time.Duration
+	Report      reportFlag
+	SignalGroup bool
+	Record      string
+	Interval    time.Duration
+	Labels      labelsFlag
 
 	Path string
 	Args []string
 }
 
-func parseArgs() (cmdArgs, error) {
-	a := cmdArgs{}
-	var limiterName string
+// limiterName is the name of the type of limiter used on the current platform
+var limiterName = func() string {
 	switch runtime.GOOS {
 	case "windows":
-		limiterName = "job object"
+		return "job object"
 	case "linux":
-		limiterName = "cgroup"
+		return "cgroup"
 	default:
-		limiterName = "<<unknown>>"
-	}
-	flag.StringVar(&a.Name, "name", "", fmt.Sprintf("name of the %s. If not specified, a random name will be generated", limiterName))
-	flag.UintVar(&a.CPULimit, "cpu", 0, "maximum CPU percentage based on a single core (100 = 1 core)")
-	memoryString := flag.String("memory", "", "maximum memory usage in bytes (e.g. 1G)")
-	flag.Parse()
-	if memoryString != nil && *memoryString != "" {
-		var err error
-		a.MemoryLimit, err = parseMemory(*memoryString)
-		if err != nil {
-			return cmdArgs{}, err
-		}
+		return "<<unknown>>"
 	}
-	if flag.NArg() < 1 {
-		flag.Usage()
-		return cmdArgs{}, errors.Errorf("Usage: %s")
+}()
+
+// newFlagSet creates a FlagSet for a subcommand, including the flags that control
+// the output format. Parse errors are returned, rather than exiting the process.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: proclimit %s\n", commandUsage(name))
+		fs.PrintDefaults()
 	}
-	a.Path = flag.Arg(0)
-	a.Args = flag.Args()[1:]
-	return a, nil
+	return fs
 }
 
-// Supports 2G, 2M, 2K, or 2 (bytes)
-func parseMemory(memory string) (proclimit.Memory, error) {
-	var factor proclimit.Memory = 1
-	hasSuffix := false
-	if hasMemorySuffix(memory, "G") {
-		factor = proclimit.Gigabyte
-		hasSuffix = true
-	} else if hasMemorySuffix(memory, "M") {
-		factor = proclimit.Megabyte
-		hasSuffix = true
-	} else if hasMemorySuffix(memory, "K") {
-		factor = proclimit.Kilobyte
-		hasSuffix = true
+func parseRunArgs(args []string) (cmdArgs, error) {
+	a := cmdArgs{}
+	fs := newFlagSet("run")
+	fs.StringVar(&a.Name, "name", "", fmt.Sprintf("name of the %s. If not specified, a random name will be generated", limiterName))
+	fs.UintVar(&a.CPULimit, "cpu", 0, "maximum CPU percentage based on a single core (100 = 1 core)")
+	fs.Var(&a.MemoryLimit, "memory", "maximum memory usage (e.g. 1G, 512Mi, 100MB)")
+	fs.StringVar(&a.ConfigFile, "config", "", "path to a JSON or YAML file describing limiter profiles")
+	fs.StringVar(&a.Profile, "profile", "", "name of the profile in -config to apply. -cpu and -memory take precedence over the profile")
+	fs.StringVar(&a.OCIConfig, "oci-config", "", "path to an OCI runtime spec (config.json) or resources JSON document whose resources to apply")
+	fs.DurationVar(&a.Timeout, "timeout", 0, fmt.Sprintf("kill all processes in the %s if the command has not exited after this duration (e.g. 30s)", limiterName))
+	fs.DurationVar(&a.KillAfter, "kill-after", 0, "if set, processes are first sent SIGTERM on timeout, and are killed after this duration (not supported on windows)")
+	fs.DurationVar(&a.CPUTime, "cpu-time", 0, fmt.Sprintf("kill all processes in the %s once they have consumed this much CPU time in total (e.g. 30s)", limiterName))
+	fs.DurationVar(&a.WallTime, "wall-time", 0, fmt.Sprintf("kill all processes in the %s once this much time has passed since the command started (e.g. 2m)", limiterName))
+	fs.Var(&a.Report, "report", "print the command's resource usage when it exits. If a path is given (-report=usage.json), the usage is written to it as JSON")
+	fs.BoolVar(&a.SignalGroup, "signal-group", false, fmt.Sprintf("forward signals received by proclimit to every process in the %s, rather than only to the command", limiterName))
+	fs.StringVar(&a.Record, "record", "", fmt.Sprintf("sample the resource usage of the %s into this file while the command runs, as CSV (or JSON lines if it ends in .jsonl)", limiterName))
+	fs.DurationVar(&a.Interval, "interval", time.Second, "interval between samples for -record")
+	fs.Var(&a.Labels, "label", fmt.Sprintf("label the %s (key=value), so it can be selected with -l. May be repeated", limiterName))
+	if err := fs.Parse(args); err != nil {
+		return cmdArgs{}, err
+	}
+	if (a.ConfigFile == "") != (a.Profile == "") {
+		return cmdArgs{}, errors.New("-config and -profile must be specified together")
 	}
-	if hasSuffix {
-		memory = memory[0 : len(memory)-1]
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return cmdArgs{}, errors.New("no command specified")
 	}
-	num, err := strconv.ParseFloat(memory, 64)
-	if err != nil {
-		return 0, errors.Wrap(err, "invalid memory value")
+	a.Path = fs.Arg(0)
+	a.Args = fs.Args()[1:]
+	return a, nil
+}
+
+// labelsFlag is a flag that can be repeated to set labels (key=value)
+type labelsFlag map[string]string
+
+func (l *labelsFlag) String() string {
+	var labels []string
+	for key, value := range *l {
+		labels = append(labels, key+"="+value)
 	}
-	return proclimit.Memory(num * float64(factor)), nil
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
 }
 
-func hasMemorySuffix(s, letter string) bool {
-	uppercase := strings.ToUpper(s)
-	return strings.HasSuffix(uppercase, letter)
+func (l *labelsFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.Errorf("invalid label %q (expected key=value)", s)
+	}
+	if *l == nil {
+		*l = labelsFlag{}
+	}
+	(*l)[kv[0]] = kv[1]
+	return nil
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"time"
+)
+
+func batchCommand(args []string) error {
+	var (
+		jobsFile    string
+		parallel    int
+		name        string
+		cpuLimit    uint
+		memoryLimit proclimit.Memory
+		cpuTime     time.Duration
+		wallTime    time.Duration
+		jobLimits   jobLimitArgs
+		failFast    bool
+	)
+	fs := newFlagSet("batch")
+	fs.StringVar(&jobsFile, "f", "", "file listing the jobs to run, one per line ('-' for stdin)")
+	fs.IntVar(&parallel, "parallel", runtime.NumCPU(), "maximum number of jobs to run at once")
+	fs.StringVar(&name, "name", "", fmt.Sprintf("name of the %s shared by all jobs. If not specified, a random name will be generated", limiterName))
+	fs.UintVar(&cpuLimit, "cpu", 0, "maximum CPU percentage of all jobs combined, based on a single core (100 = 1 core)")
+	fs.Var(&memoryLimit, "memory", "maximum memory usage of all jobs combined (e.g. 8Gi)")
+	fs.DurationVar(&cpuTime, "cpu-time", 0, "kill all jobs once they have consumed this much CPU time in total (e.g. 1h)")
+	fs.DurationVar(&wallTime, "wall-time", 0, "kill all jobs once this much time has passed since the first job started (e.g. 30m)")
+	fs.UintVar(&jobLimits.cpu, "job-cpu", 0, "maximum CPU percentage of each job. May be overridden by -cpu in the jobs file")
+	fs.Var(&jobLimits.memory, "job-memory", "maximum memory usage of each job. May be overridden by -memory in the jobs file")
+	fs.BoolVar(&failFast, "fail-fast", false, "stop starting jobs once a job has failed. Running jobs are left to finish")
+	fs.Int64Var(&jobLimits.pids, "job-pids", 0, "maximum number of processes of each job. May be overridden by -pids in the jobs file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if jobsFile == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return exitStatus(2)
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	jobs, err := readJobsFile(jobsFile)
+	if err != nil {
+		return err
+	}
+
+	batch := &proclimit.Batch{
+		JobOptions: jobLimits.options(),
+		Parallel:   parallel,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		FailFast:   failFast,
+	}
+	if name != "" {
+		batch.Options = append(batch.Options, proclimit.WithName(name))
+	}
+	if cpuLimit > 0 {
+		batch.Options = append(batch.Options, proclimit.WithCPULimit(proclimit.Percent(cpuLimit)))
+	}
+	if memoryLimit > 0 {
+		batch.Options = append(batch.Options, proclimit.WithMemoryLimit(memoryLimit))
+	}
+	if cpuTime > 0 {
+		batch.Options = append(batch.Options, proclimit.WithCPUTimeBudget(cpuTime))
+	}
+	if wallTime > 0 {
+		batch.Options = append(batch.Options, proclimit.WithWallTimeout(wallTime))
+	}
+	if rec != nil {
+		// Keep stdout for the records
+		batch.Stdout = os.Stderr
+	}
+
+	// Jobs that have not started are skipped on an interrupt. Running jobs receive the
+	// signal from the terminal, or are killed once the batch's limiter is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	results, err := batch.Run(ctx, jobs)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	skipped := 0
+	for _, result := range results {
+		rec.record(newExitRecord("job", result.Job.Name, result.ProcessState, result.Usage, result.Err))
+		if result.Err == proclimit.ErrSkipped {
+			skipped++
+			continue
+		}
+		if !result.Failed() {
+			continue
+		}
+		reason := "OOM killed"
+		if !result.OOMKilled() {
+			reason = result.Err.Error()
+		}
+		failed = append(failed, fmt.Sprintf("%s: %s", result.Job.Name, reason))
+	}
+	if len(failed) > 0 {
+		log.Printf("%d of %d jobs failed:\n  %s", len(failed), len(results), strings.Join(failed, "\n  "))
+		if skipped > 0 {
+			log.Printf("%d jobs were skipped", skipped)
+		}
+		return exitStatus(1)
+	}
+	return nil
+}
+
+// jobLimitArgs are the limits of a single job
+type jobLimitArgs struct {
+	cpu    uint
+	memory proclimit.Memory
+	pids   int64
+}
+
+func (a jobLimitArgs) options() []proclimit.Option {
+	var opts []proclimit.Option
+	if a.cpu > 0 {
+		opts = append(opts, proclimit.WithCPULimit(proclimit.Percent(a.cpu)))
+	}
+	if a.memory > 0 {
+		opts = append(opts, proclimit.WithMemoryLimit(a.memory))
+	}
+	if a.pids > 0 {
+		opts = append(opts, proclimit.WithPidsLimit(a.pids))
+	}
+	return opts
+}
+
+// readJobsFile reads the jobs of a batch from path, or stdin if path is "-"
+func readJobsFile(path string) ([]proclimit.Job, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open jobs file")
+		}
+		defer f.Close()
+		r = f
+	}
+	jobs, err := parseJobs(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid jobs file %s", path)
+	}
+	return jobs, nil
+}
+
+// parseJobs parses a jobs file. Each line that is not empty or a comment (starting
+// with #) is a job. A line may start with flags that set the name and limits of the job,
+// and the remainder of the line is run by the shell, e.g.:
+//
+//	-name=shard-1 -memory=512Mi go test ./shard1/...
+func parseJobs(r io.Reader) ([]proclimit.Job, error) {
+	var jobs []proclimit.Job
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var (
+			flags  []string
+			limits jobLimitArgs
+			name   string
+		)
+		for strings.HasPrefix(line, "-") {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			flags = append(flags, line[:i])
+			line = strings.TrimSpace(line[i:])
+		}
+		fs := flag.NewFlagSet("job", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.StringVar(&name, "name", fmt.Sprintf("job-%d", len(jobs)+1), "")
+		fs.UintVar(&limits.cpu, "cpu", 0, "")
+		fs.Var(&limits.memory, "memory", "")
+		fs.Int64Var(&limits.pids, "pids", 0, "")
+		if err := fs.Parse(flags); err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNumber)
+		}
+		if line == "" {
+			return nil, errors.Errorf("line %d: no command specified", lineNumber)
+		}
+		jobs = append(jobs, proclimit.Job{
+			Name:    name,
+			Command: shellCommand(line),
+			Options: limits.options(),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// shellCommand returns the command that runs line with the platform's shell
+func shellCommand(line string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", line}
+	}
+	return []string{"sh", "-c", line}
+}
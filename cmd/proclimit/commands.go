@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// parseNameArgs parses flags and a single <name> argument, followed by at most nargs
+// further arguments. The name may be specified before or after the flags.
+func parseNameArgs(command string, args []string, nargs int, configure func(fs *flag.FlagSet)) (string, []string, error) {
+	return parseArgs(command, args, nargs, true, configure)
+}
+
+// parseOptionalNameArgs is like parseNameArgs, but the name may be omitted (in which case
+// it is "")
+func parseOptionalNameArgs(command string, args []string, nargs int, configure func(fs *flag.FlagSet)) (string, []string, error) {
+	return parseArgs(command, args, nargs, false, configure)
+}
+
+func parseArgs(command string, args []string, nargs int, requireName bool, configure func(fs *flag.FlagSet)) (string, []string, error) {
+	fs := newFlagSet(command)
+	if configure != nil {
+		configure(fs)
+	}
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+	rest := fs.Args()
+	if name == "" && len(rest) > 0 {
+		name, rest = rest[0], rest[1:]
+	}
+	if (requireName && name == "") || len(rest) > nargs {
+		fs.Usage()
+		return "", nil, exitStatus(2)
+	}
+	return name, rest, nil
+}
+
+func attachCommand(args []string) error {
+	var (
+		pid       int
+		recursive bool
+	)
+	name, rest, err := parseNameArgs("attach", args, 1, func(fs *flag.FlagSet) {
+		fs.IntVar(&pid, "pid", 0, "pid of the process to attach (may also be given as an argument)")
+		fs.BoolVar(&recursive, "recursive", false, "also attach all descendants of the process")
+	})
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		if pid != 0 {
+			return errors.New("the pid must be specified either with -pid or as an argument, not both")
+		}
+		if pid, err = strconv.Atoi(rest[0]); err != nil {
+			return errors.Errorf("invalid pid %q", rest[0])
+		}
+	}
+	if pid <= 0 {
+		fmt.Fprintf(os.Stderr, "Usage: proclimit %s\n", commandUsage("attach"))
+		return exitStatus(2)
+	}
+	var process *proclimit.ProcessHandle
+	if !recursive {
+		// The process is identified before anything else is done, so that LimitProcess does
+		// not confuse it with another process that reuses its pid in the meantime
+		if process, err = proclimit.OpenProcess(pid); err != nil {
+			return err
+		}
+		defer process.Release()
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	limiter, err := proclimit.Existing(name)
+	if err != nil {
+		return err
+	}
+	if recursive {
+		err = proclimit.LimitTree(limiter, pid)
+	} else {
+		err = limiter.LimitProcess(process)
+	}
+	if err != nil {
+		return err
+	}
+	rec.record(attachedRecord{Event: "attached", Name: name, Pid: pid, Recursive: recursive})
+	return nil
+}
+
+func listCommand(args []string) error {
+	var selector string
+	fs := newFlagSet("list")
+	fs.StringVar(&selector, "l", "", fmt.Sprintf("only list %ss whose labels match the selector (e.g. team=infra,pipeline)", limiterName))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitStatus(2)
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	names, err := proclimit.List(proclimit.Selector(selector))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if rec != nil {
+			rec.record(limiterRecord{Event: "limiter", Name: name})
+		} else {
+			fmt.Println(name)
+		}
+	}
+	return nil
+}
+
+func statsCommand(args []string) error {
+	var selector string
+	name, _, err := parseOptionalNameArgs("stats", args, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&selector, "l", "", fmt.Sprintf("show the stats of all %ss whose labels match the selector", limiterName))
+	})
+	if err != nil {
+		return err
+	}
+	if (name == "") == (selector == "") {
+		fmt.Fprintf(os.Stderr, "Usage: proclimit %s\n", commandUsage("stats"))
+		return exitStatus(2)
+	}
+	names := []string{name}
+	if selector != "" {
+		if names, err = proclimit.List(proclimit.Selector(selector)); err != nil {
+			return err
+		}
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	for i, name := range names {
+		limiter, err := proclimit.Existing(name)
+		if err != nil {
+			return err
+		}
+		stats, err := limiter.Stats()
+		if err != nil {
+			return err
+		}
+		if rec != nil {
+			rec.record(statsRecord{Event: "stats", Name: name, Stats: stats})
+			continue
+		}
+		if selector != "" {
+			// Several limiters may match, so each is headed by its name
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", name)
+		}
+		if err := printStats(stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printStats(stats *proclimit.Stats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "processes:\t%d\n", stats.Processes)
+	fmt.Fprintf(w, "cpu usage:\t%s\n", stats.CPUUsage)
+	fmt.Fprintf(w, "user cpu:\t%s\n", stats.UserCPU)
+	fmt.Fprintf(w, "system cpu:\t%s\n", stats.SystemCPU)
+	fmt.Fprintf(w, "throttled time:\t%s\n", stats.ThrottledTime)
+	fmt.Fprintf(w, "memory usage:\t%s\n", stats.MemoryUsage)
+	fmt.Fprintf(w, "peak memory usage:\t%s\n", stats.MemoryMaxUsage)
+	fmt.Fprintf(w, "io read:\t%s\n", proclimit.Memory(stats.IOReadBytes))
+	fmt.Fprintf(w, "io write:\t%s\n", proclimit.Memory(stats.IOWriteBytes))
+	fmt.Fprintf(w, "oom kills:\t%d\n", stats.OOMKills)
+	return w.Flush()
+}
+
+func updateCommand(args []string) error {
+	var (
+		cpuLimit    uint
+		memoryLimit proclimit.Memory
+		pidsLimit   int64
+		cpuSet      string
+	)
+	name, _, err := parseNameArgs("update", args, 0, func(fs *flag.FlagSet) {
+		fs.UintVar(&cpuLimit, "cpu", 0, "maximum CPU percentage based on a single core (100 = 1 core)")
+		fs.Var(&memoryLimit, "memory", "maximum memory usage (e.g. 1G, 512Mi, 100MB)")
+		fs.Int64Var(&pidsLimit, "pids", 0, "maximum number of processes")
+		fs.StringVar(&cpuSet, "cpuset", "", "CPUs the processes may run on (e.g. 0-3,6)")
+	})
+	if err != nil {
+		return err
+	}
+	var opts []proclimit.Option
+	if cpuLimit > 0 {
+		opts = append(opts, proclimit.WithCPULimit(proclimit.Percent(cpuLimit)))
+	}
+	if memoryLimit > 0 {
+		opts = append(opts, proclimit.WithMemoryLimit(memoryLimit))
+	}
+	if pidsLimit > 0 {
+		opts = append(opts, proclimit.WithPidsLimit(pidsLimit))
+	}
+	if cpuSet != "" {
+		opts = append(opts, proclimit.WithCPUSet(cpuSet))
+	}
+	if len(opts) == 0 {
+		return errors.New("no limits specified")
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	limiter, err := proclimit.Existing(name)
+	if err != nil {
+		return err
+	}
+	if err := limiter.Update(opts...); err != nil {
+		return err
+	}
+	rec.record(updatedRecord{Event: "updated", Name: name, Limits: appliedLimits(limiter)})
+	return nil
+}
+
+func deleteCommand(args []string) error {
+	name, _, err := parseNameArgs("delete", args, 0, nil)
+	if err != nil {
+		return err
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	limiter, err := proclimit.Existing(name)
+	if err != nil {
+		return err
+	}
+	if err := limiter.Close(); err != nil {
+		return err
+	}
+	rec.record(deletedRecord{Event: "deleted", Name: name})
+	return nil
+}
+
+func gcCommand(args []string) error {
+	var dryRun bool
+	fs := newFlagSet("gc")
+	fs.BoolVar(&dryRun, "dry-run", false, fmt.Sprintf("only report stale %ss, without removing them", limiterName))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitStatus(2)
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	var stale []proclimit.StaleLimiter
+	if dryRun {
+		stale, err = proclimit.FindStale()
+	} else {
+		stale, err = proclimit.CleanupStale()
+	}
+	if err != nil {
+		return err
+	}
+	failed := false
+	for _, s := range stale {
+		if s.Err != nil {
+			failed = true
+		}
+		if rec != nil {
+			r := staleRecord{Event: "stale", Name: s.Name, OwnerPid: s.OwnerPid, Processes: s.Processes, Removed: s.Removed}
+			if s.Err != nil {
+				r.Error = s.Err.Error()
+			}
+			rec.record(r)
+			continue
+		}
+		owner := fmt.Sprintf("owner %d exited", s.OwnerPid)
+		switch {
+		case s.Err != nil:
+			log.Println(s.Err)
+		case s.Removed:
+			fmt.Printf("removed %s (%s)\n", s.Name, owner)
+		case dryRun:
+			fmt.Printf("stale %s (%s): %d processes\n", s.Name, owner, s.Processes)
+		default:
+			fmt.Printf("kept %s (%s): %d processes still running\n", s.Name, owner, s.Processes)
+		}
+	}
+	if failed {
+		return exitStatus(1)
+	}
+	return nil
+}
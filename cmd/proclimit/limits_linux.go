@@ -0,0 +1,27 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/aoldershaw/proclimit"
+)
+
+// backendName is the name of the mechanism used to limit processes, as reported in JSON records
+const backendName = "cgroup-v1"
+
+// appliedLimits describes the limits of l in JSON records. On Linux, these are the OCI
+// LinuxResources of the cgroup.
+func appliedLimits(l interface{}) interface{} {
+	if c, ok := l.(*proclimit.Cgroup); ok {
+		return c.LinuxResources
+	}
+	return nil
+}
+
+// limiterNameOf returns the name of the limiter l
+func limiterNameOf(l interface{}) string {
+	if c, ok := l.(*proclimit.Cgroup); ok {
+		return c.Name
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+// +build windows
+
+package main
+
+import (
+	"github.com/aoldershaw/proclimit"
+)
+
+// backendName is the name of the mechanism used to limit processes, as reported in JSON records
+const backendName = "job-object"
+
+// jobObjectLimits is the JSON representation of the limits of a job object
+type jobObjectLimits struct {
+	ProcessMemoryLimitBytes uint64 `json:"processMemoryLimitBytes,omitempty"`
+	ActiveProcessLimit      uint32 `json:"activeProcessLimit,omitempty"`
+	Affinity                uint64 `json:"affinity,omitempty"`
+	// CPURate is the share of all CPUs, in hundredths of a percent
+	CPURate uint32 `json:"cpuRate,omitempty"`
+}
+
+// appliedLimits describes the limits of l in JSON records
+func appliedLimits(l interface{}) interface{} {
+	j, ok := l.(*proclimit.JobObject)
+	if !ok {
+		return nil
+	}
+	limits := jobObjectLimits{}
+	if info := j.ExtendedLimitInformation; info != nil {
+		limits.ProcessMemoryLimitBytes = uint64(info.ProcessMemoryLimit)
+		limits.ActiveProcessLimit = info.BasicLimitInformation.ActiveProcessLimit
+		limits.Affinity = uint64(info.BasicLimitInformation.Affinity)
+	}
+	if info := j.CPULimitInformation; info != nil {
+		limits.CPURate = info.CPURate
+	}
+	return limits
+}
+
+// limiterNameOf returns the name of the limiter l
+func limiterNameOf(l interface{}) string {
+	if j, ok := l.(*proclimit.JobObject); ok {
+		return j.Name
+	}
+	return ""
+}
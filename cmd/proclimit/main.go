@@ -1,65 +1,96 @@
 package main
 
 import (
-	"github.com/aoldershaw/proclimit"
+	"flag"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"runtime"
+	"strings"
 )
 
-func main() {
-	args, err := parseArgs()
-	if err != nil {
-		log.Fatal(err)
-	}
-	var opts []proclimit.Option
-	if args.Name != "" {
-		opts = append(opts, proclimit.WithName(args.Name))
-	}
-	if args.MemoryLimit > 0 {
-		opts = append(opts, proclimit.WithMemoryLimit(args.MemoryLimit))
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+// commands lists the subcommands of proclimit. If no subcommand is specified, run is used, so
+// programs whose name is a subcommand must be run with "proclimit run".
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[run] [flags] command [args...]", runCommand},
+		{"attach", "attach [flags] <name> [pid]", attachCommand},
+		{"list", "list [-l selector]", listCommand},
+		{"stats", "stats <name> | stats -l selector", statsCommand},
+		{"update", "update [flags] <name>", updateCommand},
+		{"delete", "delete <name>", deleteCommand},
+		{"gc", "gc [-dry-run]", gcCommand},
+		{"batch", "batch [flags] -f jobs.txt", batchCommand},
+		{"serve", "serve [-socket=PATH] [-socket-gid=GID] [-allow-uid=UIDS] [-allow-gid=GIDS]", serveCommand},
+		{"watch", "watch -rules=rules.yaml [-poll] [-poll-interval=DURATION]", watchCommand},
+		{"top", "top [-interval=DURATION] [-sort=COLUMN] [-once] [name]", topCommand},
+		{"serve-metrics", "serve-metrics -listen=ADDR [-prefix=PREFIX] [name...]", serveMetricsCommand},
 	}
-	if args.CPULimit > 0 {
-		cpuLimit := proclimit.Percent(args.CPULimit)
-		opts = append(opts, proclimit.WithCPULimit(cpuLimit))
+}
+
+func commandUsage(name string) string {
+	for _, c := range commands {
+		if c.name == name {
+			return c.usage
+		}
 	}
-	limiter, err := proclimit.New(opts...)
-	if err != nil {
-		log.Fatal(err)
+	return name
+}
+
+func usage() {
+	var lines []string
+	for _, c := range commands {
+		lines = append(lines, "proclimit "+c.usage)
 	}
-	defer func() {
-		if err != nil {
-			exitCode := 1
-			if exitErr, ok := err.(interface{ ExitCode() int }); ok {
-				exitCode = exitErr.ExitCode()
-			} else {
-				log.Println(err)
+	fmt.Fprintf(os.Stderr, "Usage: %s\n\nRun 'proclimit <command> -h' for the flags of a command.\n"+
+		"To run a program with the same name as a command, use 'proclimit run <program>'.\n", strings.Join(lines, "\n       "))
+}
+
+func main() {
+	args := os.Args[1:]
+	run := runCommand
+	if len(args) > 0 {
+		if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+			usage()
+			return
+		}
+		for _, c := range commands {
+			if c.name == args[0] {
+				run, args = c.run, args[1:]
+				break
 			}
-			os.Exit(exitCode)
 		}
-	}()
-	defer limiter.Close()
+	}
+	if err := run(args); err != nil {
+		os.Exit(exitCode(err))
+	}
+}
 
-	cmd := limiter.Command(args.Path, args.Args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+// exitStatus is an error that causes proclimit to exit with the given code, without logging
+type exitStatus int
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt)
-	go func() {
-		sig := <-sigs
-		if cmd.Process != nil {
-			// https://golang.org/pkg/os/#Signal
-			// "On Windows, sending os.Interrupt to a process with os.Process.Signal is not implemented"
-			if runtime.GOOS == "windows" {
-				cmd.Process.Kill()
-			} else {
-				cmd.Process.Signal(sig)
-			}
-		}
-	}()
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e exitStatus) ExitCode() int {
+	return int(e)
+}
 
-	err = cmd.Run()
+func exitCode(err error) int {
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+		return exitErr.ExitCode()
+	}
+	log.Println(err)
+	return 1
 }
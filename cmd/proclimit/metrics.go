@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/metrics"
+	"log"
+	"net/http"
+	"os"
+)
+
+func serveMetricsCommand(args []string) error {
+	var (
+		listen string
+		prefix string
+	)
+	fs := newFlagSet("serve-metrics")
+	fs.StringVar(&listen, "listen", "", "address to serve metrics on, at /metrics (required)")
+	fs.StringVar(&prefix, "prefix", "", fmt.Sprintf("export every %s created by proclimit whose name starts with this prefix. If no names are given, all are exported", limiterName))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// There is no default, as the ports commonly used by exporters are likely to be taken
+	if listen == "" {
+		fmt.Fprintf(os.Stderr, "Usage: proclimit %s\n", commandUsage("serve-metrics"))
+		return exitStatus(2)
+	}
+	exporter := metrics.NewExporter()
+	for _, name := range fs.Args() {
+		limiter, err := proclimit.Existing(name)
+		if err != nil {
+			return err
+		}
+		exporter.Register(name, limiter)
+	}
+	if fs.NArg() == 0 || prefix != "" {
+		exporter.Discover(prefix)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	log.Printf("serving metrics on %s/metrics", listen)
+	return http.ListenAndServe(listen, mux)
+}
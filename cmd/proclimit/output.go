@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// outputOptions are the flags, shared by all commands, that control whether proclimit
+// writes machine-readable records, and where they are written to
+type outputOptions struct {
+	format string
+	file   string
+	fd     int
+}
+
+var output outputOptions
+
+func (o *outputOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.format, "output", "text", "output format, either text or json. JSON records are written one per line")
+	fs.StringVar(&o.file, "output-file", "", "with -output=json, path to write records to. Records are appended if the file exists")
+	fs.IntVar(&o.fd, "output-fd", 0, "with -output=json, file descriptor to write records to (e.g. 3)")
+}
+
+// open validates the output flags, and opens the destination of JSON records. In text
+// mode, it returns a nil recorder. If neither -output-file nor -output-fd is specified,
+// records are written to defaultOut. If defaultOut is nil, one of them is required.
+func (o *outputOptions) open(defaultOut io.Writer) (*recorder, error) {
+	switch o.format {
+	case "text":
+		return nil, nil
+	case "json":
+	default:
+		return nil, errors.Errorf("unknown output format %q, expected text or json", o.format)
+	}
+	switch {
+	case o.file != "" && o.fd != 0:
+		return nil, errors.New("-output-file and -output-fd cannot be specified together")
+	case o.file != "":
+		f, err := os.OpenFile(o.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open output file")
+		}
+		return &recorder{enc: json.NewEncoder(f), closer: f}, nil
+	case o.fd != 0:
+		f := os.NewFile(uintptr(o.fd), "output")
+		if f == nil {
+			return nil, errors.Errorf("invalid output file descriptor %d", o.fd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, errors.Wrapf(err, "invalid output file descriptor %d", o.fd)
+		}
+		return &recorder{enc: json.NewEncoder(f), closer: f}, nil
+	case defaultOut == nil:
+		return nil, errors.New("-output=json requires -output-file or -output-fd, so that records are kept separate from the output of the command")
+	default:
+		return &recorder{enc: json.NewEncoder(defaultOut)}, nil
+	}
+}
+
+// recorder writes JSON records, one per line. All methods are no-ops on a nil recorder,
+// which is used in text mode.
+type recorder struct {
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// record writes a single record. Records are structs whose first field is the Event.
+func (r *recorder) record(v interface{}) {
+	if r == nil {
+		return
+	}
+	if err := r.enc.Encode(v); err != nil {
+		log.Printf("failed to write output record: %v", err)
+	}
+}
+
+func (r *recorder) close() error {
+	if r == nil || r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// createdRecord is written when run creates a limiter
+type createdRecord struct {
+	Event   string `json:"event"`
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+	// Limits is the platform-specific description of the applied limits
+	Limits                interface{} `json:"limits"`
+	CPUTimeBudgetSeconds  float64     `json:"cpuTimeBudgetSeconds,omitempty"`
+	WallTimeBudgetSeconds float64     `json:"wallTimeBudgetSeconds,omitempty"`
+}
+
+// exitRecord is written when the command started by run (or a job started by batch) exits,
+// or fails to start
+type exitRecord struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+	Pid   int    `json:"pid,omitempty"`
+	// ExitCode is nil if the command did not start, or was killed by a signal
+	ExitCode *int `json:"exitCode"`
+	// Signal is the number of the signal that killed the command
+	Signal int `json:"signal,omitempty"`
+	// OOMKilled is true if any process in the limiter was killed for exceeding the memory limit
+	OOMKilled      bool   `json:"oomKilled"`
+	TimedOut       bool   `json:"timedOut"`
+	BudgetExceeded string `json:"budgetExceeded,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// newExitRecord describes how a command exited, given its state after exiting (nil if it
+// did not start) and the error returned by Cmd.Wait
+func newExitRecord(event, name string, state *os.ProcessState, usage *proclimit.Usage, err error) exitRecord {
+	r := exitRecord{Event: event, Name: name}
+	if state != nil {
+		r.Pid = state.Pid()
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			r.Signal = int(status.Signal())
+		} else {
+			code := state.ExitCode()
+			r.ExitCode = &code
+		}
+	}
+	if usage != nil {
+		r.OOMKilled = usage.OOMKills > 0
+	}
+	switch e := err.(type) {
+	case nil, *exec.ExitError:
+	case *proclimit.BudgetExceededError:
+		r.BudgetExceeded = e.Budget
+		r.Error = e.Error()
+	default:
+		r.TimedOut = err == context.DeadlineExceeded
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// usageRecord is written with the resource usage of the command started by run
+type usageRecord struct {
+	Event string           `json:"event"`
+	Name  string           `json:"name"`
+	Usage *proclimit.Usage `json:"usage"`
+}
+
+// limiterRecord is written by list for each limiter
+type limiterRecord struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+}
+
+// statsRecord is written by stats
+type statsRecord struct {
+	Event string           `json:"event"`
+	Name  string           `json:"name"`
+	Stats *proclimit.Stats `json:"stats"`
+}
+
+// attachedRecord is written by attach
+type attachedRecord struct {
+	Event     string `json:"event"`
+	Name      string `json:"name"`
+	Pid       int    `json:"pid"`
+	Recursive bool   `json:"recursive"`
+}
+
+// updatedRecord is written by update, with the limits that were changed
+type updatedRecord struct {
+	Event  string      `json:"event"`
+	Name   string      `json:"name"`
+	Limits interface{} `json:"limits"`
+}
+
+// deletedRecord is written by delete
+type deletedRecord struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+}
+
+// staleRecord is written by gc for every stale limiter
+type staleRecord struct {
+	Event     string `json:"event"`
+	Name      string `json:"name"`
+	OwnerPid  int    `json:"ownerPid,omitempty"`
+	Processes int    `json:"processes"`
+	Removed   bool   `json:"removed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// limitedRecord is written by watch whenever a process matches a rule
+type limitedRecord struct {
+	Event string `json:"event"`
+	Name  string `json:"name"`
+	Pid   int    `json:"pid"`
+	Exe   string `json:"exe"`
+	Error string `json:"error,omitempty"`
+}
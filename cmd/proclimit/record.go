@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// usageRecorder samples the stats of a limiter into a file. Files ending in .json or .jsonl
+// are written as JSON lines, and any others as CSV.
+type usageRecorder struct {
+	file   *os.File
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func startUsageRecorder(path string, interval time.Duration, stats func() (*proclimit.Stats, error)) (*usageRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create usage record")
+	}
+	var w proclimit.SampleWriter
+	switch filepath.Ext(path) {
+	case ".json", ".jsonl":
+		w = proclimit.NewJSONSampleWriter(f)
+	default:
+		w = proclimit.NewCSVSampleWriter(f)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &usageRecorder{file: f, cancel: cancel, done: make(chan error, 1)}
+	sampler := &proclimit.Sampler{Stats: stats, Interval: interval, Writer: w}
+	go func() {
+		r.done <- sampler.Run(ctx)
+	}()
+	return r, nil
+}
+
+// stop takes a final sample, and closes the file
+func (r *usageRecorder) stop() {
+	r.cancel()
+	if err := <-r.done; err != nil {
+		log.Printf("failed to record usage: %v", err)
+	}
+	r.file.Close()
+}
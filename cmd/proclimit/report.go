@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"io"
+	"io/ioutil"
+)
+
+// reportFlag is the value of the -report flag. It can be specified without a
+// value to print the report, or with a path to write the report to as JSON.
+type reportFlag struct {
+	enabled bool
+	path    string
+}
+
+func (r *reportFlag) String() string {
+	return r.path
+}
+
+func (r *reportFlag) Set(value string) error {
+	r.enabled = value != "false"
+	r.path = ""
+	if value != "true" && value != "false" {
+		r.path = value
+	}
+	return nil
+}
+
+func (r *reportFlag) IsBoolFlag() bool {
+	return true
+}
+
+// writeReport prints u to w, or writes it as JSON to the path of the flag
+func (r *reportFlag) writeReport(w io.Writer, u *proclimit.Usage) error {
+	if r.path == "" {
+		_, err := fmt.Fprintf(w,
+			"wall time: %s\nuser time: %s\nsystem time: %s\npeak memory: %s\nthrottled time: %s\nio read: %s\nio write: %s\n",
+			u.WallTime, u.UserTime, u.SystemTime, u.PeakMemory,
+			u.ThrottledTime, proclimit.Memory(u.IOReadBytes), proclimit.Memory(u.IOWriteBytes),
+		)
+		return err
+	}
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// limiter is implemented by the limiters of all platforms
+type limiter interface {
+	Command(name string, arg ...string) *proclimit.Cmd
+	CommandContext(ctx context.Context, name string, arg ...string) *proclimit.Cmd
+	Signal(sig os.Signal) error
+	Stats() (*proclimit.Stats, error)
+	Close() error
+}
+
+func runCommand(cliArgs []string) (err error) {
+	args, err := parseRunArgs(cliArgs)
+	if err != nil {
+		return err
+	}
+	rec, err := output.open(nil)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	var opts []proclimit.Option
+	if args.Profile != "" {
+		opts, err = profileOptions(args.ConfigFile, args.Profile)
+		if err != nil {
+			return err
+		}
+	}
+	if args.Name != "" {
+		opts = append(opts, proclimit.WithName(args.Name))
+	}
+	if args.MemoryLimit > 0 {
+		opts = append(opts, proclimit.WithMemoryLimit(args.MemoryLimit))
+	}
+	if args.CPULimit > 0 {
+		cpuLimit := proclimit.Percent(args.CPULimit)
+		opts = append(opts, proclimit.WithCPULimit(cpuLimit))
+	}
+	if args.CPUTime > 0 {
+		opts = append(opts, proclimit.WithCPUTimeBudget(args.CPUTime))
+	}
+	if args.WallTime > 0 {
+		opts = append(opts, proclimit.WithWallTimeout(args.WallTime))
+	}
+	if len(args.Labels) > 0 {
+		opts = append(opts, proclimit.WithLabels(args.Labels))
+	}
+	// The limiter is closed when the command exits, so it is only left behind if proclimit
+	// is killed - in which case proclimit gc removes it
+	opts = append(opts, proclimit.WithOwned())
+	var limiter limiter
+	if args.OCIConfig != "" {
+		limiter, err = ociLimiter(args.OCIConfig, opts)
+	} else {
+		limiter, err = proclimit.New(opts...)
+	}
+	if err != nil {
+		return err
+	}
+	defer limiter.Close()
+	rec.record(createdRecord{
+		Event:                 "created",
+		Name:                  limiterNameOf(limiter),
+		Backend:               backendName,
+		Limits:                appliedLimits(limiter),
+		CPUTimeBudgetSeconds:  args.CPUTime.Seconds(),
+		WallTimeBudgetSeconds: args.WallTime.Seconds(),
+	})
+
+	ctx := context.Background()
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
+	cmd := limiter.CommandContext(ctx, args.Path, args.Args...)
+	// Only os.Kill can be sent to all processes of a job object on Windows
+	if args.KillAfter > 0 && runtime.GOOS != "windows" {
+		cmd.CancelSignal = syscall.SIGTERM
+		cmd.CancelGracePeriod = args.KillAfter
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	signals := newSignalForwarder()
+	defer signals.stop()
+	if err := cmd.Start(); err != nil {
+		rec.record(exitRecord{Event: "exit", Name: limiterNameOf(limiter), Error: err.Error()})
+		return err
+	}
+	if args.SignalGroup {
+		signals.start(limiter.Signal)
+	} else {
+		signals.start(cmd.Process.Signal)
+	}
+	var usageRec *usageRecorder
+	if args.Record != "" {
+		if usageRec, err = startUsageRecorder(args.Record, args.Interval, limiter.Stats); err != nil {
+			log.Println(err)
+		}
+	}
+	err = cmd.Wait()
+	if usageRec != nil {
+		usageRec.stop()
+	}
+	usage := cmd.Usage()
+	if usage != nil && args.Report.enabled {
+		if reportErr := args.Report.writeReport(os.Stderr, usage); reportErr != nil {
+			log.Printf("failed to write report: %v", reportErr)
+		}
+	}
+	rec.record(newExitRecord("exit", limiterNameOf(limiter), cmd.ProcessState, usage, err))
+	if usage != nil {
+		rec.record(usageRecord{Event: "usage", Name: limiterNameOf(limiter), Usage: usage})
+	}
+	if err == context.DeadlineExceeded {
+		log.Printf("command timed out after %s", args.Timeout)
+		// Matches the exit code of coreutils' timeout
+		return exitStatus(124)
+	}
+	return exitError(err)
+}
+
+func profileOptions(configFile, profileName string) ([]proclimit.Option, error) {
+	config, err := proclimit.ReadConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.Profile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	return profile.Options()
+}
+
+func ociLimiter(ociConfig string, opts []proclimit.Option) (limiter, error) {
+	data, err := ioutil.ReadFile(ociConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read OCI config")
+	}
+	l, ignored, err := proclimit.NewFromOCI(data, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(ignored) > 0 {
+		log.Printf("ignoring OCI config fields: %s", strings.Join(ignored, ", "))
+	}
+	return l, nil
+}
@@ -0,0 +1,69 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/aoldershaw/proclimit/daemon"
+	"github.com/friendsofgo/errors"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func serveCommand(args []string) error {
+	var (
+		socketPath string
+		uids, gids string
+		socketGID  uint
+	)
+	fs := newFlagSet("serve")
+	fs.StringVar(&socketPath, "socket", daemon.DefaultSocketPath, "path of the Unix socket to listen on")
+	fs.StringVar(&uids, "allow-uid", "", "comma-separated uids, besides root, that may create and manage cgroups")
+	fs.StringVar(&gids, "allow-gid", "", "comma-separated gids whose members may create and manage cgroups")
+	fs.UintVar(&socketGID, "socket-gid", 0, "gid of the socket: only root and members of the group can connect to it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitStatus(2)
+	}
+	s := &daemon.Server{SocketGID: uint32(socketGID)}
+	var err error
+	if s.AllowedUIDs, err = parseIDs(uids); err != nil {
+		return errors.Wrap(err, "invalid -allow-uid")
+	}
+	if s.AllowedGIDs, err = parseIDs(gids); err != nil {
+		return errors.Wrap(err, "invalid -allow-gid")
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		s.Close()
+	}()
+	log.Printf("listening on %s", socketPath)
+	err = s.ListenAndServe(socketPath)
+	os.Remove(socketPath)
+	return err
+}
+
+func parseIDs(s string) ([]uint32, error) {
+	var ids []uint32
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
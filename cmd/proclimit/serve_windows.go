@@ -0,0 +1,11 @@
+// +build windows
+
+package main
+
+import (
+	"github.com/friendsofgo/errors"
+)
+
+func serveCommand(args []string) error {
+	return errors.New("serve is not supported on windows")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// signalForwarder relays the signals received by proclimit (see forwardedSignals) to
+// the limited command, so that proclimit does not exit and orphan it.
+type signalForwarder struct {
+	sigs chan os.Signal
+	done chan struct{}
+}
+
+// newSignalForwarder starts catching signals. Signals received before start is called
+// are buffered, and forwarded once the command has been started.
+func newSignalForwarder() *signalForwarder {
+	f := &signalForwarder{
+		sigs: make(chan os.Signal, 16),
+		done: make(chan struct{}),
+	}
+	signal.Notify(f.sigs, forwardedSignals...)
+	return f
+}
+
+// start forwards the caught signals using send until stop is called
+func (f *signalForwarder) start(send func(os.Signal) error) {
+	go func() {
+		for {
+			select {
+			case sig := <-f.sigs:
+				forwardSignal(sig, send)
+			case <-f.done:
+				return
+			}
+		}
+	}()
+}
+
+func (f *signalForwarder) stop() {
+	signal.Stop(f.sigs)
+	close(f.done)
+}
+
+// exitError converts the error returned by a command that was killed by a signal into
+// the exit status used by shells (128+signal)
+func exitError(err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return exitStatus(128 + int(status.Signal()))
+	}
+	return err
+}
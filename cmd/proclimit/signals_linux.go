@@ -0,0 +1,62 @@
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// forwardedSignals are the catchable signals that are relayed to the command
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGALRM,
+	syscall.SIGWINCH,
+	syscall.SIGTSTP,
+	syscall.SIGCONT,
+}
+
+// ttySignals are the signals that the terminal sends to its whole foreground process group
+var ttySignals = map[os.Signal]bool{
+	syscall.SIGINT:   true,
+	syscall.SIGQUIT:  true,
+	syscall.SIGTSTP:  true,
+	syscall.SIGWINCH: true,
+}
+
+func forwardSignal(sig os.Signal, send func(os.Signal) error) {
+	// The command shares the process group of proclimit, so if that is the foreground
+	// process group of the terminal, the command has already received the signals the
+	// terminal sends, and forwarding them would deliver them twice. These signals are
+	// not forwarded when sent to proclimit alone (e.g. by kill) either, as the two cannot
+	// be told apart.
+	if !ttySignals[sig] || !inForeground() {
+		send(sig)
+	}
+	if sig == syscall.SIGTSTP {
+		// Catching SIGTSTP prevents proclimit from being suspended. Stop it explicitly
+		// once the command has been suspended, so that the shell sees the job as
+		// stopped. The SIGCONT sent by the shell on fg/bg is forwarded to the command.
+		syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+	}
+}
+
+// inForeground reports whether the process group of proclimit is the foreground process
+// group of its controlling terminal
+func inForeground() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		// There is no controlling terminal
+		return false
+	}
+	defer tty.Close()
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	return errno == 0 && int(pgrp) == syscall.Getpgrp()
+}
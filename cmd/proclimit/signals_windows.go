@@ -0,0 +1,16 @@
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+// forwardedSignals are the catchable signals that are relayed to the command
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+func forwardSignal(sig os.Signal, send func(os.Signal) error) {
+	// https://golang.org/pkg/os/#Signal
+	// "On Windows, sending os.Interrupt to a process with os.Process.Signal is not implemented"
+	send(os.Kill)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// topSortKeys maps the keys that can be pressed (and passed to -sort) to the column they sort by
+var topSortKeys = map[string]string{
+	"c": "cpu",
+	"t": "throttled",
+	"m": "memory",
+	"p": "pids",
+	"o": "oom",
+	"n": "name",
+}
+
+func topCommand(args []string) error {
+	var (
+		interval time.Duration
+		sortKey  string
+		once     bool
+	)
+	fs := newFlagSet("top")
+	fs.DurationVar(&interval, "interval", 2*time.Second, "time between refreshes")
+	fs.StringVar(&sortKey, "sort", "cpu", "column to sort by: cpu, throttled, memory, pids, oom or name")
+	fs.BoolVar(&once, "once", false, "print the table once and exit (the default if stdout is not a terminal)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitStatus(2)
+	}
+	if !validTopSortKey(sortKey) {
+		return errors.Errorf("invalid -sort %q", sortKey)
+	}
+	view := &topView{sortKey: sortKey, sampler: newTopSampler()}
+	if fs.NArg() == 1 {
+		view.selected = fs.Arg(0)
+		view.drilldown = true
+	}
+
+	if once || !isTerminal(os.Stdout) {
+		// CPU% is measured between two samples
+		view.refresh()
+		time.Sleep(interval)
+		view.refresh()
+		return view.render(os.Stdout)
+	}
+	return view.runInteractive(interval)
+}
+
+func validTopSortKey(key string) bool {
+	for _, k := range topSortKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// topRow is the usage of a single limiter
+type topRow struct {
+	name             string
+	stats            *proclimit.Stats
+	cpuPercent       float64
+	throttledPercent float64
+}
+
+// topProcess is the usage of a single process within a limiter
+type topProcess struct {
+	processInfo
+	cpuPercent float64
+}
+
+// topSampler computes the usage of limiters (and their processes) between refreshes
+type topSampler struct {
+	time      time.Time
+	stats     map[string]*proclimit.Stats
+	processes map[int]processInfo
+}
+
+func newTopSampler() *topSampler {
+	return &topSampler{stats: map[string]*proclimit.Stats{}, processes: map[int]processInfo{}}
+}
+
+func (s *topSampler) sampleLimiters() ([]topRow, error) {
+	names, err := proclimit.List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	wall := now.Sub(s.time)
+	stats := map[string]*proclimit.Stats{}
+	var rows []topRow
+	for _, name := range names {
+		limiter, err := proclimit.Existing(name)
+		if err != nil {
+			continue
+		}
+		st, err := limiter.Stats()
+		if err != nil {
+			continue
+		}
+		stats[name] = st
+		row := topRow{name: name, stats: st}
+		if prev, ok := s.stats[name]; ok && wall > 0 {
+			row.cpuPercent = 100 * float64(st.CPUUsage-prev.CPUUsage) / float64(wall)
+			if periods := st.CPUPeriods - prev.CPUPeriods; periods > 0 {
+				row.throttledPercent = 100 * float64(st.ThrottledPeriods-prev.ThrottledPeriods) / float64(periods)
+			}
+		}
+		rows = append(rows, row)
+	}
+	s.time = now
+	s.stats = stats
+	return rows, nil
+}
+
+func (s *topSampler) sampleProcesses(name string, wall time.Duration) ([]topProcess, error) {
+	limiter, err := proclimit.Existing(name)
+	if err != nil {
+		return nil, err
+	}
+	pids, err := limiter.Processes()
+	if err != nil {
+		return nil, err
+	}
+	infos := map[int]processInfo{}
+	var processes []topProcess
+	for _, pid := range pids {
+		info, err := readProcessInfo(pid)
+		if err != nil {
+			continue
+		}
+		infos[pid] = info
+		p := topProcess{processInfo: info}
+		if prev, ok := s.processes[pid]; ok && wall > 0 {
+			p.cpuPercent = 100 * float64(info.cpuTime-prev.cpuTime) / float64(wall)
+		}
+		processes = append(processes, p)
+	}
+	s.processes = infos
+	sort.Slice(processes, func(i, j int) bool {
+		if processes[i].cpuPercent != processes[j].cpuPercent {
+			return processes[i].cpuPercent > processes[j].cpuPercent
+		}
+		return processes[i].pid < processes[j].pid
+	})
+	return processes, nil
+}
+
+// topView is the state of the top display
+type topView struct {
+	sampler   *topSampler
+	sortKey   string
+	selected  string
+	drilldown bool
+
+	rows      []topRow
+	processes []topProcess
+	err       error
+}
+
+// refresh samples the limiters (and the processes of the selected limiter, if drilled down)
+func (v *topView) refresh() {
+	prevTime := v.sampler.time
+	v.rows, v.err = v.sampler.sampleLimiters()
+	v.sortRows()
+	if v.selected == "" && len(v.rows) > 0 {
+		v.selected = v.rows[0].name
+	}
+	if v.drilldown {
+		var err error
+		if v.processes, err = v.sampler.sampleProcesses(v.selected, v.sampler.time.Sub(prevTime)); err != nil {
+			v.err = err
+		}
+	}
+}
+
+func (v *topView) sortRows() {
+	less := func(a, b topRow) bool {
+		switch v.sortKey {
+		case "throttled":
+			return a.throttledPercent > b.throttledPercent
+		case "memory":
+			return a.stats.MemoryUsage > b.stats.MemoryUsage
+		case "pids":
+			return a.stats.Processes > b.stats.Processes
+		case "oom":
+			return a.stats.OOMKills > b.stats.OOMKills
+		case "name":
+			return a.name < b.name
+		}
+		return a.cpuPercent > b.cpuPercent
+	}
+	sort.SliceStable(v.rows, func(i, j int) bool {
+		if less(v.rows[i], v.rows[j]) {
+			return true
+		}
+		if less(v.rows[j], v.rows[i]) {
+			return false
+		}
+		return v.rows[i].name < v.rows[j].name
+	})
+}
+
+// move changes the selected limiter by delta rows
+func (v *topView) move(delta int) {
+	if len(v.rows) == 0 {
+		return
+	}
+	i := 0
+	for j, row := range v.rows {
+		if row.name == v.selected {
+			i = j
+		}
+	}
+	i += delta
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(v.rows) {
+		i = len(v.rows) - 1
+	}
+	v.selected = v.rows[i].name
+}
+
+func (v *topView) render(w io.Writer) error {
+	if v.drilldown {
+		return v.renderProcesses(w)
+	}
+	return v.renderLimiters(w, false)
+}
+
+func (v *topView) renderLimiters(w io.Writer, interactive bool) error {
+	fmt.Fprintf(w, "%d %ss, sorted by %s\n\n", len(v.rows), limiterName, v.sortKey)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tCPU%\tTHROTTLED%\tMEMORY\tLIMIT\tMEM%\tPIDS\tOOM\t")
+	for _, row := range v.rows {
+		marker := "  "
+		if interactive && row.name == v.selected {
+			marker = "> "
+		}
+		limit, memPercent := "-", "-"
+		if row.stats.MemoryLimit > 0 {
+			limit = formatBytes(row.stats.MemoryLimit)
+			memPercent = fmt.Sprintf("%.1f", 100*float64(row.stats.MemoryUsage)/float64(row.stats.MemoryLimit))
+		}
+		fmt.Fprintf(tw, "%s%s\t%.1f\t%.1f\t%s\t%s\t%s\t%d\t%d\t\n",
+			marker, row.name, row.cpuPercent, row.throttledPercent,
+			formatBytes(row.stats.MemoryUsage), limit, memPercent, row.stats.Processes, row.stats.OOMKills)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if v.err != nil {
+		fmt.Fprintf(w, "\n%v\n", v.err)
+	}
+	return nil
+}
+
+func (v *topView) renderProcesses(w io.Writer) error {
+	fmt.Fprintf(w, "processes in %s\n\n", v.selected)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tSTATE\tCPU%\tCPU TIME\tRSS\tCOMMAND\t")
+	for _, p := range v.processes {
+		fmt.Fprintf(tw, "%d\t%s\t%.1f\t%s\t%s\t%s\t\n",
+			p.pid, p.state, p.cpuPercent, p.cpuTime.Round(10*time.Millisecond), formatBytes(p.rss), p.command)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if v.err != nil {
+		fmt.Fprintf(w, "\n%v\n", v.err)
+	}
+	return nil
+}
+
+// runInteractive refreshes the display every interval until q is pressed. If the
+// terminal supports it, keys can be used to sort, select and drill down.
+func (v *topView) runInteractive(interval time.Duration) error {
+	keys := make(chan string)
+	restore, rawErr := makeRaw(os.Stdin)
+	if rawErr == nil {
+		defer restore()
+		go readKeys(os.Stdin, keys)
+	}
+	// Use the alternate screen, and hide the cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	v.refresh()
+	for {
+		var buf bytes.Buffer
+		if v.drilldown {
+			v.renderProcesses(&buf)
+			if rawErr == nil {
+				fmt.Fprint(&buf, "\nesc/b back, q quit\n")
+			}
+		} else {
+			v.renderLimiters(&buf, rawErr == nil)
+			if rawErr == nil {
+				fmt.Fprint(&buf, "\nsort: c cpu, t throttled, m memory, p pids, o oom, n name; up/down select, enter processes, q quit\n")
+			}
+		}
+		// Move home and clear the screen before drawing
+		os.Stdout.WriteString("\x1b[H\x1b[2J" + strings.Replace(buf.String(), "\n", "\r\n", -1))
+
+		select {
+		case <-sigs:
+			return nil
+		case <-ticker.C:
+			v.refresh()
+		case key := <-keys:
+			switch key {
+			case "q", "\x03":
+				return nil
+			case "up", "k":
+				v.move(-1)
+			case "down", "j":
+				v.move(1)
+			case "enter":
+				if !v.drilldown && v.selected != "" {
+					v.drilldown = true
+					v.processes = nil
+					v.refresh()
+				}
+			case "esc", "b":
+				v.drilldown = false
+			default:
+				if sortKey, ok := topSortKeys[key]; ok {
+					v.sortKey = sortKey
+					v.sortRows()
+				}
+			}
+		}
+	}
+}
+
+// readKeys sends the keys read from r to keys
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		switch key := string(buf[:n]); key {
+		case "\x1b[A", "\x1bOA":
+			keys <- "up"
+		case "\x1b[B", "\x1bOB":
+			keys <- "down"
+		case "\r", "\n":
+			keys <- "enter"
+		case "\x1b", "\x7f":
+			keys <- "esc"
+		default:
+			keys <- key
+		}
+	}
+}
+
+// formatBytes formats m with a binary unit and one decimal place, e.g. 1.5Gi
+func formatBytes(m proclimit.Memory) string {
+	units := []struct {
+		size   proclimit.Memory
+		suffix string
+	}{
+		{proclimit.Terabyte, "Ti"},
+		{proclimit.Gigabyte, "Gi"},
+		{proclimit.Megabyte, "Mi"},
+		{proclimit.Kilobyte, "Ki"},
+	}
+	for _, u := range units {
+		if m >= u.size {
+			return fmt.Sprintf("%.1f%s", float64(m)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d", uint64(m))
+}
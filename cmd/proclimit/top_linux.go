@@ -0,0 +1,83 @@
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// processInfo is the usage of a single process
+type processInfo struct {
+	pid     int
+	state   string
+	cpuTime time.Duration
+	rss     proclimit.Memory
+	command string
+}
+
+func readProcessInfo(pid int) (processInfo, error) {
+	stat, err := procfs.ReadStat(pid)
+	if err != nil {
+		return processInfo{pid: pid}, err
+	}
+	cmdline, _ := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	return newProcessInfo(stat, cmdline, os.Getpagesize()), nil
+}
+
+// newProcessInfo builds the processInfo of a process from its stat and its command line
+func newProcessInfo(stat *procfs.Stat, cmdline []byte, pageSize int) processInfo {
+	info := processInfo{
+		pid:     stat.Pid,
+		state:   stat.State,
+		cpuTime: stat.CPUTime(),
+		rss:     proclimit.Memory(stat.RSS * uint64(pageSize)),
+	}
+	info.command = strings.TrimSpace(string(bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1)))
+	if info.command == "" {
+		// Kernel threads and zombies have no command line
+		info.command = "[" + stat.Comm + "]"
+	}
+	return info
+}
+
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	return ioctlTermios(f, syscall.TCGETS, &termios) == nil
+}
+
+// makeRaw puts the terminal f into raw mode, so that keys can be read as they are
+// pressed. The returned function restores the previous mode.
+func makeRaw(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := ioctlTermios(f, syscall.TCGETS, &old); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctlTermios(f, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() {
+		ioctlTermios(f, syscall.TCSETS, &old)
+	}, nil
+}
+
+func ioctlTermios(f *os.File, req uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
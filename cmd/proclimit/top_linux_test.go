@@ -0,0 +1,57 @@
+// +build linux
+
+package main
+
+import (
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewProcessInfo(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		stat     string
+		cmdline  string
+		expected processInfo
+	}{
+		{
+			name:     "command line",
+			stat:     "42 (sleep) S 1 42 42 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 1 0 12345 4096 300",
+			cmdline:  "sleep\x0010\x00",
+			expected: processInfo{pid: 42, state: "S", cpuTime: 3 * time.Second, rss: 300 * 4096, command: "sleep 10"},
+		},
+		{
+			name:     "kernel thread",
+			stat:     "2 (kthreadd) S 0 0 0 0 -1 2129984 0 0 0 0 0 1 0 0 20 0 1 0 1 0 0",
+			expected: processInfo{pid: 2, state: "S", cpuTime: 10 * time.Millisecond, command: "[kthreadd]"},
+		},
+		{
+			name:     "zombie with parentheses in its name",
+			stat:     "7 (a) (b)) Z 1 7 7 0 -1 4194564 0 0 0 0 1 1 0 0 20 0 1 0 99 0 0",
+			expected: processInfo{pid: 7, state: "Z", cpuTime: 20 * time.Millisecond, command: "[a) (b)]"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stat, err := procfs.ParseStat([]byte(tt.stat))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info := newProcessInfo(stat, []byte(tt.cmdline), 4096); info != tt.expected {
+				t.Errorf("expected %+v, but got %+v", tt.expected, info)
+			}
+		})
+	}
+}
+
+func TestReadProcessInfo(t *testing.T) {
+	info, err := readProcessInfo(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.pid != os.Getpid() || info.rss == 0 || info.command != strings.Join(os.Args, " ") {
+		t.Errorf("unexpected info of the current process: %+v", info)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"reflect"
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for _, tt := range []struct {
+		memory   proclimit.Memory
+		expected string
+	}{
+		{0, "0"},
+		{1023, "1023"},
+		{proclimit.Kilobyte, "1.0Ki"},
+		{1536, "1.5Ki"},
+		{100 * proclimit.Megabyte, "100.0Mi"},
+		{3 * proclimit.Gigabyte / 2, "1.5Gi"},
+		{2 * proclimit.Terabyte, "2.0Ti"},
+		{2048 * proclimit.Terabyte, "2048.0Ti"},
+	} {
+		if actual := formatBytes(tt.memory); actual != tt.expected {
+			t.Errorf("expected %d to be formatted as %q, but got %q", tt.memory, tt.expected, actual)
+		}
+	}
+}
+
+func TestTopSortRows(t *testing.T) {
+	rows := []topRow{
+		{name: "a", stats: &proclimit.Stats{MemoryUsage: 100, Processes: 1, OOMKills: 2}, cpuPercent: 10, throttledPercent: 0},
+		{name: "b", stats: &proclimit.Stats{MemoryUsage: 300, Processes: 3, OOMKills: 0}, cpuPercent: 50, throttledPercent: 5},
+		{name: "c", stats: &proclimit.Stats{MemoryUsage: 200, Processes: 1, OOMKills: 1}, cpuPercent: 10, throttledPercent: 20},
+		{name: "d", stats: &proclimit.Stats{MemoryUsage: 200, Processes: 2, OOMKills: 0}, cpuPercent: 30, throttledPercent: 5},
+	}
+	for _, tt := range []struct {
+		sortKey  string
+		expected []string
+	}{
+		// Ties are broken by name
+		{"cpu", []string{"b", "d", "a", "c"}},
+		{"throttled", []string{"c", "b", "d", "a"}},
+		{"memory", []string{"b", "c", "d", "a"}},
+		{"pids", []string{"b", "d", "a", "c"}},
+		{"oom", []string{"a", "c", "b", "d"}},
+		{"name", []string{"a", "b", "c", "d"}},
+	} {
+		t.Run(tt.sortKey, func(t *testing.T) {
+			// Sort a reversed copy, so that the result does not depend on the initial order
+			v := &topView{sortKey: tt.sortKey}
+			for i := len(rows) - 1; i >= 0; i-- {
+				v.rows = append(v.rows, rows[i])
+			}
+			v.sortRows()
+			var names []string
+			for _, row := range v.rows {
+				names = append(names, row.name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+// +build windows
+
+package main
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"os"
+	"time"
+)
+
+// processInfo is the usage of a single process. Only the pid is available on windows.
+type processInfo struct {
+	pid     int
+	state   string
+	cpuTime time.Duration
+	rss     proclimit.Memory
+	command string
+}
+
+func readProcessInfo(pid int) (processInfo, error) {
+	return processInfo{pid: pid, state: "-"}, nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// makeRaw is not supported on windows, so the display is refreshed without reading keys
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw mode is not supported on windows")
+}
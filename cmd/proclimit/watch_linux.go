@@ -0,0 +1,79 @@
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aoldershaw/proclimit/rules"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func watchCommand(args []string) error {
+	var (
+		rulesPath    string
+		poll         bool
+		pollInterval time.Duration
+	)
+	fs := newFlagSet("watch")
+	fs.StringVar(&rulesPath, "rules", "", "path to a JSON or YAML file describing the limiters, and the rules that select the processes to move into them")
+	fs.BoolVar(&poll, "poll", false, "poll /proc for new processes, rather than using the proc connector")
+	fs.DurationVar(&pollInterval, "poll-interval", time.Second, "how often /proc is polled for new processes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if rulesPath == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return exitStatus(2)
+	}
+	rec, err := output.open(os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer rec.close()
+	config, err := rules.ReadConfigFile(rulesPath)
+	if err != nil {
+		return err
+	}
+	limiters, err := rules.OpenLimiters(config)
+	if err != nil {
+		return err
+	}
+	engine, err := rules.NewEngine(config, limiters)
+	if err != nil {
+		return err
+	}
+	engine.Poll = poll
+	engine.PollInterval = pollInterval
+	engine.Logf = log.Printf
+	engine.OnLimit = func(p *rules.Process, rule *rules.Rule, err error) {
+		if rec != nil {
+			r := limitedRecord{Event: "limited", Name: rule.Limiter, Pid: p.Pid, Exe: p.Exe}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			rec.record(r)
+			return
+		}
+		if err != nil {
+			log.Printf("failed to move process %d (%s) into %s: %v", p.Pid, p.Exe, rule.Limiter, err)
+			return
+		}
+		fmt.Printf("moved process %d (%s) into %s\n", p.Pid, strings.Join(p.Cmdline, " "), rule.Limiter)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+	return engine.Run(ctx)
+}
@@ -0,0 +1,11 @@
+// +build windows
+
+package main
+
+import (
+	"github.com/friendsofgo/errors"
+)
+
+func watchCommand(args []string) error {
+	return errors.New("watch is not supported on windows")
+}
@@ -5,24 +5,18 @@ package proclimit
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/friendsofgo/errors"
+	"os"
 	"os/exec"
 	"strconv"
+	"time"
 )
 
 // Percent is a percentage value. It is used to specify CPU rate limits.
 type Percent uint
 
-// Memory represents a number of bytes
-type Memory uint
-
-const (
-	Byte     Memory = 1
-	Kilobyte        = 1024 * Byte
-	Megabyte        = 1024 * Kilobyte
-	Gigabyte        = 1024 * Megabyte
-)
-
 // Limiter allows limiting a running process' resources
 type Limiter interface {
 	// Limit applies limits to a running process by its pid.
@@ -30,6 +24,16 @@ type Limiter interface {
 	Limit(pid int) error
 }
 
+// signaller is implemented by Limiters that can signal all of their processes
+type signaller interface {
+	Signal(sig os.Signal) error
+}
+
+// processLister is implemented by Limiters that can list all of their processes
+type processLister interface {
+	Processes() ([]int, error)
+}
+
 // Cmd represents an external command being prepared or run.
 // This command will be limited by the provided Limiter.
 //
@@ -37,30 +41,238 @@ type Limiter interface {
 type Cmd struct {
 	*exec.Cmd
 	Limiter Limiter
+
+	// CancelSignal is sent when the context passed to CommandContext is done. If the Limiter
+	// supports it, the signal is sent to every process within the Limiter - otherwise, only
+	// the command's process is signalled. Defaults to os.Kill.
+	CancelSignal os.Signal
+	// CancelGracePeriod is how long to wait after sending CancelSignal before killing all
+	// remaining processes. If zero, processes are not killed after sending CancelSignal.
+	CancelGracePeriod time.Duration
+
+	ctx        context.Context
+	waitDone   chan struct{}
+	cancelDone chan struct{}
+	cancelled  bool
+	startTime  time.Time
+	startStats *Stats
+	usage      *Usage
 }
 
 // Start begins the execution of a Cmd, and applies the limits defined by the
-// associated Limiter. If the Limiter fails to apply limits, the process will be killed.
+// associated Limiter. If the Limiter fails to apply limits, the process (and any
+// processes it has started) will be killed and waited for, and a *LimitError is returned.
+// If the Limiter can list its processes and had none before the command started, it is
+// exclusive to the command, and every process within it is also killed. Otherwise, the
+// processes of the Limiter are left running, as they may belong to other commands.
+//
+// If the Limiter has an AdmissionPolicy, the command is only started once the policy
+// admits it. Otherwise, an *AdmissionError (or the error of the Cmd's context) is returned.
 //
 // Note that the Cmd will start before the limits are applied, so there will be a brief
 // period where the limits are not enforced.
 func (c *Cmd) Start() error {
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if a, ok := c.Limiter.(admitter); ok {
+		ctx := c.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		release, err := a.admit(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	exclusive := c.limiterEmpty()
+	c.startTime = time.Now()
 	if err := c.Cmd.Start(); err != nil {
 		return err
 	}
 	if err := c.Limiter.Limit(c.Process.Pid); err != nil {
-		c.Process.Kill()
-		return errors.Wrap(err, "failed to limit command")
+		return c.abortStart(err, exclusive)
+	}
+	if s, ok := c.Limiter.(statser); ok {
+		c.startStats, _ = s.Stats()
+	}
+	if c.ctx != nil {
+		c.waitDone = make(chan struct{})
+		c.cancelDone = make(chan struct{})
+		go c.watchContext()
 	}
 	return nil
 }
 
+// LimitError is returned by Cmd.Start when the Limiter failed to apply limits to the command.
+// By the time it is returned, the command and any processes it started have been killed, the
+// command has been waited for and its stdio pipes have been closed. The other processes
+// within the Limiter have only been killed if the Limiter was exclusive to the command (see
+// Cmd.Start).
+type LimitError struct {
+	// Err is the error returned by the Limiter
+	Err error
+	// ProcessState describes how the command exited. It usually reports that it was
+	// killed, but the command may have exited before it could be killed.
+	ProcessState *os.ProcessState
+	// WaitErr is the error waiting for the command, if any other than its exit status
+	// (e.g. copying its output failed)
+	WaitErr error
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("failed to limit command: %v", e.Err)
+	if e.ProcessState != nil {
+		msg += fmt.Sprintf(" (command %s)", e.ProcessState)
+	}
+	if e.WaitErr != nil {
+		msg += fmt.Sprintf(" (wait: %v)", e.WaitErr)
+	}
+	return msg
+}
+
+// Cause returns the error returned by the Limiter, so that errors.Cause can be used
+func (e *LimitError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the error returned by the Limiter
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// limiterEmpty reports whether the Limiter can list its processes, and has none
+func (c *Cmd) limiterEmpty() bool {
+	l, ok := c.Limiter.(processLister)
+	if !ok {
+		return false
+	}
+	pids, err := l.Processes()
+	return err == nil && len(pids) == 0
+}
+
+// abortStart kills the command and any processes it has started after the Limiter failed
+// to limit it, waits for it, and returns a *LimitError describing both. If the Limiter is
+// exclusive to the command, every process within it is killed as well - including those
+// the command started that are no longer its descendants.
+func (c *Cmd) abortStart(limitErr error, exclusive bool) error {
+	killProcessTree(c.Process.Pid)
+	if s, ok := c.Limiter.(signaller); ok && exclusive {
+		s.Signal(os.Kill)
+	}
+	c.Process.Kill()
+	err := &LimitError{Err: limitErr}
+	// Waiting for the command reaps it, and closes its stdio pipes
+	waitErr := c.Cmd.Wait()
+	if _, ok := waitErr.(*exec.ExitError); !ok {
+		err.WaitErr = waitErr
+	}
+	err.ProcessState = c.ProcessState
+	return err
+}
+
+// Wait waits for the command to exit. If the command was killed because a budget of the
+// Limiter was exhausted, a *BudgetExceededError is returned. If the command was started
+// with a context that is done before the command completes, the context's error is
+// returned instead of the command's exit status.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	c.recordUsage()
+	if c.waitDone != nil {
+		close(c.waitDone)
+		<-c.cancelDone
+	}
+	if err == nil {
+		return nil
+	}
+	if b, ok := c.Limiter.(budgetChecker); ok {
+		if budgetErr := b.budgetExceeded(); budgetErr != nil {
+			return budgetErr
+		}
+	}
+	if c.cancelled {
+		return c.ctx.Err()
+	}
+	return err
+}
+
+// Usage returns the resources consumed by the command. It returns nil until the command
+// has been waited for.
+func (c *Cmd) Usage() *Usage {
+	return c.usage
+}
+
+func (c *Cmd) recordUsage() {
+	if c.ProcessState == nil {
+		return
+	}
+	var endStats *Stats
+	if s, ok := c.Limiter.(statser); ok {
+		endStats, _ = s.Stats()
+	}
+	c.usage = newUsage(
+		time.Since(c.startTime),
+		c.ProcessState.UserTime(),
+		c.ProcessState.SystemTime(),
+		c.startStats,
+		endStats,
+	)
+}
+
 // Run starts the specified command (with limits), and waits for it to complete.
 func (c *Cmd) Run() error {
 	if err := c.Start(); err != nil {
 		return err
 	}
-	return c.Cmd.Wait()
+	return c.Wait()
+}
+
+// watchContext signals the command's processes when its context is done
+func (c *Cmd) watchContext() {
+	defer close(c.cancelDone)
+	select {
+	case <-c.waitDone:
+		return
+	case <-c.ctx.Done():
+	}
+	c.cancelled = true
+	sig := c.CancelSignal
+	if sig == nil {
+		sig = os.Kill
+	}
+	c.signal(sig)
+	if c.CancelGracePeriod <= 0 || sig == os.Kill {
+		return
+	}
+	timer := time.NewTimer(c.CancelGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.waitDone:
+		// The command has exited, but other processes within the Limiter may still
+		// need to be killed once the grace period is over
+		if lister, ok := c.Limiter.(processLister); ok {
+			if pids, err := lister.Processes(); err == nil && len(pids) == 0 {
+				return
+			}
+		}
+		<-timer.C
+	}
+	c.signal(os.Kill)
+}
+
+// signal sends sig to all processes in the Limiter if supported, otherwise just to
+// the command's process
+func (c *Cmd) signal(sig os.Signal) {
+	if s, ok := c.Limiter.(signaller); ok {
+		if err := s.Signal(sig); err == nil {
+			return
+		}
+	}
+	c.Process.Signal(sig)
 }
 
 // Output runs the command (with limits) and returns its standard output.
@@ -0,0 +1,70 @@
+// +build linux
+
+package proclimit
+
+import (
+	"bytes"
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// limiterFunc is a Limiter that calls a function
+type limiterFunc func(pid int) error
+
+func (f limiterFunc) Limit(pid int) error {
+	return f(pid)
+}
+
+func TestCmdStartLimiterErrorsKillsDescendants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proclimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "pid")
+
+	// The limiter fails once the command has started a background process
+	limitErr := errors.New("limit error")
+	limiter := limiterFunc(func(pid int) error {
+		for i := 0; i < 100; i++ {
+			if data, _ := ioutil.ReadFile(pidFile); bytes.HasSuffix(data, []byte("\n")) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return limitErr
+	})
+	cmd := &Cmd{Cmd: exec.Command("sh", "-c", "sleep 10 & echo $! > "+pidFile+"; wait"), Limiter: limiter}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); errors.Cause(err) != limitErr {
+		t.Fatalf("expected error \"%v\", but got: %v", limitErr, err)
+	}
+
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	background, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The background process is reparented when it is killed, so it may remain a zombie
+	time.Sleep(100 * time.Millisecond)
+	if stat, err := procfs.ReadStat(background); err == nil && stat.State != "Z" {
+		t.Errorf("expected background process %d to have been killed, but it is in state %s", background, stat.State)
+	}
+	if _, err := stdout.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected the stdout pipe to have been closed")
+	}
+}
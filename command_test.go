@@ -2,9 +2,14 @@ package proclimit
 
 import (
 	"bytes"
+	"context"
 	"github.com/friendsofgo/errors"
+	"os"
 	"os/exec"
+	"reflect"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func echo(args ...string) *exec.Cmd {
@@ -35,17 +40,68 @@ func TestCmdStartInvokesLimit(t *testing.T) {
 
 func TestCmdStartLimiterErrors(t *testing.T) {
 	sl := &spyLimiter{returnErr: errors.New("limit error")}
-	cmd := &Cmd{Cmd: echo(), Limiter: sl}
+	// The command must still be running when it is killed, for it to be reported as killed
+	cmd := &Cmd{Cmd: exec.Command("sleep", "10"), Limiter: sl}
 	err := cmd.Start()
 	if err == nil || errors.Cause(err) != sl.returnErr {
 		t.Errorf("expected error \"%v\", but got: %v", sl.returnErr, err)
 	}
-	s, err := cmd.Process.Wait()
-	if err != nil {
-		t.Fatalf("failed to wait for process: %v", err)
+	limitErr, ok := err.(*LimitError)
+	if !ok {
+		t.Fatalf("expected a *LimitError, but got: %v", err)
+	}
+	if limitErr.WaitErr != nil {
+		t.Errorf("expected no wait error, but got: %v", limitErr.WaitErr)
+	}
+	// The process has been reaped by Start
+	if limitErr.ProcessState == nil || cmd.ProcessState != limitErr.ProcessState {
+		t.Fatalf("expected the process to have been waited for")
 	}
-	if s.ExitCode() != -1 {
-		t.Errorf("expected exit code -1 (terminated by signal), but got: %d", s.ExitCode())
+	if limitErr.ProcessState.ExitCode() != -1 {
+		t.Errorf("expected exit code -1 (terminated by signal), but got: %d", limitErr.ProcessState.ExitCode())
+	}
+}
+
+// groupLimiter is a Limiter that can list and signal its processes. It adds processes to
+// its group, but reports that limiting them failed.
+type groupLimiter struct {
+	pids    []int
+	signals []os.Signal
+}
+
+func (g *groupLimiter) Limit(pid int) error {
+	g.pids = append(g.pids, pid)
+	return errors.New("limit error")
+}
+
+func (g *groupLimiter) Processes() ([]int, error) {
+	return g.pids, nil
+}
+
+func (g *groupLimiter) Signal(sig os.Signal) error {
+	g.signals = append(g.signals, sig)
+	return nil
+}
+
+func TestCmdStartLimiterErrorsKillsGroup(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		pids        []int
+		signals     []os.Signal
+	}{
+		{"exclusive limiter", nil, []os.Signal{os.Kill}},
+		{"shared limiter", []int{1}, nil},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			g := &groupLimiter{pids: tt.pids}
+			cmd := &Cmd{Cmd: exec.Command("sleep", "10"), Limiter: g}
+			if _, ok := cmd.Start().(*LimitError); !ok {
+				t.Fatalf("expected a *LimitError")
+			}
+			if !reflect.DeepEqual(g.signals, tt.signals) {
+				t.Errorf("expected signals %v, but got %v", tt.signals, g.signals)
+			}
+		})
 	}
 }
 
@@ -59,3 +115,124 @@ func TestCmdOutput(t *testing.T) {
 		t.Errorf("expected 'hello, world!\\n', but got: '%s'", string(out))
 	}
 }
+
+type signallingSpyLimiter struct {
+	spyLimiter
+	signals chan os.Signal
+	pid     int
+}
+
+func (s *signallingSpyLimiter) Limit(pid int) error {
+	s.pid = pid
+	return s.spyLimiter.Limit(pid)
+}
+
+// Signal records sig, and forwards SIGTERM to the limited process
+func (s *signallingSpyLimiter) Signal(sig os.Signal) error {
+	s.signals <- sig
+	if sig == syscall.SIGTERM {
+		p, err := os.FindProcess(s.pid)
+		if err != nil {
+			return err
+		}
+		return p.Signal(sig)
+	}
+	return nil
+}
+
+func TestCmdContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := &Cmd{Cmd: exec.Command("sleep", "10"), Limiter: &spyLimiter{}, ctx: ctx}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	cancel()
+	if err := cmd.Wait(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, but got: %v", err)
+	}
+}
+
+func TestCmdContextSignalsLimiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sl := &signallingSpyLimiter{signals: make(chan os.Signal, 2)}
+	cmd := &Cmd{
+		Cmd:               exec.Command("sleep", "10"),
+		Limiter:           sl,
+		CancelSignal:      syscall.SIGTERM,
+		CancelGracePeriod: 10 * time.Millisecond,
+		ctx:               ctx,
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	cancel()
+	if err := cmd.Wait(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, but got: %v", err)
+	}
+	close(sl.signals)
+	var signals []os.Signal
+	for sig := range sl.signals {
+		signals = append(signals, sig)
+	}
+	// The limiter can't list its processes, so it is killed after the grace period
+	if !reflect.DeepEqual(signals, []os.Signal{syscall.SIGTERM, os.Kill}) {
+		t.Errorf("expected SIGTERM and then os.Kill to be sent to the limiter, but got: %v", signals)
+	}
+}
+
+func TestCmdContextDoneBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmd := &Cmd{Cmd: exec.Command("sleep", "10"), Limiter: &spyLimiter{}, ctx: ctx}
+	if err := cmd.Start(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, but got: %v", err)
+	}
+	if cmd.Process != nil {
+		t.Errorf("expected process not to be started")
+	}
+}
+
+type statsSpyLimiter struct {
+	spyLimiter
+	stats []*Stats
+}
+
+func (s *statsSpyLimiter) Stats() (*Stats, error) {
+	stats := s.stats[0]
+	s.stats = s.stats[1:]
+	return stats, nil
+}
+
+func TestCmdUsage(t *testing.T) {
+	sl := &statsSpyLimiter{stats: []*Stats{
+		{ThrottledTime: time.Second, IOReadBytes: 100, IOWriteBytes: 1000, MemoryMaxUsage: Megabyte, OOMKills: 1},
+		{ThrottledTime: 3 * time.Second, IOReadBytes: 150, IOWriteBytes: 3000, MemoryMaxUsage: 2 * Megabyte, OOMKills: 2},
+	}}
+	cmd := &Cmd{Cmd: echo(), Limiter: sl}
+	if cmd.Usage() != nil {
+		t.Errorf("expected no usage before the command has run")
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	usage := cmd.Usage()
+	if usage == nil {
+		t.Fatalf("expected usage after the command has run")
+	}
+	if usage.WallTime <= 0 {
+		t.Errorf("expected a positive wall time, but got %s", usage.WallTime)
+	}
+	expected := Usage{
+		WallTime:      usage.WallTime,
+		UserTime:      cmd.ProcessState.UserTime(),
+		SystemTime:    cmd.ProcessState.SystemTime(),
+		PeakMemory:    2 * Megabyte,
+		ThrottledTime: 2 * time.Second,
+		IOReadBytes:   50,
+		IOWriteBytes:  2000,
+		OOMKills:      1,
+	}
+	if *usage != expected {
+		t.Errorf("expected usage %+v, but got %+v", expected, *usage)
+	}
+}
@@ -0,0 +1,117 @@
+package proclimit
+
+import (
+	"encoding/json"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config describes a set of named limiter profiles. It is typically loaded
+// from a JSON or YAML document, e.g.
+//
+//	profiles:
+//	  small:
+//	    cpu: 50
+//	    memory: 512Mi
+//	    pids: 100
+//	  build:
+//	    cpu: 400
+//	    memory: 8Gi
+//	    cpuset: 0-3
+//	    io:
+//	      - device: "8:0"
+//	        writeBps: 50MB
+type Config struct {
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// Profile describes the limits of a single limiter. Fields that are not
+// specified are left unlimited. Profile.Options converts a Profile into the
+// Options for the current platform.
+type Profile struct {
+	// CPU is the maximum CPU percentage, relative to a single core
+	CPU Percent `json:"cpu,omitempty"`
+	// Memory is the maximum memory usage, as a number of bytes or a string
+	// understood by ParseMemory
+	Memory Memory `json:"memory,omitempty"`
+	// Pids is the maximum number of processes
+	Pids int64 `json:"pids,omitempty"`
+	// CPUSet is the list of CPUs processes may run on (e.g. "0-3,6")
+	CPUSet CPUList `json:"cpuset,omitempty"`
+	// IO throttles IO to individual block devices (Linux only)
+	IO []IOProfile `json:"io,omitempty"`
+}
+
+// CPUList is a list of CPUs, e.g. "0-3,6", as accepted by WithCPUSet
+type CPUList string
+
+// UnmarshalJSON accepts either a string or a single CPU number, so that an unquoted
+// single CPU in YAML (e.g. "cpuset: 3") is not rejected.
+func (l *CPUList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*l = CPUList(s)
+		return nil
+	}
+	v, err := strconv.ParseUint(string(data), 10, 32)
+	if err != nil {
+		return errors.Errorf("invalid cpuset %s: expected a list of CPUs such as \"0-3,6\"", data)
+	}
+	*l = CPUList(strconv.FormatUint(v, 10))
+	return nil
+}
+
+// IOProfile describes the IO limits for a single block device
+type IOProfile struct {
+	// Device is the device's "major:minor" number (e.g. "8:0")
+	Device    string `json:"device"`
+	ReadBPS   Memory `json:"readBps,omitempty"`
+	WriteBPS  Memory `json:"writeBps,omitempty"`
+	ReadIOPS  uint64 `json:"readIops,omitempty"`
+	WriteIOPS uint64 `json:"writeIops,omitempty"`
+}
+
+// ParseConfig parses a JSON or YAML document into a Config. Unknown keys are
+// treated as errors.
+func ParseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	for name, profile := range c.Profiles {
+		if profile == nil {
+			return nil, errors.Errorf("invalid config: profile %q is empty", name)
+		}
+	}
+	return &c, nil
+}
+
+// ReadConfigFile reads and parses a JSON or YAML config file
+func ReadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config")
+	}
+	return ParseConfig(data)
+}
+
+// Profile looks up a profile by name
+func (c *Config) Profile(name string) (*Profile, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("unknown profile %q (available profiles: %s)", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
@@ -0,0 +1,66 @@
+// +build linux
+
+package proclimit
+
+import (
+	"github.com/friendsofgo/errors"
+	"strconv"
+	"strings"
+)
+
+// Options converts the Profile into Cgroup Options
+func (p *Profile) Options() ([]Option, error) {
+	var opts []Option
+	if p.CPU > 0 {
+		opts = append(opts, WithCPULimit(p.CPU))
+	}
+	if p.Memory > 0 {
+		opts = append(opts, WithMemoryLimit(p.Memory))
+	}
+	if p.Pids > 0 {
+		opts = append(opts, WithPidsLimit(p.Pids))
+	}
+	if p.CPUSet != "" {
+		opts = append(opts, WithCPUSet(string(p.CPUSet)))
+	}
+	for _, io := range p.IO {
+		major, minor, err := parseDevice(io.Device)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithIOLimit(IOLimit{
+			Major:     major,
+			Minor:     minor,
+			ReadBPS:   io.ReadBPS,
+			WriteBPS:  io.WriteBPS,
+			ReadIOPS:  io.ReadIOPS,
+			WriteIOPS: io.WriteIOPS,
+		}))
+	}
+	return opts, nil
+}
+
+// NewFromProfile creates a new Cgroup with the limits described by profile.
+// options are applied after the profile's limits, so they take precedence.
+func NewFromProfile(profile *Profile, options ...Option) (*Cgroup, error) {
+	opts, err := profile.Options()
+	if err != nil {
+		return nil, err
+	}
+	return New(append(opts, options...)...)
+}
+
+// parseDevice parses a block device number in the form "major:minor"
+func parseDevice(device string) (major, minor int64, err error) {
+	parts := strings.Split(device, ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid device %q: expected major:minor", device)
+	}
+	if major, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, errors.Errorf("invalid device %q: expected major:minor", device)
+	}
+	if minor, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, 0, errors.Errorf("invalid device %q: expected major:minor", device)
+	}
+	return major, minor, nil
+}
@@ -0,0 +1,39 @@
+// +build windows
+
+package proclimit
+
+import (
+	"github.com/friendsofgo/errors"
+)
+
+// Options converts the Profile into JobObject Options. IO limits are not
+// supported on Windows.
+func (p *Profile) Options() ([]Option, error) {
+	var opts []Option
+	if p.CPU > 0 {
+		opts = append(opts, WithCPULimit(p.CPU))
+	}
+	if p.Memory > 0 {
+		opts = append(opts, WithMemoryLimit(p.Memory))
+	}
+	if p.Pids > 0 {
+		opts = append(opts, WithPidsLimit(p.Pids))
+	}
+	if p.CPUSet != "" {
+		opts = append(opts, WithCPUSet(string(p.CPUSet)))
+	}
+	if len(p.IO) > 0 {
+		return nil, errors.New("io limits are not supported on windows")
+	}
+	return opts, nil
+}
+
+// NewFromProfile creates a new JobObject with the limits described by profile.
+// options are applied after the profile's limits, so they take precedence.
+func NewFromProfile(profile *Profile, options ...Option) (*JobObject, error) {
+	opts, err := profile.Options()
+	if err != nil {
+		return nil, err
+	}
+	return New(append(opts, options...)...)
+}
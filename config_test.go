@@ -0,0 +1,93 @@
+package proclimit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigYAML(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+profiles:
+  small:
+    cpu: 50
+    memory: 512Mi
+    pids: 100
+  build:
+    cpu: 400
+    memory: 1073741824
+    cpuset: 0-3
+    io:
+      - device: "8:0"
+        writeBps: 50MB
+        readIops: 1000
+`))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	expected := &Config{Profiles: map[string]*Profile{
+		"small": {CPU: 50, Memory: 512 * Megabyte, Pids: 100},
+		"build": {CPU: 400, Memory: Gigabyte, CPUSet: "0-3", IO: []IOProfile{
+			{Device: "8:0", WriteBPS: 50e6, ReadIOPS: 1000},
+		}},
+	}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %+v, but got %+v", expected, config)
+	}
+}
+
+func TestParseConfigJSON(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"profiles": {"small": {"cpu": 50, "memory": "1G"}}}`))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	profile, err := config.Profile("small")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if !reflect.DeepEqual(profile, &Profile{CPU: 50, Memory: Gigabyte}) {
+		t.Errorf("unexpected profile: %+v", profile)
+	}
+}
+
+func TestParseConfigCPUSetNumber(t *testing.T) {
+	config, err := ParseConfig([]byte("profiles:\n  pinned:\n    cpuset: 3\n"))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if cpus := config.Profiles["pinned"].CPUSet; cpus != "3" {
+		t.Errorf("expected cpuset \"3\", but got %q", cpus)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config string
+		error  string
+	}{
+		{"unknown top-level key", `{"profile": {}}`, "unknown field"},
+		{"unknown profile key", "profiles:\n  small:\n    cpus: 50\n", "unknown field"},
+		{"unknown io key", "profiles:\n  small:\n    io: [{device: '8:0', bps: 1}]\n", "unknown field"},
+		{"invalid memory", "profiles:\n  small:\n    memory: 1.5.3\n", "invalid memory value"},
+		{"overflowing memory", "profiles:\n  small:\n    memory: 9223372036854775808\n", "overflows"},
+		{"invalid cpuset", "profiles:\n  small:\n    cpuset: 1.5\n", "invalid cpuset 1.5"},
+		{"empty profile", "profiles:\n  small:\n", "profile \"small\" is empty"},
+		{"malformed", "profiles: [", "invalid config"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("expected error containing %q, but got: %v", tt.error, err)
+			}
+		})
+	}
+}
+
+func TestConfigUnknownProfile(t *testing.T) {
+	config := &Config{Profiles: map[string]*Profile{"b": {}, "a": {}}}
+	_, err := config.Profile("c")
+	if err == nil || !strings.Contains(err.Error(), "available profiles: a, b") {
+		t.Errorf("expected unknown profile error, but got: %v", err)
+	}
+}
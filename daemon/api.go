@@ -0,0 +1,71 @@
+// Package daemon implements a server that lets unprivileged processes create and
+// manage limiters over a Unix socket (see proclimit serve). The client package
+// provides a Go client for it.
+//
+// The API is HTTP with JSON bodies:
+//
+//	GET    /v1/limiters                list the limiters visible to the caller
+//	POST   /v1/limiters                create a limiter (CreateRequest)
+//	POST   /v1/limiters/<name>/limit   add a process to a limiter (LimitRequest)
+//	POST   /v1/limiters/<name>/update  change the limits of a limiter (UpdateRequest)
+//	GET    /v1/limiters/<name>/stats   get the resource usage of a limiter (proclimit.Stats)
+//	POST   /v1/limiters/<name>/signal  signal all processes in a limiter (SignalRequest)
+//	DELETE /v1/limiters/<name>         close a limiter
+//
+// Errors are returned with a non-2xx status and an ErrorResponse.
+package daemon
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"time"
+)
+
+// DefaultSocketPath is the path of the Unix socket used when none is specified
+const DefaultSocketPath = "/run/proclimit.sock"
+
+// CreateRequest is the body of a request to create a limiter
+type CreateRequest struct {
+	// Name of the limiter. If empty, a random name is generated.
+	Name string `json:"name,omitempty"`
+	// Limits are the resource limits of the limiter
+	Limits proclimit.Profile `json:"limits"`
+	// CPUTimeBudget is the total CPU time processes in the limiter may consume (see
+	// proclimit.WithCPUTimeBudget)
+	CPUTimeBudget time.Duration `json:"cpuTimeBudget,omitempty"`
+	// WallTimeout is the maximum time processes may run in the limiter (see
+	// proclimit.WithWallTimeout)
+	WallTimeout time.Duration `json:"wallTimeout,omitempty"`
+}
+
+// CreateResponse is the response to a CreateRequest
+type CreateResponse struct {
+	Name string `json:"name"`
+}
+
+// ListResponse is the response to a request to list limiters
+type ListResponse struct {
+	Names []string `json:"names"`
+}
+
+// LimitRequest is the body of a request to add a process to a limiter. Unless the
+// caller is root, the process must belong to the caller.
+type LimitRequest struct {
+	Pid int `json:"pid"`
+}
+
+// UpdateRequest is the body of a request to change the limits of a limiter. Limits
+// that are not specified are left unchanged.
+type UpdateRequest struct {
+	Limits proclimit.Profile `json:"limits"`
+}
+
+// SignalRequest is the body of a request to signal all processes in a limiter
+type SignalRequest struct {
+	// Signal is the signal number. Defaults to SIGKILL.
+	Signal int `json:"signal,omitempty"`
+}
+
+// ErrorResponse is the body of an unsuccessful response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
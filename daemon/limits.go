@@ -0,0 +1,171 @@
+// +build linux
+
+package daemon
+
+import (
+	"github.com/aoldershaw/proclimit/internal/cgroupfs"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// limitedControllers are the cgroup v1 controllers whose limits processes must not escape
+var limitedControllers = []string{"cpu", "memory", "pids", "blkio"}
+
+// unlimitedMemory is the memory limit at or above which a cgroup is considered unlimited
+const unlimitedMemory = 1 << 62
+
+// cgroupLimits are the limits of a cgroup, by the file that sets them (and the device, for
+// IO limits), e.g. "pids.max" or "blkio.throttle.read_bps_device 8:0". Lower values are
+// tighter. CPU limits are in cores.
+type cgroupLimits map[string]float64
+
+// tighten adds the limits of other that are tighter than those of l
+func (l cgroupLimits) tighten(other cgroupLimits) {
+	for name, value := range other {
+		if current, ok := l[name]; !ok || value < current {
+			l[name] = value
+		}
+	}
+}
+
+// loosens returns the first limit of current that l does not have, or has a higher value
+// for, if any
+func (l cgroupLimits) loosens(current cgroupLimits) (string, bool) {
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := l[name]; !ok || value > current[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// inheritedLimits returns the tightest limits of the cgroups at paths (by controller, as
+// read from /proc/<pid>/cgroup) and their ancestors, other than the root cgroup. Cgroups
+// for which skip returns true are ignored.
+func inheritedLimits(paths map[string]string, skip func(controller, p string) bool) (cgroupLimits, error) {
+	limits := cgroupLimits{}
+	for _, controller := range limitedControllers {
+		for p := paths[controller]; p != "" && p != "/"; p = path.Dir(p) {
+			if skip != nil && skip(controller, p) {
+				continue
+			}
+			l, err := readLimits(controller, p)
+			if err != nil {
+				return nil, err
+			}
+			limits.tighten(l)
+		}
+	}
+	return limits, nil
+}
+
+// cgroupDir returns the directory of the cgroup at p within the hierarchy of controller
+func cgroupDir(controller, p string) string {
+	root := cgroupfs.Root()
+	if root == "" {
+		root = "/sys/fs/cgroup"
+	}
+	return filepath.Join(root, controller, filepath.FromSlash(p))
+}
+
+// readLimits reads the limits of the cgroup at p within the hierarchy of controller.
+// Missing files (e.g. those of a cgroup that does not exist) set no limits.
+func readLimits(controller, p string) (cgroupLimits, error) {
+	dir := cgroupDir(controller, p)
+	read := func(file string) (string, bool, error) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, errors.Wrapf(err, "failed to read the limits of cgroup %s", p)
+		}
+		return strings.TrimSpace(string(data)), true, nil
+	}
+	limits := cgroupLimits{}
+	switch controller {
+	case "cpu":
+		quota, ok, err := read("cpu.cfs_quota_us")
+		if err != nil || !ok {
+			return limits, err
+		}
+		period, ok, err := read("cpu.cfs_period_us")
+		if err != nil || !ok {
+			return limits, err
+		}
+		q, qErr := strconv.ParseFloat(quota, 64)
+		per, perErr := strconv.ParseFloat(period, 64)
+		if qErr != nil || perErr != nil || per <= 0 {
+			return nil, errors.Errorf("invalid cpu limit %s/%s in cgroup %s", quota, period, p)
+		}
+		if q > 0 {
+			limits["cpu"] = q / per
+		}
+	case "memory":
+		for _, file := range []string{"memory.limit_in_bytes", "memory.memsw.limit_in_bytes"} {
+			value, ok, err := read(file)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, errors.Errorf("invalid %s %q in cgroup %s", file, value, p)
+			}
+			if v < unlimitedMemory {
+				limits[file] = v
+			}
+		}
+	case "pids":
+		value, ok, err := read("pids.max")
+		if err != nil || !ok || value == "max" {
+			return limits, err
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid pids.max %q in cgroup %s", value, p)
+		}
+		limits["pids.max"] = v
+	case "blkio":
+		for _, file := range []string{
+			"blkio.throttle.read_bps_device",
+			"blkio.throttle.write_bps_device",
+			"blkio.throttle.read_iops_device",
+			"blkio.throttle.write_iops_device",
+		} {
+			value, ok, err := read(file)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			// <major>:<minor> <limit>, per device
+			for _, line := range strings.Split(value, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) != 2 {
+					continue
+				}
+				v, err := strconv.ParseFloat(fields[1], 64)
+				if err != nil {
+					return nil, errors.Errorf("invalid %s %q in cgroup %s", file, line, p)
+				}
+				limits[file+" "+fields[0]] = v
+			}
+		}
+	}
+	return limits, nil
+}
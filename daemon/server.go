@@ -0,0 +1,575 @@
+// +build linux
+
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Timeouts of the requests to the Server. Requests are small, and are served quickly.
+const (
+	readTimeout  = 10 * time.Second
+	writeTimeout = time.Minute
+)
+
+// limiter is the subset of *proclimit.Cgroup used by the Server
+type limiter interface {
+	LimitProcess(h *proclimit.ProcessHandle) error
+	Update(options ...proclimit.Option) error
+	Stats() (*proclimit.Stats, error)
+	Processes() ([]int, error)
+	Signal(sig os.Signal) error
+	Close() error
+}
+
+// managedLimiter is a limiter created through the Server
+type managedLimiter struct {
+	limiter
+	// owner is the uid of the caller that created the limiter
+	owner uint32
+	// paths are the cgroups of the limiter, by controller, if it was created within the
+	// cgroups of its owner (see Server)
+	paths map[string]string
+}
+
+// Server serves the daemon API. Root may manage every limiter created through the
+// Server. Other callers must be listed in AllowedUIDs (or belong to one of the
+// AllowedGIDs), and may only manage the limiters they created, and only add and signal
+// their own processes.
+//
+// So that callers other than root cannot escape the limits set for them (e.g. by systemd),
+// their limiters are created within their current cgroups, whose limits still apply to
+// the processes of the limiters. A process can only be moved into a limiter if that does
+// not loosen any of the limits it is subject to - other than those of the caller's own
+// limiters, which the caller chose.
+type Server struct {
+	// AllowedUIDs are the users, besides root, that may use the Server
+	AllowedUIDs []uint32
+	// AllowedGIDs are the groups whose members may use the Server
+	AllowedGIDs []uint32
+	// SocketGID is the group of the socket created by ListenAndServe. Only root and the
+	// members of the group can connect to the socket. Defaults to 0 (root's group).
+	SocketGID uint32
+
+	mu       sync.Mutex
+	limiters map[string]*managedLimiter
+	http     *http.Server
+
+	// newLimiter creates the limiters. It is replaced in tests.
+	newLimiter func(options ...proclimit.Option) (limiter, error)
+	// processCgroups returns the cgroups of a process, by controller. It is replaced in tests.
+	processCgroups func(pid int) (map[string]string, error)
+}
+
+// ListenAndServe listens on the Unix socket at path, and serves the API until Close
+// is called. Any existing socket at path is replaced. The socket can be connected to
+// by root and the members of SocketGID, who are then authorized by their peer credentials.
+func (s *Server) ListenAndServe(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove existing socket")
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	if err := os.Chown(path, -1, int(s.SocketGID)); err != nil {
+		l.Close()
+		return errors.Wrap(err, "failed to set socket group")
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return errors.Wrap(err, "failed to set socket permissions")
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the API on l, which must be a Unix socket listener
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.limiters == nil {
+		s.limiters = map[string]*managedLimiter{}
+	}
+	if s.newLimiter == nil {
+		s.newLimiter = newCgroup
+	}
+	if s.processCgroups == nil {
+		s.processCgroups = procfs.ReadCgroups
+	}
+	s.http = &http.Server{
+		Handler:      s,
+		ConnContext:  withPeerCredentials,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+	server := s.http
+	s.mu.Unlock()
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Close stops serving the API, and closes all limiters that were created through the Server
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.http != nil {
+		err = s.http.Close()
+	}
+	for name, l := range s.limiters {
+		l.Close()
+		delete(s.limiters, name)
+	}
+	return err
+}
+
+// newCgroup creates an owned cgroup, which is collected by proclimit gc if the daemon
+// exits without closing it
+func newCgroup(options ...proclimit.Option) (limiter, error) {
+	return proclimit.New(append(options, proclimit.WithOwned())...)
+}
+
+type peerCredentialsKey struct{}
+
+// withPeerCredentials stores the credentials of the process connected to conn in ctx
+func withPeerCredentials(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsKey{}, cred)
+}
+
+// httpError is an error with an HTTP status code
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	response, err := s.handle(r)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if httpErr, ok := err.(*httpError); ok {
+			status = httpErr.status
+		}
+		writeJSON(w, status, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handle(r *http.Request) (interface{}, error) {
+	cred, ok := r.Context().Value(peerCredentialsKey{}).(*syscall.Ucred)
+	if !ok {
+		return nil, errorf(http.StatusForbidden, "peer credentials are unavailable")
+	}
+	if !s.allowed(cred) {
+		return nil, errorf(http.StatusForbidden, "uid %d is not allowed to use proclimit", cred.Uid)
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/limiters")
+	if path == r.URL.Path {
+		return nil, errorf(http.StatusNotFound, "not found")
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case parts[0] == "" && r.Method == http.MethodGet:
+		return s.list(cred), nil
+	case parts[0] == "" && r.Method == http.MethodPost:
+		var req CreateRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return s.create(cred, req)
+	case parts[0] == "":
+		return nil, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+
+	l, err := s.get(cred, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = strings.Join(parts[1:], "/")
+	}
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		return nil, s.delete(parts[0], l)
+	case action == "limit" && r.Method == http.MethodPost:
+		var req LimitRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, s.limit(cred, parts[0], l, req.Pid)
+	case action == "update" && r.Method == http.MethodPost:
+		var req UpdateRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		opts, err := req.Limits.Options()
+		if err != nil {
+			return nil, errorf(http.StatusBadRequest, "%v", err)
+		}
+		return nil, l.Update(opts...)
+	case action == "stats" && r.Method == http.MethodGet:
+		return l.Stats()
+	case action == "signal" && r.Method == http.MethodPost:
+		var req SignalRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		sig := syscall.SIGKILL
+		if req.Signal != 0 {
+			sig = syscall.Signal(req.Signal)
+		}
+		return nil, s.signal(cred, l, sig)
+	}
+	return nil, errorf(http.StatusNotFound, "not found")
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+// allowed reports whether the caller may use the Server at all
+func (s *Server) allowed(cred *syscall.Ucred) bool {
+	if cred.Uid == 0 {
+		return true
+	}
+	for _, uid := range s.AllowedUIDs {
+		if uid == cred.Uid {
+			return true
+		}
+	}
+	if len(s.AllowedGIDs) == 0 {
+		return false
+	}
+	gids := []uint32{cred.Gid}
+	if groups, err := processGroups(int(cred.Pid)); err == nil {
+		gids = append(gids, groups...)
+	}
+	for _, allowed := range s.AllowedGIDs {
+		for _, gid := range gids {
+			if gid == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) list(cred *syscall.Ucred) *ListResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name, l := range s.limiters {
+		if cred.Uid == 0 || l.owner == cred.Uid {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return &ListResponse{Names: names}
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+func (s *Server) create(cred *syscall.Ucred, req CreateRequest) (*CreateResponse, error) {
+	name := req.Name
+	if name == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		name = "proclimitd-" + hex.EncodeToString(b)
+	}
+	if !validName.MatchString(name) {
+		return nil, errorf(http.StatusBadRequest, "invalid name %q", name)
+	}
+	opts, err := req.Limits.Options()
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "%v", err)
+	}
+	opts = append(opts, proclimit.WithName(name))
+	if req.CPUTimeBudget > 0 {
+		opts = append(opts, proclimit.WithCPUTimeBudget(req.CPUTimeBudget))
+	}
+	if req.WallTimeout > 0 {
+		opts = append(opts, proclimit.WithWallTimeout(req.WallTimeout))
+	}
+
+	var paths map[string]string
+	if cred.Uid != 0 {
+		// The limiter is created within the cgroups of the caller, so that their limits
+		// still apply to its processes
+		parents, err := s.processCgroups(int(cred.Pid))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read the cgroups of process %d", cred.Pid)
+		}
+		paths = map[string]string{}
+		for controller, parent := range parents {
+			paths[controller] = path.Join(parent, name)
+		}
+		opts = append(opts, proclimit.WithParentCgroups(parents))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.limiters[name]; ok {
+		return nil, errorf(http.StatusConflict, "limiter %s already exists", name)
+	}
+	// Don't allow callers to take over cgroups that were not created through the Server
+	if paths == nil {
+		if _, err := proclimit.Existing(name); err == nil {
+			return nil, errorf(http.StatusConflict, "limiter %s already exists", name)
+		}
+	}
+	for controller, p := range paths {
+		if _, err := os.Stat(cgroupDir(controller, p)); err == nil {
+			return nil, errorf(http.StatusConflict, "limiter %s already exists", name)
+		}
+	}
+	l, err := s.newLimiter(opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.limiters[name] = &managedLimiter{limiter: l, owner: cred.Uid, paths: paths}
+	return &CreateResponse{Name: name}, nil
+}
+
+// get returns the named limiter, if the caller may manage it
+func (s *Server) get(cred *syscall.Ucred, name string) (*managedLimiter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limiters[name]
+	// Limiters owned by other users are reported as not found, so that their names are not revealed
+	if !ok || (cred.Uid != 0 && l.owner != cred.Uid) {
+		return nil, errorf(http.StatusNotFound, "limiter %s not found", name)
+	}
+	return l, nil
+}
+
+func (s *Server) limit(cred *syscall.Ucred, name string, l *managedLimiter, pid int) error {
+	if pid <= 0 {
+		return errorf(http.StatusBadRequest, "invalid pid %d", pid)
+	}
+	// The process is identified before it is checked, so that it cannot be replaced by
+	// another process that reuses its pid after it has been checked
+	h, err := proclimit.OpenProcess(pid)
+	if err != nil {
+		return errorf(http.StatusNotFound, "process %d not found", pid)
+	}
+	defer h.Release()
+	if cred.Uid != 0 {
+		if err := checkOwner(cred, pid); err != nil {
+			return err
+		}
+		if err := s.checkNotLoosened(cred, name, l, pid); err != nil {
+			return err
+		}
+	}
+	if err := l.LimitProcess(h); err != nil {
+		if _, ok := err.(*proclimit.ProcessGoneError); ok {
+			return errorf(http.StatusNotFound, "%v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkOwner returns an error if the process pid does not belong to the caller. All of
+// its uids must be the caller's: a process with another effective or saved uid (e.g. a
+// setuid program) has privileges the caller does not.
+func checkOwner(cred *syscall.Ucred, pid int) error {
+	uids, err := processUIDs(pid)
+	if err != nil {
+		return errorf(http.StatusNotFound, "process %d not found", pid)
+	}
+	for _, uid := range uids {
+		if uid != cred.Uid {
+			return errorf(http.StatusForbidden, "process %d does not belong to uid %d", pid, cred.Uid)
+		}
+	}
+	return nil
+}
+
+// checkNotLoosened returns an error if moving the process pid into the limiter l would
+// loosen any of the limits the process is subject to, other than those of the limiters
+// created through the Server by the caller
+func (s *Server) checkNotLoosened(cred *syscall.Ucred, name string, l *managedLimiter, pid int) error {
+	paths, err := s.processCgroups(pid)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the cgroups of process %d", pid)
+	}
+	s.mu.Lock()
+	owned := map[string]bool{}
+	for _, other := range s.limiters {
+		if other.owner != cred.Uid {
+			continue
+		}
+		for controller, p := range other.paths {
+			owned[controller+":"+p] = true
+		}
+	}
+	s.mu.Unlock()
+	current, err := inheritedLimits(paths, func(controller, p string) bool {
+		return owned[controller+":"+p]
+	})
+	if err != nil {
+		return err
+	}
+	limits, err := inheritedLimits(l.paths, nil)
+	if err != nil {
+		return err
+	}
+	if limit, ok := limits.loosens(current); ok {
+		return errorf(http.StatusForbidden, "process %d is subject to a lower %s limit than limiter %s, and may not be moved into it", pid, limit, name)
+	}
+	return nil
+}
+
+// signal sends sig to the processes of l. Callers other than root may only signal their
+// own processes, and those of the limiter that do not belong to them (e.g. because they
+// have since changed their uid) are skipped.
+func (s *Server) signal(cred *syscall.Ucred, l *managedLimiter, sig syscall.Signal) error {
+	if cred.Uid == 0 {
+		return l.Signal(sig)
+	}
+	pids, err := l.Processes()
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := signalOwned(cred, pid, sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signalOwned sends sig to the process pid if it belongs to the caller
+func signalOwned(cred *syscall.Ucred, pid int, sig syscall.Signal) error {
+	// The process is identified before it is checked, so that the signal cannot be sent
+	// to another process that reuses its pid
+	h, err := proclimit.OpenProcess(pid)
+	if err != nil {
+		return nil
+	}
+	defer h.Release()
+	if checkOwner(cred, pid) != nil {
+		return nil
+	}
+	if err := h.Signal(sig); err != nil {
+		if _, ok := err.(*proclimit.ProcessGoneError); ok {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to signal process %d", pid)
+	}
+	return nil
+}
+
+func (s *Server) delete(name string, l *managedLimiter) error {
+	if err := l.Close(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.limiters, name)
+	return nil
+}
+
+// processUIDs returns the real, effective, saved and filesystem uids of the process pid
+func processUIDs(pid int) ([]uint32, error) {
+	ids, err := statusIDs(pid, "Uid:")
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) != 4 {
+		return nil, errors.Errorf("invalid uids in /proc/%d/status", pid)
+	}
+	return ids, nil
+}
+
+// processGroups returns the supplementary groups of the process pid
+func processGroups(pid int) ([]uint32, error) {
+	return statusIDs(pid, "Groups:")
+}
+
+// statusIDs parses the ids on the line of /proc/<pid>/status starting with prefix
+func statusIDs(pid int, prefix string) ([]uint32, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		var ids []uint32
+		for _, field := range strings.Fields(strings.TrimPrefix(line, prefix)) {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid id in /proc/%d/status", pid)
+			}
+			ids = append(ids, uint32(id))
+		}
+		return ids, nil
+	}
+	return nil, errors.Errorf("/proc/%d/status has no %s line", pid, prefix)
+}
@@ -0,0 +1,419 @@
+// +build linux
+
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/proclimittest"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeLimiter struct {
+	pids    []int
+	signals []os.Signal
+	updates int
+	closed  bool
+}
+
+func (f *fakeLimiter) LimitProcess(h *proclimit.ProcessHandle) error {
+	f.pids = append(f.pids, h.Pid)
+	return nil
+}
+
+func (f *fakeLimiter) Update(options ...proclimit.Option) error {
+	f.updates++
+	return nil
+}
+
+func (f *fakeLimiter) Stats() (*proclimit.Stats, error) {
+	return &proclimit.Stats{Processes: len(f.pids)}, nil
+}
+
+func (f *fakeLimiter) Processes() ([]int, error) {
+	return f.pids, nil
+}
+
+func (f *fakeLimiter) Signal(sig os.Signal) error {
+	f.signals = append(f.signals, sig)
+	return nil
+}
+
+func (f *fakeLimiter) Close() error {
+	f.closed = true
+	return nil
+}
+
+func newTestServer() (*Server, map[string]*fakeLimiter) {
+	created := map[string]*fakeLimiter{}
+	s := &Server{
+		processCgroups: func(pid int) (map[string]string, error) {
+			return map[string]string{}, nil
+		},
+		limiters: map[string]*managedLimiter{},
+		newLimiter: func(options ...proclimit.Option) (limiter, error) {
+			c := &proclimit.Cgroup{LinuxResources: &specs.LinuxResources{}}
+			for _, opt := range options {
+				opt(c)
+			}
+			f := &fakeLimiter{}
+			created[c.Name] = f
+			return f, nil
+		},
+	}
+	return s, created
+}
+
+// request calls the server as the process with the given credentials
+func request(s *Server, cred *syscall.Ucred, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	r = r.WithContext(context.WithValue(r.Context(), peerCredentialsKey{}, cred))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestServerLifecycle(t *testing.T) {
+	s, created := newTestServer()
+	root := &syscall.Ucred{Pid: int32(os.Getpid())}
+
+	w := request(s, root, http.MethodPost, "/v1/limiters", CreateRequest{Name: "test", Limits: proclimit.Profile{Memory: proclimit.Gigabyte}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected create to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	var createResp CreateResponse
+	json.NewDecoder(w.Body).Decode(&createResp)
+	if createResp.Name != "test" {
+		t.Errorf("expected name test, but got %q", createResp.Name)
+	}
+	f := created["test"]
+	if f == nil {
+		t.Fatalf("expected limiter test to be created")
+	}
+
+	w = request(s, root, http.MethodGet, "/v1/limiters", nil)
+	var listResp ListResponse
+	json.NewDecoder(w.Body).Decode(&listResp)
+	if !reflect.DeepEqual(listResp.Names, []string{"test"}) {
+		t.Errorf("expected limiters [test], but got %v", listResp.Names)
+	}
+
+	if w := request(s, root, http.MethodPost, "/v1/limiters/test/limit", LimitRequest{Pid: os.Getpid()}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected limit to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	if !reflect.DeepEqual(f.pids, []int{os.Getpid()}) {
+		t.Errorf("expected pid %d to be limited, but got %v", os.Getpid(), f.pids)
+	}
+
+	if w := request(s, root, http.MethodPost, "/v1/limiters/test/update", UpdateRequest{Limits: proclimit.Profile{CPU: 50}}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected update to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	if f.updates != 1 {
+		t.Errorf("expected 1 update, but got %d", f.updates)
+	}
+
+	w = request(s, root, http.MethodGet, "/v1/limiters/test/stats", nil)
+	var stats proclimit.Stats
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.Processes != 1 {
+		t.Errorf("expected stats for 1 process, but got %+v", stats)
+	}
+
+	if w := request(s, root, http.MethodPost, "/v1/limiters/test/signal", SignalRequest{}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected signal to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	if !reflect.DeepEqual(f.signals, []os.Signal{syscall.SIGKILL}) {
+		t.Errorf("expected SIGKILL to be sent, but got %v", f.signals)
+	}
+
+	if w := request(s, root, http.MethodDelete, "/v1/limiters/test", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected delete to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	if !f.closed {
+		t.Errorf("expected limiter to be closed")
+	}
+	if w := request(s, root, http.MethodGet, "/v1/limiters/test/stats", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected deleted limiter not to be found, but got %d", w.Code)
+	}
+}
+
+func TestServerAuthorization(t *testing.T) {
+	s, _ := newTestServer()
+	s.AllowedUIDs = []uint32{1000, 1001}
+	s.AllowedGIDs = []uint32{2000}
+	owner := &syscall.Ucred{Uid: 1000, Gid: 1000}
+	other := &syscall.Ucred{Uid: 1001, Gid: 1001}
+	member := &syscall.Ucred{Uid: 1002, Gid: 2000}
+	stranger := &syscall.Ucred{Uid: 1003, Gid: 1003}
+	root := &syscall.Ucred{}
+
+	if w := request(s, stranger, http.MethodGet, "/v1/limiters", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected a caller that is not allowed to be forbidden, but got %d", w.Code)
+	}
+	if w := request(s, member, http.MethodGet, "/v1/limiters", nil); w.Code != http.StatusOK {
+		t.Errorf("expected a member of an allowed group to be allowed, but got %d", w.Code)
+	}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: "owned"}); w.Code != http.StatusOK {
+		t.Fatalf("expected create to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	if w := request(s, other, http.MethodGet, "/v1/limiters/owned/stats", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected another user's limiter not to be found, but got %d", w.Code)
+	}
+	w := request(s, other, http.MethodGet, "/v1/limiters", nil)
+	var listResp ListResponse
+	json.NewDecoder(w.Body).Decode(&listResp)
+	if len(listResp.Names) != 0 {
+		t.Errorf("expected another user's limiters not to be listed, but got %v", listResp.Names)
+	}
+	if w := request(s, root, http.MethodGet, "/v1/limiters/owned/stats", nil); w.Code != http.StatusOK {
+		t.Errorf("expected root to manage any limiter, but got %d", w.Code)
+	}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: "owned"}); w.Code != http.StatusConflict {
+		t.Errorf("expected creating an existing limiter to conflict, but got %d", w.Code)
+	}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: "../escape"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid name to be rejected, but got %d", w.Code)
+	}
+	// Process 1 belongs to root
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/owned/limit", LimitRequest{Pid: 1}); w.Code != http.StatusForbidden {
+		t.Errorf("expected limiting another user's process to be forbidden, but got %d", w.Code)
+	}
+}
+
+func TestServerPeerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proclimit-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "proclimit.sock")
+
+	s, _ := newTestServer()
+	s.AllowedUIDs = []uint32{uint32(os.Getuid())}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://proclimit/v1/limiters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("expected the caller to be identified and allowed, but got %d: %s", resp.StatusCode, body)
+	}
+}
+
+// startProcess starts sleep as uid 1000, or skips the test if it cannot
+func startProcess(t *testing.T, name string, arg ...string) *exec.Cmd {
+	cmd := exec.Command(name, arg...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 1000, Gid: 1000}}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start a process as uid 1000: %v", err)
+	}
+	return cmd
+}
+
+func TestServerLimitInheritedLimits(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	// systemd sets pids.max on the slices of users (TasksMax)
+	for p, max := range map[string]string{"user.slice": "100", "user.slice/session.scope": "10"} {
+		if err := os.MkdirAll(fs.Path("pids", p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(fs.Path("pids", p), "pids.max"), []byte(max), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, created := newTestServer()
+	s.AllowedUIDs = []uint32{1000}
+	owner := &syscall.Ucred{Uid: 1000, Gid: 1000, Pid: int32(os.Getpid())}
+	cmd := startProcess(t, "sleep", "10")
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	// The caller is in the user's slice, and so are its limiters
+	processPaths := map[string]string{"pids": "/user.slice"}
+	s.processCgroups = func(p int) (map[string]string, error) {
+		if p == os.Getpid() {
+			return map[string]string{"pids": "/user.slice"}, nil
+		}
+		return processPaths, nil
+	}
+	for _, name := range []string{"first", "second"} {
+		if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: name}); w.Code != http.StatusOK {
+			t.Fatalf("expected create to succeed, but got %d: %s", w.Code, w.Body)
+		}
+	}
+	if err := os.Mkdir(fs.Path("pids", "user.slice/taken"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: "taken"}); w.Code != http.StatusConflict {
+		t.Errorf("expected taking over an existing cgroup to conflict, but got %d", w.Code)
+	}
+
+	// The limits of the slice still apply within the limiter
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/first/limit", LimitRequest{Pid: pid}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected limit to succeed, but got %d: %s", w.Code, w.Body)
+	}
+
+	// A process with a lower limit than the limiter may not escape it
+	processPaths = map[string]string{"pids": "/user.slice/session.scope"}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/second/limit", LimitRequest{Pid: pid}); w.Code != http.StatusForbidden {
+		t.Errorf("expected loosening the limits of a process to be forbidden, but got %d", w.Code)
+	}
+	if len(created["second"].pids) != 0 {
+		t.Errorf("expected the process not to be limited, but got %v", created["second"].pids)
+	}
+	if err := os.MkdirAll(fs.Path("pids", "user.slice/second"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fs.Path("pids", "user.slice/second"), "pids.max"), []byte("5"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/second/limit", LimitRequest{Pid: pid}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected limit to succeed once the limiter is as tight, but got %d: %s", w.Code, w.Body)
+	}
+
+	// The limits of the caller's own limiters were chosen by the caller
+	processPaths = map[string]string{"pids": "/user.slice/second"}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/first/limit", LimitRequest{Pid: pid}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected moving a process between the caller's limiters to succeed, but got %d: %s", w.Code, w.Body)
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/first/limit", LimitRequest{Pid: pid}); w.Code != http.StatusNotFound {
+		t.Errorf("expected an exited process not to be found, but got %d", w.Code)
+	}
+}
+
+func TestServerLimitSetuidProcess(t *testing.T) {
+	s, created := newTestServer()
+	s.AllowedUIDs = []uint32{1000}
+	owner := &syscall.Ucred{Uid: 1000, Gid: 1000}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: "test"}); w.Code != http.StatusOK {
+		t.Fatalf("expected create to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	// Like a running setuid program, the process has the real uid of the caller, but the
+	// effective and saved uids of root
+	cmd := exec.Command("perl", "-e", "$| = 1; $< = 1000; print qq(ready\n); sleep 10")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start perl: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	if _, err := stdout.Read(make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/test/limit", LimitRequest{Pid: cmd.Process.Pid}); w.Code != http.StatusForbidden {
+		t.Errorf("expected limiting a process with other effective uids to be forbidden, but got %d", w.Code)
+	}
+	if len(created["test"].pids) != 0 {
+		t.Errorf("expected the process not to be limited, but got %v", created["test"].pids)
+	}
+}
+
+func TestServerSignalOwnedProcesses(t *testing.T) {
+	s, created := newTestServer()
+	s.AllowedUIDs = []uint32{1000}
+	owner := &syscall.Ucred{Uid: 1000, Gid: 1000}
+	if w := request(s, owner, http.MethodPost, "/v1/limiters", CreateRequest{Name: "test"}); w.Code != http.StatusOK {
+		t.Fatalf("expected create to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	owned := startProcess(t, "sleep", "10")
+	defer owned.Wait()
+	defer owned.Process.Kill()
+	// A process that has changed its uid since it was limited (e.g. through sudo)
+	other := exec.Command("sleep", "10")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Wait()
+	defer other.Process.Kill()
+	created["test"].pids = []int{owned.Process.Pid, other.Process.Pid}
+
+	if w := request(s, owner, http.MethodPost, "/v1/limiters/test/signal", SignalRequest{Signal: int(syscall.SIGTERM)}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected signal to succeed, but got %d: %s", w.Code, w.Body)
+	}
+	if err := owned.Wait(); err == nil {
+		t.Errorf("expected the caller's process to be signalled")
+	}
+	if err := other.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("expected the process of another user not to be signalled, but got: %v", err)
+	}
+	if len(created["test"].signals) != 0 {
+		t.Errorf("expected the limiter not to signal every process, but got %v", created["test"].signals)
+	}
+}
+
+func TestServerSocketPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proclimit-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "proclimit.sock")
+
+	s, _ := newTestServer()
+	s.SocketGID = 1001
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServe(socketPath)
+	}()
+	defer s.Close()
+	var info os.FileInfo
+	for i := 0; i < 100; i++ {
+		if info, err = os.Stat(socketPath); err == nil && info.Mode().Perm() == 0660 {
+			break
+		}
+		select {
+		case err := <-errs:
+			t.Fatalf("failed to serve: %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if info == nil || info.Mode().Perm() != 0660 {
+		t.Fatalf("expected the socket to have mode 0660, but got %v", info)
+	}
+	if gid := info.Sys().(*syscall.Stat_t).Gid; gid != 1001 {
+		t.Errorf("expected the socket to belong to group 1001, but got %d", gid)
+	}
+}
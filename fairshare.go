@@ -0,0 +1,256 @@
+package proclimit
+
+import (
+	"context"
+	"github.com/friendsofgo/errors"
+	"math"
+	"time"
+)
+
+// defaultFairShareInterval is how often CPU is rebalanced if no Interval is specified
+const defaultFairShareInterval = time.Second
+
+const (
+	// fairShareSaturation is the fraction of its CPU limit above which a group is
+	// considered busy, i.e. it would use more CPU if it were allowed to
+	fairShareSaturation = 0.9
+	// fairShareHeadroom is the fraction of its usage that a group that is not busy is
+	// allowed on top of its usage, so that it can grow before the next rebalance
+	fairShareHeadroom = 0.2
+)
+
+// FairShareLimiter is a limiter whose CPU limit can be managed by FairShare, such as a *Cgroup
+type FairShareLimiter interface {
+	Stats() (*Stats, error)
+	Update(options ...Option) error
+}
+
+// FairShareGroup is a limiter that shares the CPU budget of a FairShare
+type FairShareGroup struct {
+	// Limiter is the limiter whose CPU limit is rewritten
+	Limiter FairShareLimiter
+	// Weight is the share of the budget the group receives relative to the other groups,
+	// when they are all busy. Defaults to 1.
+	Weight uint
+	// Minimum is the CPU the group is guaranteed, however busy the other groups are
+	Minimum Percent
+}
+
+// FairShare periodically rebalances a total CPU budget between a set of limiters, so
+// that the CPU left unused by idle limiters flows to busy ones. Each group is
+// guaranteed its Minimum, and the rest of the budget is divided in proportion to
+// Weight - except that groups using less than their share only keep what they use
+// (plus some headroom), and the difference is divided between the others.
+//
+// The CPU limits of the groups are rewritten with WithCPULimit, so they should not be
+// updated by anything else while FairShare is running.
+type FairShare struct {
+	// Total is the CPU budget shared by the groups, relative to a single core (e.g. 400
+	// is 4 cores)
+	Total Percent
+	// Groups are the limiters that share the budget
+	Groups []*FairShareGroup
+	// Interval is how often usage is measured and the budget is rebalanced. Defaults to 1s.
+	Interval time.Duration
+	// OnRebalance is called, if set, after the budget has been rebalanced, with the CPU
+	// limit of each group (in the order of Groups)
+	OnRebalance func(limits []Percent)
+
+	// now returns the current time. It is replaced in tests.
+	now func() time.Time
+}
+
+// fairShareState is the CPU limit of a group, and its usage when it was last measured
+type fairShareState struct {
+	// limit is 0 until the limit has been set
+	limit Percent
+	// measured is zero until the usage has been measured
+	measured  time.Time
+	cpuUsage  time.Duration
+	throttled uint64
+}
+
+// Run divides the budget between the groups immediately (as if they were all busy), and
+// then rebalances it every Interval, until ctx is done. Groups whose Stats cannot be read
+// keep their limit. Run returns the first error updating the limit of a group.
+func (f *FairShare) Run(ctx context.Context) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	interval := f.Interval
+	if interval <= 0 {
+		interval = defaultFairShareInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	states := make([]fairShareState, len(f.Groups))
+	for {
+		if err := f.rebalance(states); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (f *FairShare) validate() error {
+	if f.Total == 0 {
+		return errors.New("fair share: no CPU budget specified")
+	}
+	if len(f.Groups) == 0 {
+		return errors.New("fair share: no groups specified")
+	}
+	var minimums Percent
+	for _, g := range f.Groups {
+		minimums += g.Minimum
+	}
+	if minimums > f.Total {
+		return errors.Errorf("fair share: the minimums of the groups (%d%%) exceed the budget (%d%%)", minimums, f.Total)
+	}
+	if f.Total < Percent(len(f.Groups)) {
+		return errors.Errorf("fair share: the budget (%d%%) is less than 1%% per group", f.Total)
+	}
+	return nil
+}
+
+// rebalance measures the demand of each group, and rewrites their CPU limits
+func (f *FairShare) rebalance(states []fairShareState) error {
+	minimums := make([]float64, len(f.Groups))
+	weights := make([]float64, len(f.Groups))
+	demands := make([]float64, len(f.Groups))
+	for i, g := range f.Groups {
+		minimums[i] = float64(g.Minimum)
+		weights[i] = 1
+		if g.Weight > 0 {
+			weights[i] = float64(g.Weight)
+		}
+		demands[i] = measureDemand(g, &states[i], f.clock())
+	}
+	allocations := allocate(float64(f.Total), minimums, weights, demands)
+	limits := make([]Percent, len(f.Groups))
+	for i := range f.Groups {
+		// Allocations are rounded down (allowing for floating point error), so that the
+		// limits do not exceed the budget
+		limits[i] = Percent(allocations[i] + 1e-6)
+	}
+	for i := range limits {
+		if limits[i] > 0 {
+			continue
+		}
+		// A limit of 0 would not be valid, so the group is given 1%, which is taken from
+		// the group with the largest limit above its minimum so that the limits still fit
+		// the budget
+		limits[i] = 1
+		var sum Percent
+		largest := -1
+		for j, g := range f.Groups {
+			sum += limits[j]
+			if limits[j] > 1 && limits[j] > g.Minimum && (largest < 0 || limits[j] > limits[largest]) {
+				largest = j
+			}
+		}
+		if sum <= f.Total {
+			continue
+		}
+		if largest < 0 {
+			return errors.Errorf("fair share: the budget (%d%%) is too small to give every group 1%% and its minimum", f.Total)
+		}
+		limits[largest]--
+	}
+	for i, g := range f.Groups {
+		if states[i].limit == limits[i] {
+			continue
+		}
+		if err := g.Limiter.Update(WithCPULimit(limits[i])); err != nil {
+			return errors.Wrap(err, "fair share: failed to update CPU limit")
+		}
+		states[i].limit = limits[i]
+	}
+	if f.OnRebalance != nil {
+		f.OnRebalance(limits)
+	}
+	return nil
+}
+
+// clock returns the current time
+func (f *FairShare) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// measureDemand returns the CPU the group needs, based on its usage since it was last
+// measured at now. It is +Inf if the group is busy, or if its usage is not known yet.
+func measureDemand(g *FairShareGroup, state *fairShareState, now time.Time) float64 {
+	stats, err := g.Limiter.Stats()
+	if err != nil {
+		if state.limit > 0 {
+			return float64(state.limit)
+		}
+		return math.Inf(1)
+	}
+	prev := *state
+	state.measured = now
+	state.cpuUsage = stats.CPUUsage
+	state.throttled = stats.ThrottledPeriods
+	elapsed := state.measured.Sub(prev.measured)
+	if prev.measured.IsZero() || prev.limit == 0 || elapsed <= 0 {
+		return math.Inf(1)
+	}
+	usage := float64(stats.CPUUsage-prev.cpuUsage) / float64(elapsed) * 100
+	if usage >= fairShareSaturation*float64(prev.limit) || stats.ThrottledPeriods > prev.throttled {
+		return math.Inf(1)
+	}
+	return usage * (1 + fairShareHeadroom)
+}
+
+// allocate divides total between groups by weighted max-min fairness. Each group needs
+// its demand, and at least its minimum. If every need can be met, the CPU that is left
+// is divided in proportion to weight. Otherwise, each group receives a share in
+// proportion to its weight, but no less than its minimum and no more than it needs -
+// what it does not need is divided between the other groups.
+func allocate(total float64, minimums, weights, demands []float64) []float64 {
+	allocations := make([]float64, len(weights))
+	needs := make([]float64, len(weights))
+	var needed, weightSum float64
+	minWeight := math.Inf(1)
+	for i := range needs {
+		needs[i] = math.Max(demands[i], minimums[i])
+		needed += needs[i]
+		weightSum += weights[i]
+		minWeight = math.Min(minWeight, weights[i])
+	}
+	if needed <= total {
+		for i := range allocations {
+			allocations[i] = needs[i] + (total-needed)*weights[i]/weightSum
+		}
+		return allocations
+	}
+	// The CPU allocated grows with the level that shares are proportional to, so the
+	// level at which the whole budget is allocated is found by bisection
+	share := func(i int, level float64) float64 {
+		return math.Min(math.Max(weights[i]*level, minimums[i]), needs[i])
+	}
+	low, high := 0.0, total/minWeight
+	for iteration := 0; iteration < 100; iteration++ {
+		level := (low + high) / 2
+		var allocated float64
+		for i := range allocations {
+			allocated += share(i, level)
+		}
+		if allocated > total {
+			high = level
+		} else {
+			low = level
+		}
+	}
+	for i := range allocations {
+		allocations[i] = share(i, low)
+	}
+	return allocations
+}
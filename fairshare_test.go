@@ -0,0 +1,178 @@
+package proclimit
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllocate(t *testing.T) {
+	busy := math.Inf(1)
+	for _, tt := range []struct {
+		description string
+		total       float64
+		minimums    []float64
+		weights     []float64
+		demands     []float64
+		expected    []float64
+	}{
+		{"all busy", 300, []float64{0, 0, 0}, []float64{1, 1, 1}, []float64{busy, busy, busy}, []float64{100, 100, 100}},
+		{"weighted", 400, []float64{0, 0}, []float64{3, 1}, []float64{busy, busy}, []float64{300, 100}},
+		{"idle group", 200, []float64{0, 0}, []float64{1, 1}, []float64{busy, 20}, []float64{180, 20}},
+		{"unused share is redistributed", 300, []float64{0, 0, 0}, []float64{1, 1, 1}, []float64{busy, 50, busy}, []float64{125, 50, 125}},
+		{"minimums", 200, []float64{0, 150}, []float64{1, 1}, []float64{busy, busy}, []float64{50, 150}},
+		{"minimums below the share", 200, []float64{50, 0}, []float64{1, 1}, []float64{busy, busy}, []float64{100, 100}},
+		{"idle group keeps its minimum", 200, []float64{50, 0}, []float64{1, 1}, []float64{10, busy}, []float64{50, 150}},
+		{"spare is divided by weight", 100, []float64{0, 0}, []float64{1, 3}, []float64{10, 30}, []float64{25, 75}},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			allocations := allocate(tt.total, tt.minimums, tt.weights, tt.demands)
+			for i := range allocations {
+				allocations[i] = math.Round(allocations[i]*1000) / 1000
+			}
+			if !reflect.DeepEqual(allocations, tt.expected) {
+				t.Errorf("expected %v, but got %v", tt.expected, allocations)
+			}
+		})
+	}
+}
+
+// fairShareSpyLimiter reports a CPU usage, and counts the updates of its limits
+type fairShareSpyLimiter struct {
+	cpuUsage time.Duration
+	updates  int
+}
+
+func (s *fairShareSpyLimiter) Stats() (*Stats, error) {
+	return &Stats{CPUUsage: s.cpuUsage}, nil
+}
+
+func (s *fairShareSpyLimiter) Update(options ...Option) error {
+	s.updates++
+	return nil
+}
+
+func TestFairShareRebalance(t *testing.T) {
+	idle, busy := &fairShareSpyLimiter{}, &fairShareSpyLimiter{}
+	var limits []Percent
+	f := &FairShare{
+		Total: 200,
+		Groups: []*FairShareGroup{
+			{Limiter: idle, Minimum: 10},
+			{Limiter: busy},
+		},
+		OnRebalance: func(l []Percent) {
+			limits = l
+		},
+	}
+	now := time.Now()
+	f.now = func() time.Time {
+		return now
+	}
+	states := make([]fairShareState, 2)
+
+	// Until their usage is known, the groups are assumed to be busy
+	if err := f.rebalance(states); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limits, []Percent{100, 100}) {
+		t.Errorf("expected the budget to be divided equally, but got %v", limits)
+	}
+
+	// Over a second, the idle group uses 5% of a core, and the busy group all of its limit
+	now = now.Add(time.Second)
+	idle.cpuUsage += 50 * time.Millisecond
+	busy.cpuUsage += time.Second
+	if err := f.rebalance(states); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limits, []Percent{10, 190}) {
+		t.Errorf("expected the idle group's share to flow to the busy group, but got %v", limits)
+	}
+
+	// Limits are only updated when they change
+	now = now.Add(time.Second)
+	idle.cpuUsage += 50 * time.Millisecond
+	busy.cpuUsage += 1900 * time.Millisecond
+	if err := f.rebalance(states); err != nil {
+		t.Fatal(err)
+	}
+	if idle.updates != 2 || busy.updates != 2 {
+		t.Errorf("expected each limit to be updated twice, but got %d and %d", idle.updates, busy.updates)
+	}
+}
+
+func TestFairShareMinimumLimit(t *testing.T) {
+	var limits []Percent
+	f := &FairShare{
+		Total: 100,
+		Groups: []*FairShareGroup{
+			{Limiter: &fairShareSpyLimiter{}, Weight: 1000},
+			{Limiter: &fairShareSpyLimiter{}},
+			{Limiter: &fairShareSpyLimiter{}},
+		},
+		OnRebalance: func(l []Percent) {
+			limits = l
+		},
+	}
+	// The small groups are allocated less than 1%, but are given 1% at the expense of the
+	// largest group, so that the budget is not exceeded
+	if err := f.rebalance(make([]fairShareState, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limits, []Percent{98, 1, 1}) {
+		t.Errorf("expected limits [98 1 1], but got %v", limits)
+	}
+}
+
+func TestFairShareMinimumLimitKeepsMinimums(t *testing.T) {
+	var limits []Percent
+	f := &FairShare{
+		Total: 100,
+		Groups: []*FairShareGroup{
+			{Limiter: &fairShareSpyLimiter{}, Minimum: 60},
+			{Limiter: &fairShareSpyLimiter{}, Weight: 1000},
+			{Limiter: &fairShareSpyLimiter{}},
+			{Limiter: &fairShareSpyLimiter{}},
+		},
+		OnRebalance: func(l []Percent) {
+			limits = l
+		},
+	}
+	// The largest group is at its minimum, so the small groups' 1% is taken from the next
+	// largest group
+	if err := f.rebalance(make([]fairShareState, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(limits, []Percent{60, 38, 1, 1}) {
+		t.Errorf("expected limits [60 38 1 1], but got %v", limits)
+	}
+
+	// When every group is at its minimum or 1%, the small groups cannot be given 1%
+	f.Groups = []*FairShareGroup{
+		{Limiter: &fairShareSpyLimiter{}, Minimum: 99},
+		{Limiter: &fairShareSpyLimiter{}},
+		{Limiter: &fairShareSpyLimiter{}},
+	}
+	err := f.rebalance(make([]fairShareState, 3))
+	if err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("expected the budget to be too small, but got %v", err)
+	}
+}
+
+func TestFairShareInvalid(t *testing.T) {
+	f := &FairShare{
+		Total: 100,
+		Groups: []*FairShareGroup{
+			{Limiter: &fairShareSpyLimiter{}, Minimum: 60},
+			{Limiter: &fairShareSpyLimiter{}, Minimum: 60},
+		},
+	}
+	err := f.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exceed the budget") {
+		t.Errorf("expected the minimums to be rejected, but got: %v", err)
+	}
+}
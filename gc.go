@@ -0,0 +1,16 @@
+package proclimit
+
+// StaleLimiter is an owned limiter (see WithOwned) whose owner (the process that created
+// it) has exited without closing it
+type StaleLimiter struct {
+	Name string
+	// OwnerPid is the pid of the owner
+	OwnerPid int
+	// Processes is the number of processes still running within the limiter. Limiters
+	// with processes are not removed by CleanupStale.
+	Processes int
+	// Removed is whether the limiter was removed
+	Removed bool
+	// Err is the error that occurred removing the limiter, if any
+	Err error
+}
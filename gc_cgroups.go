@@ -0,0 +1,75 @@
+// +build linux
+
+package proclimit
+
+import (
+	"github.com/containerd/cgroups"
+	"github.com/friendsofgo/errors"
+	"os"
+	"sort"
+)
+
+// FindStale returns the owned Cgroups (see WithOwned) whose owner has exited without
+// closing them, because it is no longer running or ran during a previous boot. Cgroups
+// that are not owned are never stale.
+func FindStale() ([]StaleLimiter, error) {
+	names, err := List()
+	if err != nil {
+		return nil, err
+	}
+	var stale []StaleLimiter
+	for _, name := range names {
+		m, err := readMetadata(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Closed since it was listed
+				continue
+			}
+			return nil, err
+		}
+		if !m.Owned || m.Creator == nil || m.Creator.running() {
+			continue
+		}
+		stale = append(stale, StaleLimiter{Name: name, OwnerPid: m.Creator.Pid})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].Name < stale[j].Name
+	})
+	for i := range stale {
+		if c, err := Existing(stale[i].Name); err == nil {
+			if pids, err := c.Processes(); err == nil {
+				stale[i].Processes = len(pids)
+			}
+		}
+	}
+	return stale, nil
+}
+
+// CleanupStale removes the stale Cgroups returned by FindStale that have no processes
+// running within them, and returns all stale Cgroups. Cgroups that could not be removed
+// have Err set.
+func CleanupStale() ([]StaleLimiter, error) {
+	stale, err := FindStale()
+	if err != nil {
+		return nil, err
+	}
+	for i := range stale {
+		s := &stale[i]
+		if s.Processes > 0 {
+			continue
+		}
+		c, err := Existing(s.Name)
+		if err == nil {
+			err = c.Close()
+		} else if errors.Cause(err) == cgroups.ErrCgroupDeleted {
+			// Only the metadata remains
+			err = removeMetadata(s.Name)
+		}
+		if err != nil {
+			s.Err = errors.Wrapf(err, "failed to remove %s", s.Name)
+			continue
+		}
+		s.Removed = true
+	}
+	return stale, nil
+}
@@ -0,0 +1,112 @@
+// +build linux
+
+package proclimit_test
+
+import (
+	"encoding/json"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/proclimittest"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// exitedPid returns the pid of a process that has exited
+func exitedPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// orphan changes the recorded owner of the named cgroup to a process that has exited
+func orphan(t *testing.T, fs *proclimittest.CgroupFS, name string, pid int) {
+	path := filepath.Join(fs.StateDir, name+".json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	m["creator"].(map[string]interface{})["pid"] = pid
+	data, _ = json.Marshal(m)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanupStale(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	live, err := proclimit.New(proclimit.WithName("live"), proclimit.WithOwned())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	if _, err := proclimit.New(proclimit.WithName("leaked"), proclimit.WithOwned()); err != nil {
+		t.Fatal(err)
+	}
+	// Cgroups that are not owned outlive their creator
+	if _, err := proclimit.New(proclimit.WithName("persistent")); err != nil {
+		t.Fatal(err)
+	}
+	// Cgroups with generated names are owned
+	generated, err := proclimit.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := proclimit.New(proclimit.WithName("busy"), proclimit.WithOwned())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	cmd := busy.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	// A cgroup with a generated name whose metadata was lost, whose owner is not known
+	if err := os.Mkdir(fs.Path("freezer", "proclimit-lost"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pid := exitedPid(t)
+	orphan(t, fs, "leaked", pid)
+	orphan(t, fs, "busy", pid)
+	orphan(t, fs, "persistent", pid)
+	orphan(t, fs, generated.Name, pid)
+
+	stale, err := proclimit.CleanupStale()
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	expected := []proclimit.StaleLimiter{
+		{Name: "busy", OwnerPid: pid, Processes: 1},
+		{Name: "leaked", OwnerPid: pid, Removed: true},
+		{Name: generated.Name, OwnerPid: pid, Removed: true},
+	}
+	if len(stale) != len(expected) {
+		t.Fatalf("expected stale limiters %+v, but got %+v", expected, stale)
+	}
+	for i := range expected {
+		if stale[i] != expected[i] {
+			t.Errorf("expected %+v, but got %+v", expected[i], stale[i])
+		}
+	}
+	names, _ := proclimit.List()
+	if len(names) != 3 || names[0] != "busy" || names[1] != "live" || names[2] != "persistent" {
+		t.Errorf("expected busy, live and persistent to remain, but got %v", names)
+	}
+	if _, err := os.Stat(fs.Path("freezer", "proclimit-lost")); err != nil {
+		t.Errorf("expected proclimit-lost to remain")
+	}
+}
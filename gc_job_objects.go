@@ -0,0 +1,15 @@
+// +build windows
+
+package proclimit
+
+// FindStale always returns no limiters on Windows. A job object is destroyed by the
+// system once the last handle to it is closed (which happens when its owner exits) and
+// its processes have exited.
+func FindStale() ([]StaleLimiter, error) {
+	return nil, nil
+}
+
+// CleanupStale always returns no limiters on Windows (see FindStale)
+func CleanupStale() ([]StaleLimiter, error) {
+	return nil, nil
+}
@@ -7,4 +7,5 @@ require (
 	github.com/friendsofgo/errors v0.9.2
 	github.com/google/uuid v1.1.1
 	github.com/opencontainers/runtime-spec v0.1.2-0.20190507144316-5b71a03e2700
+	sigs.k8s.io/yaml v1.2.0
 )
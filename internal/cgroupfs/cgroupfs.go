@@ -0,0 +1,44 @@
+// Package cgroupfs configures where proclimit finds cgroups. It is shared by proclimit and
+// proclimittest, so that tests can point proclimit at a fake hierarchy without proclimit
+// exporting a way to do so.
+package cgroupfs
+
+import "sync"
+
+// DefaultStateDir is the directory in which proclimit records the metadata of cgroups
+const DefaultStateDir = "/run/proclimit"
+
+var (
+	mu       sync.RWMutex
+	root     string
+	stateDir = DefaultStateDir
+)
+
+// Root returns the directory of the cgroup v1 hierarchy to use instead of the one mounted
+// by the system, or "" to use the system's
+func Root() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return root
+}
+
+// StateDir returns the directory in which proclimit records the metadata of cgroups
+func StateDir() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return stateDir
+}
+
+// Set makes proclimit use the cgroup v1 hierarchy at cgroupRoot, and record metadata in
+// metadataDir. The returned function restores the previous configuration.
+func Set(cgroupRoot, metadataDir string) (restore func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	prevRoot, prevStateDir := root, stateDir
+	root, stateDir = cgroupRoot, metadataDir
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		root, stateDir = prevRoot, prevStateDir
+	}
+}
@@ -0,0 +1,39 @@
+// +build linux
+
+package procfs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadCgroups reads /proc/<pid>/cgroup, and returns the cgroup of the process in each
+// cgroup v1 hierarchy, by controller (e.g. "memory")
+func ReadCgroups(pid int) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseCgroups(data), nil
+}
+
+// ParseCgroups parses the contents of /proc/<pid>/cgroup. The cgroup v2 hierarchy, which
+// has no controllers listed, is not included.
+func ParseCgroups(data []byte) map[string]string {
+	paths := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		// <hierarchy id>:<comma-separated controllers>:<path>
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller != "" {
+				paths[controller] = parts[2]
+			}
+		}
+	}
+	return paths
+}
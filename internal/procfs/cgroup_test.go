@@ -0,0 +1,39 @@
+// +build linux
+
+package procfs
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCgroups(t *testing.T) {
+	paths := ParseCgroups([]byte("12:pids:/user.slice/user-1000.slice\n" +
+		"4:cpu,cpuacct:/user.slice\n" +
+		"1:name=systemd:/user.slice/user-1000.slice/session-2.scope\n" +
+		"0::/user.slice/user-1000.slice/session-2.scope\n"))
+	expected := map[string]string{
+		"pids":         "/user.slice/user-1000.slice",
+		"cpu":          "/user.slice",
+		"cpuacct":      "/user.slice",
+		"name=systemd": "/user.slice/user-1000.slice/session-2.scope",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, but got %v", expected, paths)
+	}
+}
+
+func TestReadCgroups(t *testing.T) {
+	paths, err := ReadCgroups(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := paths["memory"]; !ok {
+		t.Skipf("the memory controller is not mounted: %v", paths)
+	}
+	if !strings.HasPrefix(paths["memory"], "/") {
+		t.Errorf("expected an absolute cgroup path, but got %q", paths["memory"])
+	}
+}
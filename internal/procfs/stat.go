@@ -0,0 +1,99 @@
+// +build linux
+
+// Package procfs reads the state of processes from /proc
+package procfs
+
+import (
+	"bytes"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stat is the subset of /proc/<pid>/stat used by proclimit
+type Stat struct {
+	Pid int
+	// Comm is the command name, without the surrounding parentheses
+	Comm string
+	// State is the state of the process, e.g. "R" (running), "S" (sleeping) or "Z" (zombie)
+	State string
+	// PPid is the pid of the parent of the process
+	PPid int
+	// UTime and STime are the CPU time spent in user and kernel mode, in clock ticks
+	UTime uint64
+	STime uint64
+	// StartTime is the time the process started, in clock ticks after boot. Together with
+	// the pid, it identifies a process even if the pid is reused.
+	StartTime uint64
+	// RSS is the resident set size, in pages
+	RSS uint64
+}
+
+// ReadStat reads /proc/<pid>/stat
+func ReadStat(pid int) (*Stat, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseStat(data)
+}
+
+// ParseStat parses the contents of /proc/<pid>/stat
+func ParseStat(data []byte) (*Stat, error) {
+	// The command name (field 2) may contain spaces and parentheses, so it extends to
+	// the last closing parenthesis: "<pid> (<comm>) <state> <ppid> ..."
+	open, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if open < 0 || end < open {
+		return nil, errors.Errorf("invalid stat %q", data)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:open])))
+	if err != nil {
+		return nil, errors.Errorf("invalid stat %q", data)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		return nil, errors.Errorf("invalid stat %q", data)
+	}
+	s := &Stat{Pid: pid, Comm: string(data[open+1 : end]), State: fields[0]}
+	// The fields are numbered from 3 (the state)
+	for _, f := range []struct {
+		field int
+		value *uint64
+	}{
+		{14, &s.UTime},
+		{15, &s.STime},
+		{22, &s.StartTime},
+		{24, &s.RSS},
+	} {
+		if *f.value, err = strconv.ParseUint(fields[f.field-3], 10, 64); err != nil {
+			return nil, errors.Errorf("invalid stat %q", data)
+		}
+	}
+	if s.PPid, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, errors.Errorf("invalid stat %q", data)
+	}
+	return s, nil
+}
+
+// CPUTime returns the total CPU time of the process
+func (s *Stat) CPUTime() time.Duration {
+	return TicksToDuration(s.UTime + s.STime)
+}
+
+// Pids returns the pids of all processes
+func Pids() ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,80 @@
+// +build linux
+
+package procfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	// Fields 3 to 24, of which 4 (ppid), 14 (utime), 15 (stime), 22 (starttime) and
+	// 24 (rss) are parsed
+	fields := "S 1 3 3 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 1 0 12345 4096 300 18446744073709551615"
+	for _, tt := range []struct {
+		stat     string
+		expected *Stat
+	}{
+		{"42 (sleep) " + fields, &Stat{Pid: 42, Comm: "sleep", State: "S", PPid: 1, UTime: 250, STime: 50, StartTime: 12345, RSS: 300}},
+		{"42 (my prog) " + fields, &Stat{Pid: 42, Comm: "my prog", State: "S", PPid: 1, UTime: 250, STime: 50, StartTime: 12345, RSS: 300}},
+		{"42 (a) (b)) " + fields, &Stat{Pid: 42, Comm: "a) (b)", State: "S", PPid: 1, UTime: 250, STime: 50, StartTime: 12345, RSS: 300}},
+		{"42 () " + fields + "\n", &Stat{Pid: 42, Comm: "", State: "S", PPid: 1, UTime: 250, STime: 50, StartTime: 12345, RSS: 300}},
+	} {
+		t.Run(tt.stat, func(t *testing.T) {
+			stat, err := ParseStat([]byte(tt.stat))
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(stat, tt.expected) {
+				t.Errorf("expected %+v, but got %+v", tt.expected, stat)
+			}
+		})
+	}
+
+	for _, stat := range []string{
+		"",
+		"42 sleep " + fields,
+		"42 (sleep) S 1 3",
+		"x (sleep) " + fields,
+		"42 (sleep) S x 3 3 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 1 0 12345 4096 300",
+	} {
+		if _, err := ParseStat([]byte(stat)); err == nil {
+			t.Errorf("expected %q to be invalid", stat)
+		}
+	}
+}
+
+func TestReadStat(t *testing.T) {
+	stat, err := ReadStat(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Pid != os.Getpid() || stat.PPid != os.Getppid() || stat.StartTime == 0 {
+		t.Errorf("unexpected stat of the current process: %+v", stat)
+	}
+}
+
+func TestClockTicks(t *testing.T) {
+	// USER_HZ is 100 on every architecture Go supports
+	if ticks := ClockTicks(); ticks != 100 {
+		t.Errorf("expected 100 clock ticks per second, but got %d", ticks)
+	}
+	if d := TicksToDuration(250); d != 2500*time.Millisecond {
+		t.Errorf("expected 250 ticks to be 2.5s, but got %s", d)
+	}
+}
+
+func TestPids(t *testing.T) {
+	pids, err := Pids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range pids {
+		if pid == os.Getpid() {
+			return
+		}
+	}
+	t.Errorf("expected the pids to include the current process %d, but got %v", os.Getpid(), pids)
+}
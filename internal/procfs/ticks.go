@@ -0,0 +1,72 @@
+// +build linux
+
+package procfs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// defaultClockTicks is USER_HZ on every architecture Linux supports, and is used if it
+// cannot be read from the auxiliary vector
+const defaultClockTicks = 100
+
+// atClkTck is the type of the auxiliary vector entry that holds USER_HZ (AT_CLKTCK)
+const atClkTck = 17
+
+var (
+	clockTicksOnce sync.Once
+	clockTicks     uint64
+)
+
+// ClockTicks returns the number of clock ticks per second (USER_HZ), the unit of the CPU
+// and start times in /proc. It is what sysconf(_SC_CLK_TCK) returns, read from the
+// auxiliary vector the kernel passed to the process.
+func ClockTicks() uint64 {
+	clockTicksOnce.Do(func() {
+		clockTicks = defaultClockTicks
+		if ticks, ok := readAuxv(atClkTck); ok && ticks > 0 {
+			clockTicks = ticks
+		}
+	})
+	return clockTicks
+}
+
+// TicksToDuration converts a number of clock ticks into a Duration
+func TicksToDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks) * time.Second / time.Duration(ClockTicks())
+}
+
+// readAuxv reads an entry of the auxiliary vector of the current process
+func readAuxv(key uint64) (uint64, bool) {
+	data, err := ioutil.ReadFile("/proc/self/auxv")
+	if err != nil {
+		return 0, false
+	}
+	// The vector is a list of (type, value) pairs of native words
+	word := int(unsafe.Sizeof(uintptr(0)))
+	read := func(b []byte) uint64 {
+		if word == 4 {
+			return uint64(nativeEndian.Uint32(b))
+		}
+		return nativeEndian.Uint64(b)
+	}
+	for i := 0; i+2*word <= len(data); i += 2 * word {
+		if read(data[i:]) == key {
+			return read(data[i+word:]), true
+		}
+	}
+	return 0, false
+}
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
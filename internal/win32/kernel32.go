@@ -3,6 +3,7 @@
 package win32
 
 import (
+	"errors"
 	"os"
 	"syscall"
 	"unsafe"
@@ -11,9 +12,12 @@ import (
 var (
 	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
 
-	procCreateJobObjectA         = modkernel32.NewProc("CreateJobObjectA")
-	procAssignProcessToJobObject = modkernel32.NewProc("AssignProcessToJobObject")
-	procSetInformationJobObject  = modkernel32.NewProc("SetInformationJobObject")
+	procCreateJobObjectA          = modkernel32.NewProc("CreateJobObjectA")
+	procOpenJobObjectA            = modkernel32.NewProc("OpenJobObjectA")
+	procAssignProcessToJobObject  = modkernel32.NewProc("AssignProcessToJobObject")
+	procSetInformationJobObject   = modkernel32.NewProc("SetInformationJobObject")
+	procQueryInformationJobObject = modkernel32.NewProc("QueryInformationJobObject")
+	procTerminateJobObject        = modkernel32.NewProc("TerminateJobObject")
 )
 
 func CloseHandle(handle Handle) error {
@@ -43,6 +47,22 @@ func CreateJobObject(attr *SecurityAttributes, name string) (Handle, error) {
 	return Handle(r1), nil
 }
 
+func OpenJobObject(desiredAccess uint32, inheritHandle bool, name string) (Handle, error) {
+	var inherit uintptr
+	if inheritHandle {
+		inherit = 1
+	}
+	r1, _, err := procOpenJobObjectA.Call(
+		uintptr(desiredAccess),
+		inherit,
+		uintptr(unsafe.Pointer(stringToCharPtr(name))),
+	)
+	if r1 == 0 {
+		return InvalidHandle, os.NewSyscallError("OpenJobObjectA", err)
+	}
+	return Handle(r1), nil
+}
+
 func AssignProcessToJobObject(job Handle, process Handle) error {
 	_, _, err := procAssignProcessToJobObject.Call(
 		uintptr(job),
@@ -84,3 +104,95 @@ func SetInformationJobObject_CPURateControlInformation(job Handle, info *JobObje
 		unsafe.Sizeof(*info),
 	)
 }
+
+func TerminateJobObject(job Handle, exitCode uint32) error {
+	_, _, err := procTerminateJobObject.Call(
+		uintptr(job),
+		uintptr(exitCode),
+	)
+	if err != syscall.Errno(0) {
+		return os.NewSyscallError("TerminateJobObject", err)
+	}
+	return nil
+}
+
+func queryInformationJobObject(job Handle, jobObjectInfoClass uint32, ptr uintptr, length uintptr) error {
+	_, _, err := procQueryInformationJobObject.Call(
+		uintptr(job),
+		uintptr(jobObjectInfoClass),
+		ptr,
+		length,
+		0,
+	)
+	if err != syscall.Errno(0) {
+		return os.NewSyscallError("QueryInformationJobObject", err)
+	}
+	return nil
+}
+
+// QueryInformationJobObject_ProcessIdList returns the pids of all processes in the job
+func QueryInformationJobObject_ProcessIdList(job Handle) ([]uint32, error) {
+	header := int(unsafe.Sizeof(JobObjectBasicProcessIdList{}) / unsafe.Sizeof(uintptr(0)))
+	for size := 64; ; size *= 2 {
+		buf := make([]uintptr, header+size)
+		err := queryInformationJobObject(
+			job,
+			jobObjectBasicProcessIdList,
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(len(buf))*unsafe.Sizeof(buf[0]),
+		)
+		if err != nil && !errors.Is(err, syscall.ERROR_MORE_DATA) {
+			return nil, err
+		}
+		list := (*JobObjectBasicProcessIdList)(unsafe.Pointer(&buf[0]))
+		if err == nil && list.NumberOfProcessIdsInList == list.NumberOfAssignedProcesses {
+			pids := make([]uint32, list.NumberOfProcessIdsInList)
+			for i := range pids {
+				pids[i] = uint32(buf[header+i])
+			}
+			return pids, nil
+		}
+	}
+}
+
+func QueryInformationJobObject_BasicAndIoAccountingInformation(job Handle) (*JobObjectBasicAndIoAccountingInformation, error) {
+	info := &JobObjectBasicAndIoAccountingInformation{}
+	err := queryInformationJobObject(
+		job,
+		jobObjectBasicAndIoAccountingInformation,
+		uintptr(unsafe.Pointer(info)),
+		unsafe.Sizeof(*info),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func QueryInformationJobObject_ExtendedLimitInformation(job Handle) (*JobObjectExtendedLimitInformation, error) {
+	info := &JobObjectExtendedLimitInformation{}
+	err := queryInformationJobObject(
+		job,
+		jobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(info)),
+		unsafe.Sizeof(*info),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func QueryInformationJobObject_CPURateControlInformation(job Handle) (*JobObjectCPURateControlInformation, error) {
+	info := &JobObjectCPURateControlInformation{}
+	err := queryInformationJobObject(
+		job,
+		jobObjectCpuRateControlInformation,
+		uintptr(unsafe.Pointer(info)),
+		unsafe.Sizeof(*info),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
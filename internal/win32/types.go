@@ -1,3 +1,5 @@
+// +build windows
+
 package win32
 
 import "syscall"
@@ -11,10 +13,14 @@ type StartupInfo = syscall.StartupInfo
 type ProcessInformation = syscall.ProcessInformation
 
 const (
-	jobObjectExtendedLimitInformation  = 9
-	jobObjectCpuRateControlInformation = 15
+	jobObjectBasicProcessIdList              = 3
+	jobObjectBasicAndIoAccountingInformation = 8
+	jobObjectExtendedLimitInformation        = 9
+	jobObjectCpuRateControlInformation       = 15
 )
 
+const JOB_OBJECT_ALL_ACCESS = 0x1F001F
+
 type ProcessAccessFlags uint32
 
 const (
@@ -90,3 +96,29 @@ type JobObjectCPURateControlInformation struct {
 	ControlFlags JobObjectCPURateControlFlags
 	CPURate      uint32
 }
+
+// https://docs.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-jobobject_basic_process_id_list
+// ProcessIdList immediately follows the header in memory
+type JobObjectBasicProcessIdList struct {
+	NumberOfAssignedProcesses uint32
+	NumberOfProcessIdsInList  uint32
+}
+
+// https://docs.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-jobobject_basic_accounting_information
+// Times are in 100-nanosecond ticks
+type JobObjectBasicAccountingInformation struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+// https://docs.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-jobobject_basic_and_io_accounting_information
+type JobObjectBasicAndIoAccountingInformation struct {
+	BasicInfo JobObjectBasicAccountingInformation
+	IoInfo    IOCounters
+}
@@ -1,3 +1,5 @@
+// +build windows
+
 package win32
 
 // stringToCharPtr converts a Go string into pointer to a null-terminated cstring.
@@ -3,10 +3,16 @@
 package proclimit
 
 import (
+	"context"
 	"github.com/aoldershaw/proclimit/internal/win32"
 	"github.com/friendsofgo/errors"
+	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
 )
 
 // TODO: godocs
@@ -19,6 +25,34 @@ func WithName(name string) Option {
 	}
 }
 
+// WithLabels adds labels to the JobObject. Note that job objects cannot be listed (and so
+// selected by their labels) on Windows.
+func WithLabels(labels map[string]string) Option {
+	return func(jobObject *JobObject) {
+		if err := validateLabels(labels); err != nil {
+			jobObject.optionErr = err
+			return
+		}
+		if jobObject.labels == nil {
+			jobObject.labels = map[string]string{}
+		}
+		for key, value := range labels {
+			jobObject.labels[key] = value
+		}
+	}
+}
+
+// WithOwned has no effect on Windows. A job object is destroyed once the last handle to it
+// is closed, so it never outlives its creator (see FindStale).
+func WithOwned() Option {
+	return func(jobObject *JobObject) {}
+}
+
+// WithPressure has no effect on Windows, where Pressure Stall Information is not supported.
+func WithPressure() Option {
+	return func(jobObject *JobObject) {}
+}
+
 func WithCPULimit(cpuLimit Percent) Option {
 	return func(jobObject *JobObject) {
 		if jobObject.CPULimitInformation == nil {
@@ -40,18 +74,118 @@ func WithMemoryLimit(mem Memory) Option {
 	}
 }
 
+func WithPidsLimit(limit int64) Option {
+	return func(jobObject *JobObject) {
+		if jobObject.ExtendedLimitInformation == nil {
+			jobObject.ExtendedLimitInformation = &win32.JobObjectExtendedLimitInformation{}
+		}
+		jobObject.ExtendedLimitInformation.BasicLimitInformation.ActiveProcessLimit = uint32(limit)
+		jobObject.ExtendedLimitInformation.BasicLimitInformation.LimitFlags |= win32.JOB_OBJECT_LIMIT_ACTIVE_PROCESS
+	}
+}
+
+// WithCPUSet restricts the job object to the given CPUs, e.g. "0-3,6". Only the
+// first 64 (32 on 32-bit systems) CPUs can be specified.
+func WithCPUSet(cpus string) Option {
+	return func(jobObject *JobObject) {
+		mask, err := parseCPUList(cpus)
+		if err != nil {
+			jobObject.optionErr = err
+			return
+		}
+		if jobObject.ExtendedLimitInformation == nil {
+			jobObject.ExtendedLimitInformation = &win32.JobObjectExtendedLimitInformation{}
+		}
+		jobObject.ExtendedLimitInformation.BasicLimitInformation.Affinity = mask
+		jobObject.ExtendedLimitInformation.BasicLimitInformation.LimitFlags |= win32.JOB_OBJECT_LIMIT_AFFINITY
+	}
+}
+
+// parseCPUList converts a list of CPU numbers or ranges into an affinity mask
+func parseCPUList(cpus string) (uintptr, error) {
+	var mask uintptr
+	maxCPU := uint64(unsafe.Sizeof(mask)*8 - 1)
+	for _, part := range strings.Split(cpus, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.ParseUint(bounds[0], 10, 8)
+		if err != nil {
+			return 0, errors.Errorf("invalid cpuset %q", cpus)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.ParseUint(bounds[1], 10, 8); err != nil {
+				return 0, errors.Errorf("invalid cpuset %q", cpus)
+			}
+		}
+		if first > last || last > maxCPU {
+			return 0, errors.Errorf("invalid cpuset %q", cpus)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			mask |= 1 << cpu
+		}
+	}
+	return mask, nil
+}
+
+// WithCPUTimeBudget sets the total CPU time that all processes within the JobObject may
+// consume. Once the budget is exhausted, all processes are killed, and Cmd.Wait returns
+// a *BudgetExceededError.
+func WithCPUTimeBudget(budget time.Duration) Option {
+	return func(jobObject *JobObject) {
+		jobObject.budget.cpuTime = budget
+	}
+}
+
+// WithAdmission sets the policy that decides when commands may be started within the
+// JobObject. See AdmissionPolicy. MaxMemory is not supported on Windows.
+func WithAdmission(policy AdmissionPolicy) Option {
+	return func(jobObject *JobObject) {
+		jobObject.admission = newAdmission(policy)
+	}
+}
+
+// WithWallTimeout sets the maximum wall time that processes may run within the JobObject,
+// measured from when the first process is limited. Once the timeout elapses, all processes
+// are killed, and Cmd.Wait returns a *BudgetExceededError.
+func WithWallTimeout(timeout time.Duration) Option {
+	return func(jobObject *JobObject) {
+		jobObject.budget.wallTime = timeout
+	}
+}
+
 type JobObject struct {
 	Name                     string
 	ExtendedLimitInformation *win32.JobObjectExtendedLimitInformation
 	CPULimitInformation      *win32.JobObjectCPURateControlInformation
 	handle                   win32.Handle
+	optionErr                error
+	budget                   budget
+	parent                   *JobObject
+	admission                *admission
+	labels                   map[string]string
 }
 
 func New(options ...Option) (*JobObject, error) {
-	j := &JobObject{}
+	return newJobObject(nil, options)
+}
+
+// NewChild creates a JobObject nested within j. Processes within the child are subject
+// to the limits (and budgets) of both job objects, and are included in the Processes and
+// Stats of j. Nested job objects require Windows 8 or later.
+//
+// The Name of the returned JobObject is "<parent name>/<child name>".
+func (j *JobObject) NewChild(options ...Option) (*JobObject, error) {
+	return newJobObject(j, options)
+}
+
+func newJobObject(parent *JobObject, options []Option) (*JobObject, error) {
+	j := &JobObject{parent: parent}
 	for _, opt := range options {
 		opt(j)
 	}
+	if j.optionErr != nil {
+		return nil, j.optionErr
+	}
 	var err error
 	if j.Name == "" {
 		j.Name, err = randomName()
@@ -59,26 +193,93 @@ func New(options ...Option) (*JobObject, error) {
 			return nil, err
 		}
 	}
+	if parent != nil {
+		j.Name = parent.Name + "/" + j.Name
+	}
 	j.handle, err = win32.CreateJobObject(nil, j.Name)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create job object %s", j.Name)
 	}
+	if err = j.setInformation(); err != nil {
+		win32.CloseHandle(j.handle)
+		return nil, err
+	}
+	return j, nil
+}
+
+// Existing opens an existing JobObject by name. Note that a job object is destroyed
+// once all of its handles have been closed, so it can only be opened while another
+// process (e.g. the one that created it) holds a handle.
+//
+// Its limit information is queried from the job object, and reflects any changes made by
+// Update. Budgets and admission policies are not restored - they are only enforced by the
+// process that set them.
+func Existing(name string) (*JobObject, error) {
+	handle, err := win32.OpenJobObject(win32.JOB_OBJECT_ALL_ACCESS, false, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open job object %s", name)
+	}
+	j := &JobObject{Name: name, handle: handle}
+	extended, err := win32.QueryInformationJobObject_ExtendedLimitInformation(handle)
+	if err != nil {
+		win32.CloseHandle(handle)
+		return nil, errors.Wrap(err, "failed to query extended limit information")
+	}
+	if extended.BasicLimitInformation.LimitFlags != 0 {
+		j.ExtendedLimitInformation = extended
+	}
+	cpu, err := win32.QueryInformationJobObject_CPURateControlInformation(handle)
+	if err != nil {
+		win32.CloseHandle(handle)
+		return nil, errors.Wrap(err, "failed to query CPU rate information")
+	}
+	if cpu.ControlFlags&win32.JOB_OBJECT_CPU_RATE_CONTROL_ENABLE != 0 {
+		j.CPULimitInformation = cpu
+	}
+	return j, nil
+}
+
+func (j *JobObject) setInformation() error {
 	if j.CPULimitInformation != nil {
-		err = win32.SetInformationJobObject_CPURateControlInformation(j.handle, j.CPULimitInformation)
+		err := win32.SetInformationJobObject_CPURateControlInformation(j.handle, j.CPULimitInformation)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to set CPU rate information")
+			return errors.Wrap(err, "failed to set CPU rate information")
 		}
 	}
 	if j.ExtendedLimitInformation != nil {
-		err = win32.SetInformationJobObject_ExtendedLimitInformation(j.handle, j.ExtendedLimitInformation)
+		err := win32.SetInformationJobObject_ExtendedLimitInformation(j.handle, j.ExtendedLimitInformation)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to set extended limit information")
+			return errors.Wrap(err, "failed to set extended limit information")
 		}
 	}
-	return j, nil
+	return nil
 }
 
-// TODO: implement Existing
+// Update changes the limits of the JobObject while it is running. Options that do not
+// modify limits (such as WithName or WithCPUTimeBudget) have no effect.
+func (j *JobObject) Update(options ...Option) error {
+	updated := &JobObject{handle: j.handle}
+	if j.CPULimitInformation != nil {
+		info := *j.CPULimitInformation
+		updated.CPULimitInformation = &info
+	}
+	if j.ExtendedLimitInformation != nil {
+		info := *j.ExtendedLimitInformation
+		updated.ExtendedLimitInformation = &info
+	}
+	for _, opt := range options {
+		opt(updated)
+	}
+	if updated.optionErr != nil {
+		return updated.optionErr
+	}
+	if err := updated.setInformation(); err != nil {
+		return err
+	}
+	j.CPULimitInformation = updated.CPULimitInformation
+	j.ExtendedLimitInformation = updated.ExtendedLimitInformation
+	return nil
+}
 
 func (j *JobObject) Command(name string, arg ...string) *Cmd {
 	return j.Wrap(exec.Command(name, arg...))
@@ -94,10 +295,25 @@ func (j *JobObject) Wrap(cmd *exec.Cmd) *Cmd {
 	}
 }
 
+// CommandContext is like Command, but includes a context. When the context is done, all processes
+// within the JobObject are killed.
+func (j *JobObject) CommandContext(ctx context.Context, name string, arg ...string) *Cmd {
+	cmd := j.Command(name, arg...)
+	cmd.ctx = ctx
+	return cmd
+}
+
 func (j *JobObject) Limit(pid int) error {
 	if pid == 0 {
 		return errors.New("must provide a valid pid")
 	}
+	if j.parent != nil {
+		// A process must be in the parent job before it is assigned to the child, so that
+		// the jobs form a hierarchy
+		if err := j.parent.Limit(pid); err != nil {
+			return err
+		}
+	}
 	handle, err := win32.OpenProcess(win32.STANDARD_RIGHTS_READ|win32.PROCESS_QUERY_INFORMATION|win32.SYNCHRONIZE|win32.PROCESS_SET_INFORMATION,
 		false, uint32(pid))
 	if err != nil {
@@ -107,12 +323,105 @@ func (j *JobObject) Limit(pid int) error {
 	if err = win32.AssignProcessToJobObject(j.handle, handle); err != nil {
 		return errors.Wrap(err, "failed to assign process to job object")
 	}
+	j.budget.start(j.Stats, func() error {
+		return j.Signal(os.Kill)
+	})
 	return nil
 }
 
+// LimitProcess is like Limit, but returns a *ProcessGoneError if the process of h has
+// exited. Pids are not reused while h is open, so the process cannot be confused with
+// another process.
+func (j *JobObject) LimitProcess(h *ProcessHandle) error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	if err := j.Limit(h.Pid); err != nil {
+		if goneErr := h.check(); goneErr != nil {
+			return goneErr
+		}
+		return err
+	}
+	return nil
+}
+
+// Processes returns the pids of all processes within the JobObject
+func (j *JobObject) Processes() ([]int, error) {
+	ids, err := win32.QueryInformationJobObject_ProcessIdList(j.handle)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list processes")
+	}
+	pids := make([]int, len(ids))
+	for i, id := range ids {
+		pids[i] = int(id)
+	}
+	return pids, nil
+}
+
+// Signal sends sig to all processes within the JobObject. Only os.Kill is supported,
+// which terminates all processes.
+func (j *JobObject) Signal(sig os.Signal) error {
+	if sig != os.Kill {
+		return errors.Errorf("unsupported signal %v: only os.Kill is supported on windows", sig)
+	}
+	if err := win32.TerminateJobObject(j.handle, 1); err != nil {
+		return errors.Wrap(err, "failed to terminate job object")
+	}
+	return nil
+}
+
+// Stats returns the combined resource usage of all processes within the JobObject.
+// ThrottledTime and MemoryUsage are not available on Windows.
+func (j *JobObject) Stats() (*Stats, error) {
+	accounting, err := win32.QueryInformationJobObject_BasicAndIoAccountingInformation(j.handle)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query accounting information")
+	}
+	limits, err := win32.QueryInformationJobObject_ExtendedLimitInformation(j.handle)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query extended limit information")
+	}
+	// Times are reported in 100ns ticks
+	userCPU := time.Duration(accounting.BasicInfo.TotalUserTime) * 100
+	systemCPU := time.Duration(accounting.BasicInfo.TotalKernelTime) * 100
+	var memoryLimit Memory
+	if limits.BasicLimitInformation.LimitFlags&win32.JOB_OBJECT_LIMIT_PROCESS_MEMORY != 0 {
+		memoryLimit = Memory(limits.ProcessMemoryLimit)
+	}
+	return &Stats{
+		CPUUsage:       userCPU + systemCPU,
+		UserCPU:        userCPU,
+		SystemCPU:      systemCPU,
+		MemoryMaxUsage: Memory(limits.PeakJobMemoryUsed),
+		MemoryLimit:    memoryLimit,
+		IOReadBytes:    accounting.IoInfo.ReadTransferCount,
+		IOWriteBytes:   accounting.IoInfo.WriteTransferCount,
+		Processes:      int(accounting.BasicInfo.ActiveProcesses),
+	}, nil
+}
+
 func (j *JobObject) Close() error {
 	// TODO: also close all processes that have been limited?
 	// https://docs.microsoft.com/en-us/windows/win32/procthread/job-objects#managing-job-objects
 	// "The job is destroyed when its last handle has been closed and all associated processes have been terminated"
+	j.budget.stop()
 	return win32.CloseHandle(j.handle)
 }
+
+func (j *JobObject) admit(ctx context.Context) (func(), error) {
+	if j.admission == nil {
+		return func() {}, nil
+	}
+	return j.admission.admit(ctx, j.Stats)
+}
+
+// budgetExceeded returns the error of the first exhausted budget of the job object or its
+// ancestors, as the budgets of an ancestor also kill the processes of its children
+func (j *JobObject) budgetExceeded() error {
+	for g := j; g != nil; g = g.parent {
+		if err := g.budget.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package proclimit
+
+import (
+	"github.com/friendsofgo/errors"
+	"strings"
+)
+
+// Selector selects limiters by their labels (see WithLabels). It is a comma separated list
+// of requirements, all of which must be met by the labels of a limiter:
+//
+//	key=value   the label is set to value
+//	key!=value  the label is not set to value (or is not set at all)
+//	key         the label is set
+//	!key        the label is not set
+//
+// For example, "team=infra,pipeline" selects limiters of the infra team that are part of
+// a pipeline. The empty Selector selects every limiter.
+type Selector string
+
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+func (r labelRequirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	if r.exists {
+		return ok != r.negate
+	}
+	return (ok && value == r.value) != r.negate
+}
+
+func (s Selector) parse() ([]labelRequirement, error) {
+	var requirements []labelRequirement
+	for _, part := range strings.Split(string(s), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var r labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = labelRequirement{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1]), negate: true}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			r = labelRequirement{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			r = labelRequirement{key: strings.TrimSpace(part[1:]), negate: true, exists: true}
+		default:
+			r = labelRequirement{key: part, exists: true}
+		}
+		if err := validateLabel(r.key, r.value); err != nil {
+			return nil, errors.Wrapf(err, "invalid selector %q", string(s))
+		}
+		requirements = append(requirements, r)
+	}
+	return requirements, nil
+}
+
+// Matches returns whether labels meet all of the requirements of the Selector. An error
+// is returned if the Selector is invalid.
+func (s Selector) Matches(labels map[string]string) (bool, error) {
+	requirements, err := s.parse()
+	if err != nil {
+		return false, err
+	}
+	return matchesAll(requirements, labels), nil
+}
+
+func matchesAll(requirements []labelRequirement, labels map[string]string) bool {
+	for _, r := range requirements {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSelectors parses each of selectors, and combines their requirements
+func parseSelectors(selectors []Selector) ([]labelRequirement, error) {
+	var requirements []labelRequirement
+	for _, s := range selectors {
+		r, err := s.parse()
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, r...)
+	}
+	return requirements, nil
+}
+
+// validateLabel checks that a label can be used in a Selector: keys must not be empty,
+// and neither keys nor values may contain whitespace, commas, '=' or '!'
+func validateLabel(key, value string) error {
+	if key == "" {
+		return errors.New("label key must not be empty")
+	}
+	for _, s := range []string{key, value} {
+		if strings.ContainsAny(s, " \t\n,=!") {
+			return errors.Errorf("label %q contains an invalid character", s)
+		}
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if err := validateLabel(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package proclimit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "infra", "pipeline": "nightly"}
+	for _, tt := range []struct {
+		selector Selector
+		expected bool
+	}{
+		{"", true},
+		{"team=infra", true},
+		{"team=web", false},
+		{"team!=web", true},
+		{"team!=infra", false},
+		{"owner!=me", true},
+		{"pipeline", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!pipeline", false},
+		{"team=infra,pipeline=nightly", true},
+		{"team=infra, pipeline=weekly", false},
+		{"team=infra,,", true},
+	} {
+		t.Run(string(tt.selector), func(t *testing.T) {
+			matches, err := tt.selector.Matches(labels)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if matches != tt.expected {
+				t.Errorf("expected %v, but got %v", tt.expected, matches)
+			}
+		})
+	}
+}
+
+func TestSelectorErrors(t *testing.T) {
+	for _, tt := range []struct {
+		selector Selector
+		error    string
+	}{
+		{"=infra", "label key must not be empty"},
+		{"!", "label key must not be empty"},
+		{"team==infra", "invalid character"},
+		{"team=in fra", "invalid character"},
+		{"!!team", "invalid character"},
+	} {
+		t.Run(string(tt.selector), func(t *testing.T) {
+			_, err := tt.selector.Matches(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("expected error containing %q, but got: %v", tt.error, err)
+			}
+		})
+	}
+}
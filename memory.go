@@ -0,0 +1,148 @@
+package proclimit
+
+import (
+	"encoding/json"
+	"github.com/friendsofgo/errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Memory represents a number of bytes
+type Memory uint64
+
+// MaxMemory is the largest Memory accepted by ParseMemory. Cgroups store limits as signed
+// 64-bit integers, in which larger values would wrap around to negative (i.e. unlimited).
+const MaxMemory = Memory(math.MaxInt64)
+
+const (
+	Byte     Memory = 1
+	Kilobyte        = 1024 * Byte
+	Megabyte        = 1024 * Kilobyte
+	Gigabyte        = 1024 * Megabyte
+	Terabyte        = 1024 * Gigabyte
+	Petabyte        = 1024 * Terabyte
+)
+
+type memoryUnit struct {
+	suffix string
+	factor uint64
+}
+
+// memoryUnits lists the units used by String, from largest to smallest.
+// IEC units are preferred over SI units when both are exact.
+var memoryUnits = []memoryUnit{
+	{"Pi", uint64(Petabyte)},
+	{"Ti", uint64(Terabyte)},
+	{"Gi", uint64(Gigabyte)},
+	{"Mi", uint64(Megabyte)},
+	{"Ki", uint64(Kilobyte)},
+	{"PB", 1e15},
+	{"TB", 1e12},
+	{"GB", 1e9},
+	{"MB", 1e6},
+	{"KB", 1e3},
+}
+
+// memorySuffixes maps every accepted (upper-cased) suffix to its factor.
+// Single letter suffixes (e.g. "M") are binary, for compatibility with the
+// original CLI.
+var memorySuffixes = map[string]uint64{
+	"":  1,
+	"B": 1,
+}
+
+func init() {
+	for _, u := range memoryUnits {
+		upper := strings.ToUpper(u.suffix)
+		memorySuffixes[upper] = u.factor
+		if strings.HasSuffix(upper, "I") {
+			memorySuffixes[upper+"B"] = u.factor
+			memorySuffixes[upper[:1]] = u.factor
+		}
+	}
+}
+
+// ParseMemory parses a human readable memory size, such as "512Mi", "1.5G" or
+// "100MB". Units are case-insensitive:
+//
+//	K, M, G, T, P           binary (e.g. 1K = 1024 bytes)
+//	Ki, Mi, Gi, Ti, Pi      binary (IEC), optionally followed by B (e.g. MiB)
+//	KB, MB, GB, TB, PB      decimal (SI) (e.g. 1KB = 1000 bytes)
+//	B or no unit            bytes
+//
+// Fractional values are truncated to a whole number of bytes. Negative values,
+// malformed numbers and values above MaxMemory are rejected.
+func ParseMemory(s string) (Memory, error) {
+	str := strings.TrimSpace(s)
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(str)
+	}
+	num, unit := str[:i], strings.TrimSpace(str[i:])
+
+	if strings.Count(num, ".") > 1 || strings.Trim(num, ".") == "" {
+		return 0, errors.Errorf("invalid memory value %q", s)
+	}
+	factor, ok := memorySuffixes[strings.ToUpper(unit)]
+	if !ok {
+		return 0, errors.Errorf("invalid memory value %q: unknown unit %q", s, unit)
+	}
+
+	var fraction string
+	if dot := strings.Index(num, "."); dot != -1 {
+		num, fraction = num[:dot], num[dot+1:]
+	}
+	value, _ := new(big.Int).SetString(num+fraction, 10)
+	value.Mul(value, new(big.Int).SetUint64(factor))
+	value.Div(value, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(len(fraction))), nil))
+	if !value.IsUint64() || value.Uint64() > uint64(MaxMemory) {
+		return 0, errors.Errorf("invalid memory value %q: overflows %d bytes", s, uint64(MaxMemory))
+	}
+	return Memory(value.Uint64()), nil
+}
+
+// String formats m using the largest unit that represents it exactly. The
+// result can be parsed by ParseMemory to get back the same value.
+func (m Memory) String() string {
+	for _, u := range memoryUnits {
+		if uint64(m) >= u.factor && uint64(m)%u.factor == 0 {
+			return strconv.FormatUint(uint64(m)/u.factor, 10) + u.suffix
+		}
+	}
+	return strconv.FormatUint(uint64(m), 10)
+}
+
+// Set parses s using ParseMemory, allowing *Memory to be used as a flag.Value.
+func (m *Memory) Set(s string) error {
+	v, err := ParseMemory(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// UnmarshalJSON accepts either a number of bytes or a string understood by
+// ParseMemory (e.g. "512Mi").
+func (m *Memory) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return m.Set(s)
+	}
+	v, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid memory value %s", data)
+	}
+	if v > uint64(MaxMemory) {
+		return errors.Errorf("invalid memory value %s: overflows %d bytes", data, uint64(MaxMemory))
+	}
+	*m = Memory(v)
+	return nil
+}
@@ -0,0 +1,111 @@
+package proclimit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMemory(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		expected Memory
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"12B", 12},
+		{"2K", 2 * Kilobyte},
+		{"2k", 2 * Kilobyte},
+		{"512M", 512 * Megabyte},
+		{"1.5G", 3 * Gigabyte / 2},
+		{"2T", 2 * Terabyte},
+		{"1P", Petabyte},
+		{"512Mi", 512 * Megabyte},
+		{"512MiB", 512 * Megabyte},
+		{"4gi", 4 * Gigabyte},
+		{"100MB", 100 * 1000 * 1000},
+		{"1kB", 1000},
+		{"3TB", 3e12},
+		{"1PB", 1e15},
+		{".5Ki", 512},
+		{"1.", 1},
+		{" 64 Mi ", 64 * Megabyte},
+		{"0.1", 0},
+		{"15.5Pi", 31 * Petabyte / 2},
+		{"9223372036854775807", MaxMemory},
+		{"8191.5Pi", 16383 * Petabyte / 2},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			actual, err := ParseMemory(tt.in)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual != tt.expected {
+				t.Errorf("expected %d, but got %d", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseMemoryErrors(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		error string
+	}{
+		{"", "invalid memory value"},
+		{"G", "invalid memory value"},
+		{".", "invalid memory value"},
+		{"1.5.3", "invalid memory value"},
+		{"-1", "invalid memory value"},
+		{"+1", "invalid memory value"},
+		{"1e3", "unknown unit"},
+		{"10X", "unknown unit"},
+		{"10MBi", "unknown unit"},
+		{"8192Pi", "overflows"},
+		{"9223372036854775808", "overflows"},
+		{"16384Pi", "overflows"},
+		{"18446744073709551616", "overflows"},
+		{"100000PB", "overflows"},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := ParseMemory(tt.in)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("expected error containing %q, but got: %v", tt.error, err)
+			}
+		})
+	}
+}
+
+func TestMemoryString(t *testing.T) {
+	for _, tt := range []struct {
+		in       Memory
+		expected string
+	}{
+		{0, "0"},
+		{1023, "1023"},
+		{1024, "1Ki"},
+		{1536, "1536"},
+		{512 * Megabyte, "512Mi"},
+		{3 * Gigabyte / 2, "1536Mi"},
+		{2 * Petabyte, "2Pi"},
+		{1000, "1KB"},
+		{1000 * 1024, "1000Ki"},
+		{5e9, "5GB"},
+		{1<<64 - 1, "18446744073709551615"},
+	} {
+		if actual := tt.in.String(); actual != tt.expected {
+			t.Errorf("expected %d to format as %q, but got %q", uint64(tt.in), tt.expected, actual)
+		}
+	}
+}
+
+func TestMemoryRoundTrip(t *testing.T) {
+	for _, m := range []Memory{0, 1, 999, 1000, 1024, 4096, 1e6, 1e6 + 1, 3 * Gigabyte / 2, 7 * Terabyte, 1e15, MaxMemory} {
+		parsed, err := ParseMemory(m.String())
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", m.String(), err)
+		}
+		if parsed != m {
+			t.Errorf("expected %q to round trip to %d, but got %d", m.String(), uint64(m), uint64(parsed))
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package metrics exports the Stats of limiters in the Prometheus text exposition
+// format. Limiters can be registered explicitly, or discovered by name prefix among
+// the limiters created by proclimit (see proclimit.List).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Source is a limiter that reports its resource usage, such as a *proclimit.Cgroup
+type Source interface {
+	Stats() (*proclimit.Stats, error)
+}
+
+// Exporter serves the Stats of a set of limiters, labelled by limiter name
+type Exporter struct {
+	mu       sync.Mutex
+	sources  map[string]Source
+	discover bool
+	prefix   string
+	// discovered are the limiters opened by previous scrapes, by name
+	discovered map[string]Source
+
+	// list and open discover limiters. They are replaced in tests.
+	list func() ([]string, error)
+	open func(name string) (Source, error)
+}
+
+// NewExporter creates an Exporter with no limiters
+func NewExporter() *Exporter {
+	return &Exporter{
+		sources:    map[string]Source{},
+		discovered: map[string]Source{},
+		list: func() ([]string, error) {
+			return proclimit.List()
+		},
+		open: func(name string) (Source, error) {
+			return proclimit.Existing(name)
+		},
+	}
+}
+
+// Register exports the Stats of source with the given limiter name
+func (e *Exporter) Register(name string, source Source) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sources[name] = source
+}
+
+// Unregister stops exporting the named limiter
+func (e *Exporter) Unregister(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.sources, name)
+}
+
+// Discover exports all limiters created by proclimit whose names start with prefix, in
+// addition to the registered limiters. Limiters are listed on every scrape, which reads
+// the metadata of every limiter (see proclimit.List), but each limiter is only opened
+// the first time it is listed.
+func (e *Exporter) Discover(prefix string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.discover = true
+	e.prefix = prefix
+}
+
+// metric describes a single exported metric
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s *proclimit.Stats) float64
+}
+
+var exportedMetrics = []metric{
+	{"proclimit_cpu_usage_seconds_total", "Total CPU time consumed by processes in the limiter.", "counter",
+		func(s *proclimit.Stats) float64 { return s.CPUUsage.Seconds() }},
+	{"proclimit_cpu_user_seconds_total", "CPU time consumed in user mode by processes in the limiter.", "counter",
+		func(s *proclimit.Stats) float64 { return s.UserCPU.Seconds() }},
+	{"proclimit_cpu_system_seconds_total", "CPU time consumed in kernel mode by processes in the limiter.", "counter",
+		func(s *proclimit.Stats) float64 { return s.SystemCPU.Seconds() }},
+	{"proclimit_cpu_throttled_seconds_total", "Total time processes in the limiter were throttled by the CPU limit.", "counter",
+		func(s *proclimit.Stats) float64 { return s.ThrottledTime.Seconds() }},
+	{"proclimit_memory_usage_bytes", "Current memory usage of the limiter.", "gauge",
+		func(s *proclimit.Stats) float64 { return float64(s.MemoryUsage) }},
+	{"proclimit_memory_max_usage_bytes", "Peak memory usage of the limiter.", "gauge",
+		func(s *proclimit.Stats) float64 { return float64(s.MemoryMaxUsage) }},
+	{"proclimit_io_read_bytes_total", "Bytes read by processes in the limiter.", "counter",
+		func(s *proclimit.Stats) float64 { return float64(s.IOReadBytes) }},
+	{"proclimit_io_write_bytes_total", "Bytes written by processes in the limiter.", "counter",
+		func(s *proclimit.Stats) float64 { return float64(s.IOWriteBytes) }},
+	{"proclimit_oom_kills_total", "Processes in the limiter killed for exceeding the memory limit.", "counter",
+		func(s *proclimit.Stats) float64 { return float64(s.OOMKills) }},
+	{"proclimit_processes", "Number of processes running in the limiter.", "gauge",
+		func(s *proclimit.Stats) float64 { return float64(s.Processes) }},
+}
+
+// sourcesToScrape returns the registered and discovered limiters
+func (e *Exporter) sourcesToScrape() map[string]Source {
+	e.mu.Lock()
+	sources := make(map[string]Source, len(e.sources))
+	for name, source := range e.sources {
+		sources[name] = source
+	}
+	discover, prefix := e.discover, e.prefix
+	e.mu.Unlock()
+
+	if !discover {
+		return sources
+	}
+	// Limiters that cannot be listed or opened (e.g. because they were deleted since
+	// being listed) are skipped
+	names, err := e.list()
+	if err != nil {
+		return sources
+	}
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := sources[name]; ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		listed[name] = true
+		e.mu.Lock()
+		source, ok := e.discovered[name]
+		e.mu.Unlock()
+		if !ok {
+			if source, err = e.open(name); err != nil {
+				continue
+			}
+			e.mu.Lock()
+			e.discovered[name] = source
+			e.mu.Unlock()
+		}
+		sources[name] = source
+	}
+	// Forget limiters that have been closed, so that they are opened again if a limiter
+	// with the same name is created
+	e.mu.Lock()
+	for name := range e.discovered {
+		if !listed[name] {
+			delete(e.discovered, name)
+		}
+	}
+	e.mu.Unlock()
+	return sources
+}
+
+// WriteTo writes the metrics of all limiters to w. Limiters whose Stats cannot be read
+// are omitted, and counted by proclimit_scrape_errors.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	sources := e.sourcesToScrape()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := make(map[string]*proclimit.Stats, len(names))
+	scrapeErrors := 0
+	for _, name := range names {
+		s, err := sources[name].Stats()
+		if err != nil {
+			scrapeErrors++
+			continue
+		}
+		stats[name] = s
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range exportedMetrics {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, name := range names {
+			if s, ok := stats[name]; ok {
+				fmt.Fprintf(cw, "%s{limiter=\"%s\"} %s\n", m.name, escapeLabelValue(name), formatValue(m.value(s)))
+			}
+		}
+	}
+	fmt.Fprintf(cw, "# HELP proclimit_scrape_errors Number of limiters whose stats could not be read.\n")
+	fmt.Fprintf(cw, "# TYPE proclimit_scrape_errors gauge\nproclimit_scrape_errors %d\n", scrapeErrors)
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// ServeHTTP serves the metrics, e.g. on /metrics
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteTo(w)
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
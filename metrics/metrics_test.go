@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	stats *proclimit.Stats
+	err   error
+}
+
+func (f *fakeSource) Stats() (*proclimit.Stats, error) {
+	return f.stats, f.err
+}
+
+func TestExporterWriteTo(t *testing.T) {
+	e := NewExporter()
+	e.Register("job-1", &fakeSource{stats: &proclimit.Stats{
+		CPUUsage:       1500 * time.Millisecond,
+		ThrottledTime:  250 * time.Millisecond,
+		MemoryUsage:    proclimit.Megabyte,
+		MemoryMaxUsage: 2 * proclimit.Megabyte,
+		OOMKills:       1,
+		Processes:      3,
+	}})
+	e.Register(`we"ird`, &fakeSource{stats: &proclimit.Stats{}})
+	e.Register("broken", &fakeSource{err: errors.New("cgroup deleted")})
+
+	var out bytes.Buffer
+	if _, err := e.WriteTo(&out); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	for _, expected := range []string{
+		"# TYPE proclimit_cpu_usage_seconds_total counter\n",
+		`proclimit_cpu_usage_seconds_total{limiter="job-1"} 1.5` + "\n",
+		`proclimit_cpu_throttled_seconds_total{limiter="job-1"} 0.25` + "\n",
+		"# TYPE proclimit_memory_usage_bytes gauge\n",
+		`proclimit_memory_usage_bytes{limiter="job-1"} 1.048576e+06` + "\n",
+		`proclimit_memory_max_usage_bytes{limiter="job-1"} 2.097152e+06` + "\n",
+		`proclimit_oom_kills_total{limiter="job-1"} 1` + "\n",
+		`proclimit_processes{limiter="job-1"} 3` + "\n",
+		`proclimit_processes{limiter="we\"ird"} 0` + "\n",
+		"proclimit_scrape_errors 1\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, but got:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "broken") {
+		t.Errorf("expected limiters whose stats failed to be omitted, but got:\n%s", out.String())
+	}
+}
+
+func TestExporterDiscover(t *testing.T) {
+	e := NewExporter()
+	names := []string{"ci-1", "ci-2", "other"}
+	e.list = func() ([]string, error) {
+		return names, nil
+	}
+	opened := 0
+	e.open = func(name string) (Source, error) {
+		opened++
+		return &fakeSource{stats: &proclimit.Stats{Processes: 1}}, nil
+	}
+	e.Discover("ci-")
+
+	var out bytes.Buffer
+	e.WriteTo(&out)
+	// Limiters are only opened the first time they are discovered
+	e.WriteTo(ioutil.Discard)
+	if opened != 2 {
+		t.Errorf("expected 2 limiters to be opened, but %d were", opened)
+	}
+	// Limiters that were closed are opened again if they are recreated
+	names = []string{"ci-1"}
+	e.WriteTo(ioutil.Discard)
+	names = []string{"ci-1", "ci-2"}
+	e.WriteTo(ioutil.Discard)
+	if opened != 3 {
+		t.Errorf("expected a recreated limiter to be opened again, but %d limiters were opened", opened)
+	}
+	for _, name := range []string{"ci-1", "ci-2"} {
+		if !strings.Contains(out.String(), `proclimit_processes{limiter="`+name+`"} 1`) {
+			t.Errorf("expected %s to be discovered, but got:\n%s", name, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "other") {
+		t.Errorf("expected limiters without the prefix not to be discovered, but got:\n%s", out.String())
+	}
+}
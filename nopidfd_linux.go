@@ -0,0 +1,18 @@
+// +build linux,mips linux,mipsle linux,mips64 linux,mips64le
+
+package proclimit
+
+import (
+	"syscall"
+)
+
+// pidfds are not used on mips, whose syscall numbers differ by ABI. Processes are
+// identified by their start time instead.
+
+func pidfdOpen(pid int) (int, error) {
+	return -1, syscall.ENOSYS
+}
+
+func pidfdSendSignal(pidfd int, sig syscall.Signal) error {
+	return syscall.ENOSYS
+}
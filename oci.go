@@ -0,0 +1,96 @@
+package proclimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/friendsofgo/errors"
+	"sort"
+)
+
+// parseOCIResources decodes the resources for platform ("linux" or "windows")
+// into resources. data may either be a full OCI runtime spec (config.json), in
+// which case the resources are read from <platform>.resources, or a bare
+// resources document.
+//
+// It returns the path prefix of the resources within the document (used to
+// report fields), and the fields of the document that were not decoded into
+// resources.
+func parseOCIResources(data []byte, platform string, resources interface{}) (prefix string, ignored []string, err error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", nil, errors.Wrap(err, "invalid OCI config")
+	}
+	raw := interface{}(doc)
+	if _, ok := doc["ociVersion"]; ok {
+		for key := range doc {
+			if key != platform && key != "ociVersion" {
+				ignored = append(ignored, key)
+			}
+		}
+		platformDoc, _ := doc[platform].(map[string]interface{})
+		for key := range platformDoc {
+			if key != "resources" {
+				ignored = append(ignored, platform+"."+key)
+			}
+		}
+		prefix = platform + ".resources"
+		if raw = platformDoc["resources"]; raw == nil {
+			return "", nil, errors.Errorf("OCI config does not specify %s", prefix)
+		}
+	}
+
+	rawJSON, err := json.Marshal(raw)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "invalid OCI config")
+	}
+	if err := json.Unmarshal(rawJSON, resources); err != nil {
+		return "", nil, errors.Wrap(err, "invalid OCI resources")
+	}
+	decodedJSON, err := json.Marshal(resources)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "invalid OCI resources")
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(decodedJSON, &decoded); err != nil {
+		return "", nil, errors.Wrap(err, "invalid OCI resources")
+	}
+	ignored = append(ignored, unknownFields(raw, decoded, prefix)...)
+	sort.Strings(ignored)
+	return prefix, ignored, nil
+}
+
+// unknownFields returns the paths of all fields that are present in original,
+// but not in decoded.
+func unknownFields(original, decoded interface{}, path string) []string {
+	var fields []string
+	switch o := original.(type) {
+	case map[string]interface{}:
+		d, _ := decoded.(map[string]interface{})
+		for key, value := range o {
+			if value == nil {
+				continue
+			}
+			fieldPath := joinFieldPath(path, key)
+			if _, ok := d[key]; !ok {
+				fields = append(fields, fieldPath)
+				continue
+			}
+			fields = append(fields, unknownFields(value, d[key], fieldPath)...)
+		}
+	case []interface{}:
+		d, _ := decoded.([]interface{})
+		for i, value := range o {
+			if i < len(d) {
+				fields = append(fields, unknownFields(value, d[i], fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return fields
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,100 @@
+// +build linux
+
+package proclimit
+
+import (
+	"github.com/containerd/cgroups"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"sort"
+)
+
+// OCIOptions converts the linux.resources of an OCI runtime spec (config.json), or a bare
+// LinuxResources JSON document, into Options that apply exactly those resources.
+//
+// It also returns the fields of the document that will be ignored, such as the process
+// and mount configuration of a full runtime spec, or fields that are unknown to proclimit.
+//
+// Note that the devices of a container spec usually deny access to all devices that are
+// not explicitly allowed, which will also apply to the limited processes.
+func OCIOptions(data []byte) ([]Option, []string, error) {
+	resources := &specs.LinuxResources{}
+	_, ignored, err := parseOCIResources(data, "linux", resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []Option{WithLinuxResources(resources)}, ignored, nil
+}
+
+// ociSubsystems maps each field of LinuxResources to the subsystems that enforce it.
+var ociSubsystems = map[string][]cgroups.Name{
+	"devices":        {cgroups.Devices},
+	"memory":         {cgroups.Memory},
+	"cpu":            {cgroups.Cpu, cgroups.Cpuset},
+	"pids":           {cgroups.Pids},
+	"blockIO":        {cgroups.Blkio},
+	"hugepageLimits": {cgroups.Hugetlb},
+	"network":        {cgroups.NetCLS, cgroups.NetPrio},
+	"rdma":           {cgroups.Rdma},
+}
+
+// NewFromOCI creates a new Cgroup with the resources of an OCI runtime spec or LinuxResources
+// document (see OCIOptions). options are applied after the resources, so they take precedence.
+//
+// The returned fields include those of OCIOptions, as well as resources that could not be
+// applied because their cgroup subsystem is not mounted.
+func NewFromOCI(data []byte, options ...Option) (*Cgroup, []string, error) {
+	resources := &specs.LinuxResources{}
+	prefix, ignored, err := parseOCIResources(data, "linux", resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := New(append([]Option{WithLinuxResources(resources)}, options...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	mounted := make(map[cgroups.Name]bool)
+	for _, s := range c.cgroup.Subsystems() {
+		mounted[s.Name()] = true
+	}
+	for _, field := range setResourceFields(resources) {
+		applied := false
+		for _, name := range ociSubsystems[field] {
+			applied = applied || mounted[name]
+		}
+		if !applied {
+			ignored = append(ignored, joinFieldPath(prefix, field))
+		}
+	}
+	sort.Strings(ignored)
+	return c, ignored, nil
+}
+
+// setResourceFields returns the JSON names of the fields of resources that are set
+func setResourceFields(resources *specs.LinuxResources) []string {
+	var fields []string
+	if len(resources.Devices) > 0 {
+		fields = append(fields, "devices")
+	}
+	if resources.Memory != nil {
+		fields = append(fields, "memory")
+	}
+	if resources.CPU != nil {
+		fields = append(fields, "cpu")
+	}
+	if resources.Pids != nil {
+		fields = append(fields, "pids")
+	}
+	if resources.BlockIO != nil {
+		fields = append(fields, "blockIO")
+	}
+	if len(resources.HugepageLimits) > 0 {
+		fields = append(fields, "hugepageLimits")
+	}
+	if resources.Network != nil {
+		fields = append(fields, "network")
+	}
+	if len(resources.Rdma) > 0 {
+		fields = append(fields, "rdma")
+	}
+	return fields
+}
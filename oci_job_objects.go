@@ -0,0 +1,76 @@
+// +build windows
+
+package proclimit
+
+import (
+	"github.com/aoldershaw/proclimit/internal/win32"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"runtime"
+	"sort"
+)
+
+// OCIOptions converts the windows.resources of an OCI runtime spec (config.json), or a bare
+// WindowsResources JSON document, into Options that apply those resources.
+//
+// It also returns the fields of the document that will be ignored. CPU shares and storage
+// limits are not supported by job objects, and are always ignored.
+func OCIOptions(data []byte) ([]Option, []string, error) {
+	resources := &specs.WindowsResources{}
+	prefix, ignored, err := parseOCIResources(data, "windows", resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	var opts []Option
+	if resources.Memory != nil && resources.Memory.Limit != nil {
+		opts = append(opts, WithMemoryLimit(Memory(*resources.Memory.Limit)))
+	}
+	if cpu := resources.CPU; cpu != nil {
+		// Both Maximum and CPURate are expressed as a percentage of all processors times 100
+		if cpu.Maximum != nil {
+			opts = append(opts, withCPURate(uint32(*cpu.Maximum)))
+			if cpu.Count != nil {
+				ignored = append(ignored, joinFieldPath(prefix, "cpu.count"))
+			}
+		} else if cpu.Count != nil {
+			rate := *cpu.Count * 10000 / uint64(runtime.NumCPU())
+			if rate > 10000 {
+				rate = 10000
+			}
+			opts = append(opts, withCPURate(uint32(rate)))
+		}
+		if cpu.Shares != nil {
+			ignored = append(ignored, joinFieldPath(prefix, "cpu.shares"))
+		}
+	}
+	if resources.Storage != nil {
+		ignored = append(ignored, joinFieldPath(prefix, "storage"))
+	}
+	sort.Strings(ignored)
+	return opts, ignored, nil
+}
+
+// NewFromOCI creates a new JobObject with the resources of an OCI runtime spec or
+// WindowsResources document (see OCIOptions). options are applied after the resources,
+// so they take precedence.
+func NewFromOCI(data []byte, options ...Option) (*JobObject, []string, error) {
+	opts, ignored, err := OCIOptions(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	j, err := New(append(opts, options...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return j, ignored, nil
+}
+
+func withCPURate(rate uint32) Option {
+	return func(jobObject *JobObject) {
+		if jobObject.CPULimitInformation == nil {
+			jobObject.CPULimitInformation = &win32.JobObjectCPURateControlInformation{}
+		}
+		jobObject.CPULimitInformation.CPURate = rate
+		jobObject.CPULimitInformation.ControlFlags |= win32.JOB_OBJECT_CPU_RATE_CONTROL_ENABLE
+		jobObject.CPULimitInformation.ControlFlags |= win32.JOB_OBJECT_CPU_RATE_CONTROL_HARD_CAP
+	}
+}
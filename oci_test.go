@@ -0,0 +1,86 @@
+package proclimit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testResources struct {
+	Memory *struct {
+		Limit *int64 `json:"limit,omitempty"`
+	} `json:"memory,omitempty"`
+	Devices []struct {
+		Allow bool `json:"allow"`
+	} `json:"devices,omitempty"`
+}
+
+func TestParseOCIResourcesFullSpec(t *testing.T) {
+	var resources testResources
+	prefix, ignored, err := parseOCIResources([]byte(`{
+		"ociVersion": "1.0.1",
+		"process": {"args": ["sh"]},
+		"root": {"path": "rootfs"},
+		"linux": {
+			"namespaces": [{"type": "pid"}],
+			"resources": {
+				"memory": {"limit": 1024, "unified": 1},
+				"devices": [{"allow": false, "access": "rwm"}],
+				"futureResource": {}
+			}
+		}
+	}`), "linux", &resources)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if prefix != "linux.resources" {
+		t.Errorf("expected prefix linux.resources, but got %q", prefix)
+	}
+	if resources.Memory == nil || *resources.Memory.Limit != 1024 {
+		t.Errorf("expected memory limit to be decoded, but got %+v", resources.Memory)
+	}
+	expected := []string{
+		"linux.namespaces",
+		"linux.resources.devices[0].access",
+		"linux.resources.futureResource",
+		"linux.resources.memory.unified",
+		"process",
+		"root",
+	}
+	if !reflect.DeepEqual(ignored, expected) {
+		t.Errorf("expected ignored fields %v, but got %v", expected, ignored)
+	}
+}
+
+func TestParseOCIResourcesFragment(t *testing.T) {
+	var resources testResources
+	prefix, ignored, err := parseOCIResources([]byte(`{"memory": {"limit": 1024, "swap": null}, "cpu": {}}`), "linux", &resources)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if prefix != "" {
+		t.Errorf("expected no prefix, but got %q", prefix)
+	}
+	if !reflect.DeepEqual(ignored, []string{"cpu"}) {
+		t.Errorf("expected ignored fields [cpu], but got %v", ignored)
+	}
+}
+
+func TestParseOCIResourcesErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{"not json", `memory: 1`, "invalid OCI config"},
+		{"no resources", `{"ociVersion": "1.0.1", "linux": {}}`, "does not specify linux.resources"},
+		{"wrong type", `{"memory": {"limit": "1G"}}`, "invalid OCI resources"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseOCIResources([]byte(tt.data), "linux", &testResources{})
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("expected error containing %q, but got: %v", tt.error, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package proclimit
+
+import (
+	"syscall"
+)
+
+// The numbers of the pidfd syscalls are the same on every architecture but mips
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+// pidfdOpen opens a pidfd referring to the process pid. pidfds are always close-on-exec.
+func pidfdOpen(pid int) (int, error) {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func pidfdSendSignal(pidfd int, sig syscall.Signal) error {
+	_, _, errno := syscall.Syscall6(sysPidfdSendSignal, uintptr(pidfd), uintptr(sig), 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package proclimit
+
+import (
+	"fmt"
+	"github.com/friendsofgo/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PressureResource is a resource for which Pressure Stall Information is reported
+type PressureResource string
+
+const (
+	PressureCPU    PressureResource = "cpu"
+	PressureMemory PressureResource = "memory"
+	PressureIO     PressureResource = "io"
+)
+
+// Pressure is the Pressure Stall Information (PSI) of a limiter: the share of time its
+// processes were stalled waiting for CPU, memory or IO. Unlike usage, it shows whether the
+// processes are starved of a resource.
+type Pressure struct {
+	CPU    ResourcePressure
+	Memory ResourcePressure
+	IO     ResourcePressure
+}
+
+// ResourcePressure is the Pressure Stall Information of a single resource
+type ResourcePressure struct {
+	// Some is the time during which at least one process was stalled on the resource
+	Some PressureAverages
+	// Full is the time during which all non-idle processes were stalled at once
+	Full PressureAverages
+}
+
+// PressureAverages describes how much of the time processes were stalled
+type PressureAverages struct {
+	// Avg10, Avg60 and Avg300 are the percentages of time stalled over the last 10s,
+	// 60s and 300s
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	// Total is the total time stalled
+	Total time.Duration
+}
+
+// parseResourcePressure parses the contents of a pressure file, e.g.
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parseResourcePressure(data string) (ResourcePressure, error) {
+	var p ResourcePressure
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var averages *PressureAverages
+		switch fields[0] {
+		case "some":
+			averages = &p.Some
+		case "full":
+			averages = &p.Full
+		default:
+			return p, errors.Errorf("invalid pressure line %q", line)
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return p, errors.Errorf("invalid pressure line %q", line)
+			}
+			var err error
+			switch kv[0] {
+			case "avg10":
+				averages.Avg10, err = strconv.ParseFloat(kv[1], 64)
+			case "avg60":
+				averages.Avg60, err = strconv.ParseFloat(kv[1], 64)
+			case "avg300":
+				averages.Avg300, err = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				// The total is in microseconds
+				var total uint64
+				total, err = strconv.ParseUint(kv[1], 10, 64)
+				averages.Total = time.Duration(total) * time.Microsecond
+			}
+			if err != nil {
+				return p, errors.Wrapf(err, "invalid pressure line %q", line)
+			}
+		}
+	}
+	return p, nil
+}
+
+// PressureTrigger is a threshold of stall time, for which notifications are received (see
+// Cgroup.WatchPressure). For example, a trigger with Resource PressureMemory, a Stall of
+// 100ms and a Window of 1s fires whenever processes are stalled on memory for more than
+// 100ms within a second.
+type PressureTrigger struct {
+	Resource PressureResource
+	// Full makes the trigger measure the time during which all non-idle processes were
+	// stalled at once, rather than the time during which at least one was
+	Full bool
+	// Stall is the stall time within Window above which the trigger fires
+	Stall time.Duration
+	// Window is the period over which stall time is measured. It must be between 500ms and
+	// 10s, and a multiple of 2s for processes without CAP_SYS_RESOURCE.
+	Window time.Duration
+}
+
+func (t PressureTrigger) validate() error {
+	switch t.Resource {
+	case PressureCPU, PressureMemory, PressureIO:
+	default:
+		return errors.Errorf("invalid pressure trigger: unknown resource %q", t.Resource)
+	}
+	if t.Window < 500*time.Millisecond || t.Window > 10*time.Second {
+		return errors.Errorf("invalid pressure trigger: window %s is not between 500ms and 10s", t.Window)
+	}
+	if t.Stall <= 0 || t.Stall > t.Window {
+		return errors.Errorf("invalid pressure trigger: stall %s is not within the window of %s", t.Stall, t.Window)
+	}
+	return nil
+}
+
+// String returns the trigger in the format written to pressure files, e.g.
+// "some 100000 1000000" (the stall and window are in microseconds)
+func (t PressureTrigger) String() string {
+	kind := "some"
+	if t.Full {
+		kind = "full"
+	}
+	return fmt.Sprintf("%s %d %d", kind, t.Stall.Microseconds(), t.Window.Microseconds())
+}
@@ -0,0 +1,268 @@
+// +build linux
+
+package proclimit
+
+import (
+	"context"
+	"github.com/aoldershaw/proclimit/internal/cgroupfs"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pressurePollTimeout is how often WatchPressure checks whether its context is done while
+// waiting for a trigger to fire
+const pressurePollTimeout = 200 * time.Millisecond
+
+// unifiedRoot returns the mount point of the cgroup v2 hierarchy, or "" if none is mounted.
+// If tests point proclimit at another cgroup v1 hierarchy, its directory named unified is
+// used instead.
+func unifiedRoot() string {
+	root := cgroupfs.Root()
+	if root == "" {
+		return mountedUnifiedRoot()
+	}
+	unified := filepath.Join(root, "unified")
+	if _, err := os.Stat(unified); err != nil {
+		return ""
+	}
+	return unified
+}
+
+// mountedUnifiedRoot finds the cgroup v2 hierarchy mounted by the system. On hybrid
+// systems it is mounted alongside the v1 hierarchy, typically at /sys/fs/cgroup/unified.
+func mountedUnifiedRoot() string {
+	data, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// The filesystem type follows the optional fields, which are terminated by "-"
+		fields := strings.Fields(line)
+		for i := 6; i+1 < len(fields); i++ {
+			if fields[i] == "-" {
+				if fields[i+1] == "cgroup2" {
+					return fields[4]
+				}
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// unifiedPath returns the directory of the named cgroup within the cgroup v2 hierarchy,
+// or "" if none is mounted
+func unifiedPath(name string) string {
+	root := unifiedRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, filepath.FromSlash(name))
+}
+
+// createUnified creates a directory for the Cgroup within the cgroup v2 hierarchy, if one
+// is mounted, so that the Pressure of its processes can be read (see WithPressure). No controllers are
+// enabled in it, so limits are still enforced through v1 alone. Failures are ignored, as
+// they only make Pressure unavailable.
+func (c *Cgroup) createUnified() {
+	dir := unifiedPath(c.Name)
+	if dir == "" {
+		return
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return
+	}
+	c.unified = dir
+}
+
+// loadUnified finds the directory of an existing Cgroup within the cgroup v2 hierarchy
+func (c *Cgroup) loadUnified() {
+	dir := unifiedPath(c.Name)
+	if dir == "" {
+		return
+	}
+	if _, err := os.Stat(dir); err == nil {
+		c.unified = dir
+	}
+}
+
+// limitUnified moves a process into the directory of the Cgroup within the cgroup v2
+// hierarchy, if it has one. The process is already limited through v1, so a failure only
+// makes its Pressure unaccounted for, and is logged rather than returned.
+func (c *Cgroup) limitUnified(pid int) {
+	if c.unified == "" {
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.unified, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		log.Printf("proclimit: %s: failed to add process %d to cgroup v2 hierarchy: %v", c.Name, pid, err)
+	}
+}
+
+// removeUnified removes the directory of the Cgroup (including any children) from the
+// cgroup v2 hierarchy. Cgroups can only be removed with rmdir, children first - their
+// interface files cannot be removed. Like the v1 hierarchy, removal is retried, as the
+// kernel may not have released the cgroup right after its last process exited.
+func (c *Cgroup) removeUnified() error {
+	if c.unified == "" {
+		return nil
+	}
+	delay := 10 * time.Millisecond
+	var err error
+	for i := 0; i < 5; i++ {
+		if i != 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = removeDirs(c.unified); err == nil {
+			return nil
+		}
+	}
+	return errors.Wrap(err, "failed to remove cgroup from cgroup v2 hierarchy")
+}
+
+// removeDirs removes dir and the directories within it, deepest first. Files are left in
+// place, so removing a directory that contains any fails.
+func removeDirs(dir string) error {
+	var dirs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Walk visits directories before their contents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := syscall.Rmdir(dirs[i]); err != nil && err != syscall.ENOENT {
+			return os.NewSyscallError("rmdir", err)
+		}
+	}
+	return nil
+}
+
+// pressureFile returns the path of the pressure file of a resource
+func (c *Cgroup) pressureFile(resource PressureResource) (string, error) {
+	if c.unified == "" {
+		return "", errors.New("pressure stall information requires a cgroup created WithPressure, and a cgroup v2 hierarchy")
+	}
+	return filepath.Join(c.unified, string(resource)+".pressure"), nil
+}
+
+// Pressure returns the Pressure Stall Information of the processes in the Cgroup (including
+// nested cgroups). It is read from the cgroup v2 hierarchy, so it is only available for
+// Cgroups created WithPressure, on hybrid systems that mount one alongside the v1 hierarchy,
+// with a kernel that supports PSI (Linux 4.20 or later, with PSI enabled). Full CPU
+// pressure is only reported by Linux 5.13 and later.
+func (c *Cgroup) Pressure() (*Pressure, error) {
+	var pressure Pressure
+	for _, r := range []struct {
+		resource PressureResource
+		pressure *ResourcePressure
+	}{
+		{PressureCPU, &pressure.CPU},
+		{PressureMemory, &pressure.Memory},
+		{PressureIO, &pressure.IO},
+	} {
+		path, err := c.pressureFile(r.resource)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read pressure")
+		}
+		if *r.pressure, err = parseResourcePressure(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	return &pressure, nil
+}
+
+// WatchPressure registers a PSI trigger on the Cgroup, and returns a channel that receives
+// the time at which the trigger fires - i.e. whenever the processes in the Cgroup have been
+// stalled on trigger.Resource for longer than trigger.Stall within trigger.Window. The
+// kernel fires a trigger at most once per window. Notifications are dropped if the channel
+// has not been read since the last one.
+//
+// The channel is closed once ctx is done, or the Cgroup is deleted. WatchPressure has the
+// same requirements as Pressure.
+func (c *Cgroup) WatchPressure(ctx context.Context, trigger PressureTrigger) (<-chan time.Time, error) {
+	if err := trigger.validate(); err != nil {
+		return nil, err
+	}
+	path, err := c.pressureFile(trigger.Resource)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrap(os.NewSyscallError("open", err), "failed to open pressure")
+	}
+	epfd, err := registerPressureTrigger(fd, trigger)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	ch := make(chan time.Time, 1)
+	go func() {
+		defer close(ch)
+		defer syscall.Close(fd)
+		defer syscall.Close(epfd)
+		events := make([]syscall.EpollEvent, 1)
+		for ctx.Err() == nil {
+			n, err := syscall.EpollWait(epfd, events, int(pressurePollTimeout/time.Millisecond))
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			if events[0].Events&syscall.EPOLLERR != 0 {
+				// The cgroup has been deleted
+				return
+			}
+			select {
+			case ch <- time.Now():
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// registerPressureTrigger writes trigger to an open pressure file, and returns an epoll
+// instance that reports when it fires
+func registerPressureTrigger(fd int, trigger PressureTrigger) (int, error) {
+	// The trigger must be written in a single write, terminated by a NUL byte
+	if _, err := syscall.Write(fd, append([]byte(trigger.String()), 0)); err != nil {
+		return 0, errors.Wrap(os.NewSyscallError("write", err), "failed to register pressure trigger")
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return 0, os.NewSyscallError("epoll_create1", err)
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLPRI, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		syscall.Close(epfd)
+		return 0, errors.Wrap(os.NewSyscallError("epoll_ctl", err), "failed to watch pressure")
+	}
+	return epfd, nil
+}
@@ -0,0 +1,19 @@
+// +build windows
+
+package proclimit
+
+import (
+	"context"
+	"github.com/friendsofgo/errors"
+	"time"
+)
+
+// Pressure is not supported on Windows, as Pressure Stall Information is a Linux feature
+func (j *JobObject) Pressure() (*Pressure, error) {
+	return nil, errors.New("pressure stall information is not supported on windows")
+}
+
+// WatchPressure is not supported on Windows, as Pressure Stall Information is a Linux feature
+func (j *JobObject) WatchPressure(ctx context.Context, trigger PressureTrigger) (<-chan time.Time, error) {
+	return nil, errors.New("pressure stall information is not supported on windows")
+}
@@ -0,0 +1,24 @@
+package proclimit
+
+import (
+	"fmt"
+)
+
+// ProcessGoneError is returned by OpenProcess when there is no process with the pid, and
+// by LimitProcess when the process exited before it could be limited (or while it was
+// being limited).
+type ProcessGoneError struct {
+	// Pid is the pid of the process that exited
+	Pid int
+	// Reused is true if the pid was reused by another process after the process exited,
+	// in which case that unrelated process may have been limited in its place (and is not
+	// moved back)
+	Reused bool
+}
+
+func (e *ProcessGoneError) Error() string {
+	if e.Reused {
+		return fmt.Sprintf("process %d exited before it was limited, and its pid was reused", e.Pid)
+	}
+	return fmt.Sprintf("process %d has exited", e.Pid)
+}
@@ -0,0 +1,113 @@
+// +build linux
+
+package proclimit
+
+import (
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"github.com/friendsofgo/errors"
+	"os"
+	"syscall"
+)
+
+// ProcessHandle refers to a running process, obtained with OpenProcess. Unlike a pid, it
+// cannot be confused with another process that reuses the pid after the process exits.
+//
+// On Linux, a handle holds a pidfd referring to the process itself where the kernel
+// supports it (Linux 5.3+), and the start time of the process otherwise.
+type ProcessHandle struct {
+	Pid       int
+	pidfd     int
+	startTime uint64
+}
+
+// OpenProcess returns a handle to the process that currently has pid. A *ProcessGoneError
+// is returned if there is none. The handle should be opened as soon as the process is
+// found (e.g. before deciding to limit it based on its pid), so that it refers to that
+// process, and released once it is no longer needed.
+func OpenProcess(pid int) (*ProcessHandle, error) {
+	h := &ProcessHandle{Pid: pid, pidfd: -1}
+	fd, err := pidfdOpen(pid)
+	switch err {
+	case nil:
+		h.pidfd = fd
+	case syscall.ESRCH:
+		return nil, &ProcessGoneError{Pid: pid}
+	default:
+		// pidfds are not supported (or are blocked by a seccomp filter)
+	}
+	// If the pid is reused after the pidfd was opened, the start time is that of the
+	// new process - but the pidfd no longer refers to a running process, so check
+	// finds that it has gone.
+	stat, err := procfs.ReadStat(pid)
+	if err != nil || stat.State == "Z" {
+		h.Release()
+		if err == nil || os.IsNotExist(err) {
+			return nil, &ProcessGoneError{Pid: pid}
+		}
+		return nil, err
+	}
+	h.startTime = stat.StartTime
+	return h, nil
+}
+
+// check returns a *ProcessGoneError if the process has exited since the handle was opened
+func (h *ProcessHandle) check() error {
+	alive := true
+	if h.pidfd >= 0 {
+		alive = pidfdSendSignal(h.pidfd, 0) != syscall.ESRCH
+	}
+	stat, err := procfs.ReadStat(h.Pid)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ProcessGoneError{Pid: h.Pid}
+		}
+		return err
+	}
+	if !alive || stat.StartTime != h.startTime {
+		return &ProcessGoneError{Pid: h.Pid, Reused: true}
+	}
+	if stat.State == "Z" {
+		return &ProcessGoneError{Pid: h.Pid}
+	}
+	return nil
+}
+
+// Signal sends sig to the process of h. A *ProcessGoneError is returned if it has exited,
+// so that sig is never sent to another process that reused its pid. Without pidfds, the
+// process is checked right before it is signalled instead.
+func (h *ProcessHandle) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.Errorf("unsupported signal %v", sig)
+	}
+	if h.pidfd >= 0 {
+		err := pidfdSendSignal(h.pidfd, s)
+		if err == syscall.ESRCH {
+			return &ProcessGoneError{Pid: h.Pid}
+		}
+		if err != nil {
+			return os.NewSyscallError("pidfd_send_signal", err)
+		}
+		return nil
+	}
+	if err := h.check(); err != nil {
+		return err
+	}
+	if err := syscall.Kill(h.Pid, s); err != nil {
+		if err == syscall.ESRCH {
+			return &ProcessGoneError{Pid: h.Pid}
+		}
+		return os.NewSyscallError("kill", err)
+	}
+	return nil
+}
+
+// Release releases the resources held by the handle
+func (h *ProcessHandle) Release() error {
+	if h.pidfd < 0 {
+		return nil
+	}
+	err := syscall.Close(h.pidfd)
+	h.pidfd = -1
+	return err
+}
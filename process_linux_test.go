@@ -0,0 +1,50 @@
+package proclimit
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestProcessHandleReused(t *testing.T) {
+	h, err := OpenProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Release()
+	if err := h.check(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	// A process with the same pid that started at a different time is another process
+	h.startTime++
+	err = h.check()
+	if goneErr, ok := err.(*ProcessGoneError); !ok || !goneErr.Reused {
+		t.Errorf("expected a *ProcessGoneError with Reused, but got: %v", err)
+	}
+}
+
+func TestProcessHandleSignal(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start command: %v", err)
+	}
+	h, err := OpenProcess(cmd.Process.Pid)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		t.Fatal(err)
+	}
+	defer h.Release()
+	if err := h.Signal(syscall.SIGKILL); err != nil {
+		t.Errorf("expected no error, but got: %v", err)
+	}
+	cmd.Wait()
+
+	// Once the process has exited, its pid may be reused, so it is not signalled
+	err = h.Signal(syscall.SIGKILL)
+	if _, ok := err.(*ProcessGoneError); !ok {
+		t.Errorf("expected a *ProcessGoneError, but got: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// +build windows
+
+package proclimit
+
+import (
+	"github.com/aoldershaw/proclimit/internal/win32"
+	"os"
+	"syscall"
+)
+
+// ProcessHandle refers to a running process, obtained with OpenProcess. Unlike a pid, it
+// cannot be confused with another process that reuses the pid after the process exits.
+//
+// On Windows, a handle holds a process handle, and pids are not reused while it is open.
+type ProcessHandle struct {
+	Pid    int
+	handle win32.Handle
+}
+
+// OpenProcess returns a handle to the process that currently has pid. A *ProcessGoneError
+// is returned if there is none. The handle should be opened as soon as the process is
+// found (e.g. before deciding to limit it based on its pid), so that it refers to that
+// process, and released once it is no longer needed.
+func OpenProcess(pid int) (*ProcessHandle, error) {
+	handle, err := win32.OpenProcess(win32.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return nil, &ProcessGoneError{Pid: pid}
+	}
+	h := &ProcessHandle{Pid: pid, handle: handle}
+	if err := h.check(); err != nil {
+		h.Release()
+		return nil, err
+	}
+	return h, nil
+}
+
+// check returns a *ProcessGoneError if the process has exited since the handle was opened
+func (h *ProcessHandle) check() error {
+	var exitCode uint32
+	if err := syscall.GetExitCodeProcess(h.handle, &exitCode); err != nil {
+		return err
+	}
+	const stillActive = 259
+	if exitCode != stillActive {
+		return &ProcessGoneError{Pid: h.Pid}
+	}
+	return nil
+}
+
+// Signal sends sig to the process of h. A *ProcessGoneError is returned if it has exited.
+// As with os.Process, only os.Kill is supported.
+func (h *ProcessHandle) Signal(sig os.Signal) error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	process, err := os.FindProcess(h.Pid)
+	if err != nil {
+		return err
+	}
+	defer process.Release()
+	return process.Signal(sig)
+}
+
+// Release releases the resources held by the handle
+func (h *ProcessHandle) Release() error {
+	if h.handle == 0 {
+		return nil
+	}
+	err := win32.CloseHandle(h.handle)
+	h.handle = 0
+	return err
+}
@@ -0,0 +1,251 @@
+// +build linux
+
+package proclimittest
+
+import (
+	"fmt"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/internal/cgroupfs"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupSubsystems are the subsystems created within a CgroupFS
+var cgroupSubsystems = []string{"freezer", "pids", "cpuset", "cpu", "cpuacct", "memory", "blkio"}
+
+// unlimitedMemory is the memory.limit_in_bytes reported by a cgroup without a memory limit
+const unlimitedMemory = "9223372036854771712"
+
+// clockTicks is the unit of the CPU times in cpuacct.stat (USER_HZ)
+const clockTicks = 100
+
+// CgroupFS is a fake cgroup v1 hierarchy in a temporary directory. While it is open,
+// proclimit.New, Existing and List operate on it instead of the system's cgroups, so
+// code using Cgroups can be tested end-to-end without root.
+//
+// Limits are written to the controller files as they would be on a real hierarchy, but
+// are not enforced, and usage is only reported once it has been set with SetStats.
+// Unlike a real hierarchy, cgroup.procs only holds the most recently limited process.
+type CgroupFS struct {
+	// Root is the directory containing a directory per subsystem, like /sys/fs/cgroup, and a
+	// cgroup v2 hierarchy named unified (in which pressure is reported)
+	Root string
+	// StateDir is the directory in which proclimit records the metadata of Cgroups
+	StateDir string
+
+	dir     string
+	restore func()
+}
+
+// NewCgroupFS creates a CgroupFS, and points proclimit at it. Close must be called to
+// remove it and restore the system's cgroups. Only one CgroupFS may be open at a time.
+func NewCgroupFS() (*CgroupFS, error) {
+	dir, err := ioutil.TempDir("", "proclimittest")
+	if err != nil {
+		return nil, err
+	}
+	fs := &CgroupFS{
+		Root:     filepath.Join(dir, "cgroup"),
+		StateDir: filepath.Join(dir, "state"),
+		dir:      dir,
+	}
+	for _, subsystem := range append(cgroupSubsystems, "unified") {
+		if err := os.MkdirAll(filepath.Join(fs.Root, subsystem), 0755); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	// New cpusets inherit the CPUs and memory nodes of the root
+	cpuset := filepath.Join(fs.Root, "cpuset")
+	if err := ioutil.WriteFile(filepath.Join(cpuset, "cpuset.cpus"), []byte("0-3"), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(cpuset, "cpuset.mems"), []byte("0"), 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	fs.restore = cgroupfs.Set(fs.Root, fs.StateDir)
+	return fs, nil
+}
+
+// Close restores the system's cgroups, and removes the CgroupFS
+func (fs *CgroupFS) Close() error {
+	fs.restore()
+	return os.RemoveAll(fs.dir)
+}
+
+// Path returns the directory of the named cgroup within a subsystem (e.g. "memory")
+func (fs *CgroupFS) Path(subsystem, name string) string {
+	return filepath.Join(fs.Root, subsystem, filepath.FromSlash(name))
+}
+
+// ReadFile returns the trimmed contents of a controller file of the named cgroup, e.g.
+// ReadFile("memory", "my-cgroup", "memory.limit_in_bytes")
+func (fs *CgroupFS) ReadFile(subsystem, name, file string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(fs.Path(subsystem, name), file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (fs *CgroupFS) writeFile(subsystem, name, file, contents string) error {
+	dir := fs.Path(subsystem, name)
+	if _, err := os.Stat(dir); err != nil {
+		return errors.Wrapf(err, "cgroup %s does not exist", name)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(contents), 0644)
+}
+
+// Processes returns the pids in cgroup.procs of the named cgroup
+func (fs *CgroupFS) Processes(name string) ([]int, error) {
+	procs, err := fs.ReadFile("freezer", name, "cgroup.procs")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(procs) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// SetStats writes the controller files of the named cgroup so that it reports stats.
+// MemoryLimit is ignored - the limit is that of the cgroup. If Processes is 0, the
+// processes in cgroup.procs are counted.
+func (fs *CgroupFS) SetStats(name string, stats proclimit.Stats) error {
+	ticks := func(d time.Duration) int64 {
+		return int64(d * clockTicks / time.Second)
+	}
+	files := []struct {
+		subsystem, file, contents string
+	}{
+		{"cpuacct", "cpuacct.usage", strconv.FormatInt(int64(stats.CPUUsage), 10)},
+		{"cpuacct", "cpuacct.usage_percpu", strconv.FormatInt(int64(stats.CPUUsage), 10)},
+		{"cpuacct", "cpuacct.stat", fmt.Sprintf("user %d\nsystem %d\n", ticks(stats.UserCPU), ticks(stats.SystemCPU))},
+		{"cpu", "cpu.stat", fmt.Sprintf("nr_periods %d\nnr_throttled %d\nthrottled_time %d\n",
+			stats.CPUPeriods, stats.ThrottledPeriods, int64(stats.ThrottledTime))},
+		{"memory", "memory.stat", fmt.Sprintf("rss %d\n", uint64(stats.MemoryUsage))},
+		{"memory", "memory.usage_in_bytes", strconv.FormatUint(uint64(stats.MemoryUsage), 10)},
+		{"memory", "memory.max_usage_in_bytes", strconv.FormatUint(uint64(stats.MemoryMaxUsage), 10)},
+		{"memory", "memory.failcnt", "0"},
+		{"memory", "memory.oom_control", fmt.Sprintf("oom_kill_disable 0\nunder_oom 0\noom_kill %d\n", stats.OOMKills)},
+		{"blkio", "blkio.throttle.io_service_bytes", fmt.Sprintf("8:0 Read %d\n8:0 Write %d\n", stats.IOReadBytes, stats.IOWriteBytes)},
+		{"blkio", "blkio.throttle.io_serviced", "8:0 Read 0\n8:0 Write 0\n"},
+	}
+	for _, f := range files {
+		if err := fs.writeFile(f.subsystem, name, f.file, f.contents); err != nil {
+			return err
+		}
+	}
+	// Swap and kernel memory are reported along with memory usage
+	for _, module := range []string{"memsw", "kmem", "kmem.tcp"} {
+		for _, file := range []string{"usage_in_bytes", "max_usage_in_bytes", "failcnt", "limit_in_bytes"} {
+			path := "memory." + module + "." + file
+			if _, err := os.Stat(filepath.Join(fs.Path("memory", name), path)); err == nil {
+				continue
+			}
+			if err := fs.writeFile("memory", name, path, "0"); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(fs.Path("memory", name), "memory.limit_in_bytes")); os.IsNotExist(err) {
+		if err := fs.writeFile("memory", name, "memory.limit_in_bytes", unlimitedMemory); err != nil {
+			return err
+		}
+	}
+	if stats.Processes == 0 {
+		err := os.Remove(filepath.Join(fs.Path("pids", name), "pids.current"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(fs.Path("pids", name), "pids.max")); os.IsNotExist(err) {
+		if err := fs.writeFile("pids", name, "pids.max", "max"); err != nil {
+			return err
+		}
+	}
+	return fs.writeFile("pids", name, "pids.current", strconv.Itoa(stats.Processes))
+}
+
+// SetPressure writes the pressure file of a resource of the named cgroup, so that it reports
+// pressure. Triggers cannot be registered on a CgroupFS, so WatchPressure always fails.
+func (fs *CgroupFS) SetPressure(name string, resource proclimit.PressureResource, pressure proclimit.ResourcePressure) error {
+	line := func(kind string, averages proclimit.PressureAverages) string {
+		return fmt.Sprintf("%s avg10=%.2f avg60=%.2f avg300=%.2f total=%d\n",
+			kind, averages.Avg10, averages.Avg60, averages.Avg300, averages.Total.Microseconds())
+	}
+	return fs.writeFile("unified", name, string(resource)+".pressure", line("some", pressure.Some)+line("full", pressure.Full))
+}
+
+// SimulateOOM simulates the OOM killer within the named cgroup: the oom_kill counter is
+// incremented, and the process in cgroup.procs is killed (if any).
+func (fs *CgroupFS) SimulateOOM(name string) error {
+	oomKills, err := fs.counter("memory", name, "memory.oom_control", "oom_kill")
+	if err != nil {
+		return err
+	}
+	if err := fs.writeFile("memory", name, "memory.oom_control",
+		fmt.Sprintf("oom_kill_disable 0\nunder_oom 0\noom_kill %d\n", oomKills+1)); err != nil {
+		return err
+	}
+	pids, err := fs.Processes(name)
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return errors.Wrapf(err, "failed to kill process %d", pid)
+		}
+	}
+	return nil
+}
+
+// SimulateThrottling simulates the CPU limit of the named cgroup being enforced: periods
+// CPU periods elapse, of which throttledPeriods were throttled for a total of throttledTime.
+func (fs *CgroupFS) SimulateThrottling(name string, periods, throttledPeriods uint64, throttledTime time.Duration) error {
+	values := map[string]uint64{}
+	for _, key := range []string{"nr_periods", "nr_throttled", "throttled_time"} {
+		v, err := fs.counter("cpu", name, "cpu.stat", key)
+		if err != nil {
+			return err
+		}
+		values[key] = v
+	}
+	return fs.writeFile("cpu", name, "cpu.stat", fmt.Sprintf("nr_periods %d\nnr_throttled %d\nthrottled_time %d\n",
+		values["nr_periods"]+periods, values["nr_throttled"]+throttledPeriods, values["throttled_time"]+uint64(throttledTime)))
+}
+
+// counter reads a "key value" line from a controller file. Missing files and keys are 0.
+func (fs *CgroupFS) counter(subsystem, name, file, key string) (uint64, error) {
+	contents, err := fs.ReadFile(subsystem, name, file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, nil
+}
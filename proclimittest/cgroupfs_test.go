@@ -0,0 +1,84 @@
+// +build linux
+
+package proclimittest
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCgroupFS(t *testing.T) {
+	fs, err := NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+
+	cgroup, err := proclimit.New(
+		proclimit.WithName("test"),
+		proclimit.WithCPULimit(50),
+		proclimit.WithMemoryLimit(100*proclimit.Megabyte),
+	)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if quota, _ := fs.ReadFile("cpu", "test", "cpu.cfs_quota_us"); quota != "50000" {
+		t.Errorf("expected cpu.cfs_quota_us 50000, but got %q", quota)
+	}
+	if limit, _ := fs.ReadFile("memory", "test", "memory.limit_in_bytes"); limit != "104857600" {
+		t.Errorf("expected memory.limit_in_bytes 104857600, but got %q", limit)
+	}
+	if names, _ := proclimit.List(); !reflect.DeepEqual(names, []string{"test"}) {
+		t.Errorf("expected cgroups [test], but got %v", names)
+	}
+
+	cmd := cgroup.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if pids, _ := fs.Processes("test"); !reflect.DeepEqual(pids, []int{cmd.Process.Pid}) {
+		t.Errorf("expected pid %d to be limited, but got %v", cmd.Process.Pid, pids)
+	}
+	if err := fs.SetStats("test", proclimit.Stats{CPUUsage: time.Second, MemoryUsage: proclimit.Megabyte}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SimulateThrottling("test", 10, 5, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SimulateOOM("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("expected the command to be killed")
+	}
+	existing, err := proclimit.Existing("test")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	stats, err := existing.Stats()
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	expected := proclimit.Stats{
+		CPUUsage:         time.Second,
+		ThrottledTime:    time.Second,
+		CPUPeriods:       10,
+		ThrottledPeriods: 5,
+		MemoryUsage:      proclimit.Megabyte,
+		MemoryLimit:      100 * proclimit.Megabyte,
+		OOMKills:         1,
+		Processes:        1,
+	}
+	if *stats != expected {
+		t.Errorf("expected %+v, but got %+v", expected, *stats)
+	}
+
+	if err := cgroup.Close(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if names, _ := proclimit.List(); len(names) != 0 {
+		t.Errorf("expected no cgroups, but got %v", names)
+	}
+}
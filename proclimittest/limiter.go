@@ -0,0 +1,198 @@
+// Package proclimittest provides utilities for testing code that uses proclimit without
+// root privileges: a fake Limiter that records how it is used, and (on Linux) a fake
+// cgroup hierarchy in a temporary directory that the real Cgroup can be pointed at.
+package proclimittest
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Limiter is a fake proclimit.Limiter that records the processes it limits and the signals
+// it sends, and reports scripted Stats. It does not limit any resources.
+//
+// Limit, Signal and Stats can be made to fail by setting LimitErr, SignalErr and StatsErr.
+// OOM kills and CPU throttling can be simulated with SimulateOOM and SimulateThrottling.
+type Limiter struct {
+	// LimitErr is returned by Limit, if set. The process is not recorded.
+	LimitErr error
+	// SignalErr is returned by Signal, if set. The processes are not signalled.
+	SignalErr error
+	// StatsErr is returned by Stats, if set
+	StatsErr error
+	// OnLimit is called by Limit after the process has been recorded, if set. Its error
+	// is returned by Limit. It can be used to simulate events as soon as a command starts.
+	OnLimit func(pid int) error
+
+	mu sync.Mutex
+	// processes are the handles of the limited processes that have not been found to
+	// have exited, in the order they were limited. Handles are kept rather than pids, so
+	// that processes that reuse the pid of an exited process are not signalled.
+	processes []*proclimit.ProcessHandle
+	signals   []os.Signal
+	stats     proclimit.Stats
+	updates   int
+	closed    bool
+}
+
+// NewLimiter creates a fake Limiter
+func NewLimiter() *Limiter {
+	return &Limiter{}
+}
+
+// Command constructs a wrapped Cmd struct to execute the named program with the given
+// arguments. The Cmd will be added to the Limiter when it is started.
+func (l *Limiter) Command(name string, arg ...string) *proclimit.Cmd {
+	return &proclimit.Cmd{
+		Cmd:     exec.Command(name, arg...),
+		Limiter: l,
+	}
+}
+
+// Limit records the process pid, unless LimitErr is set. Like a real Limiter, it fails if
+// there is no such process.
+func (l *Limiter) Limit(pid int) error {
+	if l.LimitErr != nil {
+		return l.LimitErr
+	}
+	h, err := proclimit.OpenProcess(pid)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.processes = append(l.processes, h)
+	l.mu.Unlock()
+	if l.OnLimit != nil {
+		return l.OnLimit(pid)
+	}
+	return nil
+}
+
+// Processes returns the pids of the limited processes that are still running, in the order
+// they were limited
+func (l *Limiter) Processes() ([]int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var pids []int
+	for _, h := range l.running() {
+		pids = append(pids, h.Pid)
+	}
+	return pids, nil
+}
+
+// running returns the handles of the limited processes that are still running, and
+// releases those of the processes that have exited. l.mu must be held.
+func (l *Limiter) running() []*proclimit.ProcessHandle {
+	running := l.processes[:0]
+	for _, h := range l.processes {
+		if _, gone := h.Signal(syscall.Signal(0)).(*proclimit.ProcessGoneError); gone {
+			h.Release()
+			continue
+		}
+		running = append(running, h)
+	}
+	l.processes = running
+	return append([]*proclimit.ProcessHandle(nil), running...)
+}
+
+// Signal records sig, and sends it to all limited processes that are still running
+// (unless SignalErr is set)
+func (l *Limiter) Signal(sig os.Signal) error {
+	if l.SignalErr != nil {
+		return l.SignalErr
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.signals = append(l.signals, sig)
+	for _, h := range l.running() {
+		h.Signal(sig)
+	}
+	return nil
+}
+
+// Signals returns the signals that have been sent, in order
+func (l *Limiter) Signals() []os.Signal {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]os.Signal(nil), l.signals...)
+}
+
+// Stats returns the Stats set by SetStats (and modified by SimulateOOM and
+// SimulateThrottling), unless StatsErr is set. If Processes has not been set, it is the
+// number of limited processes that are still running.
+func (l *Limiter) Stats() (*proclimit.Stats, error) {
+	if l.StatsErr != nil {
+		return nil, l.StatsErr
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.stats
+	if stats.Processes == 0 {
+		stats.Processes = len(l.running())
+	}
+	return &stats, nil
+}
+
+// SetStats sets the Stats reported by the Limiter
+func (l *Limiter) SetStats(stats proclimit.Stats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats = stats
+}
+
+// SimulateOOM simulates the OOM killer: OOMKills is incremented, and the most recently
+// limited process that is still running is killed (if any).
+func (l *Limiter) SimulateOOM() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.OOMKills++
+	running := l.running()
+	if len(running) == 0 {
+		return nil
+	}
+	return running[len(running)-1].Signal(os.Kill)
+}
+
+// SimulateThrottling simulates the CPU limit being enforced: periods CPU periods elapse,
+// of which throttledPeriods were throttled for a total of throttledTime.
+func (l *Limiter) SimulateThrottling(periods, throttledPeriods uint64, throttledTime time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.CPUPeriods += periods
+	l.stats.ThrottledPeriods += throttledPeriods
+	l.stats.ThrottledTime += throttledTime
+}
+
+// Update records that the limits have been updated. The options are not applied.
+func (l *Limiter) Update(options ...proclimit.Option) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.updates++
+	return nil
+}
+
+// Updates returns the number of times Update has been called
+func (l *Limiter) Updates() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.updates
+}
+
+// Close records that the Limiter has been closed
+func (l *Limiter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return nil
+}
+
+// Closed returns whether Close has been called
+func (l *Limiter) Closed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
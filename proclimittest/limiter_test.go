@@ -0,0 +1,80 @@
+package proclimittest
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimiterRecordsProcesses(t *testing.T) {
+	l := NewLimiter()
+	cmd := l.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	pids, _ := l.Processes()
+	if !reflect.DeepEqual(pids, []int{cmd.Process.Pid}) {
+		t.Errorf("expected pid %d to be limited, but got %v", cmd.Process.Pid, pids)
+	}
+	if err := l.Signal(os.Kill); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("expected the command to be killed")
+	}
+	if !reflect.DeepEqual(l.Signals(), []os.Signal{os.Kill}) {
+		t.Errorf("expected os.Kill to be sent, but got %v", l.Signals())
+	}
+	// Processes that have exited are forgotten, so that a process that reuses the pid is
+	// never signalled
+	if pids, _ := l.Processes(); len(pids) != 0 {
+		t.Errorf("expected exited processes not to be reported, but got %v", pids)
+	}
+}
+
+func TestLimiterLimitErr(t *testing.T) {
+	l := NewLimiter()
+	l.LimitErr = errors.New("limit error")
+	cmd := l.Command("sleep", "10")
+	if err := cmd.Start(); errors.Cause(err) != l.LimitErr {
+		t.Errorf("expected error %q, but got: %v", l.LimitErr, err)
+	}
+	if pids, _ := l.Processes(); len(pids) != 0 {
+		t.Errorf("expected no processes to be recorded, but got %v", pids)
+	}
+}
+
+func TestLimiterSimulateOOM(t *testing.T) {
+	l := NewLimiter()
+	cmd := l.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if err := l.SimulateOOM(); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("expected the command to be killed")
+	}
+	if cmd.Usage().OOMKills != 1 {
+		t.Errorf("expected 1 OOM kill, but got %d", cmd.Usage().OOMKills)
+	}
+}
+
+func TestLimiterSimulateThrottling(t *testing.T) {
+	l := NewLimiter()
+	l.SetStats(proclimit.Stats{CPUUsage: time.Second})
+	l.SimulateThrottling(10, 4, time.Second)
+	l.SimulateThrottling(10, 1, time.Second)
+	stats, err := l.Stats()
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	expected := proclimit.Stats{CPUUsage: time.Second, CPUPeriods: 20, ThrottledPeriods: 5, ThrottledTime: 2 * time.Second}
+	if *stats != expected {
+		t.Errorf("expected %+v, but got %+v", expected, *stats)
+	}
+}
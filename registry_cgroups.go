@@ -0,0 +1,200 @@
+// +build linux
+
+package proclimit
+
+import (
+	"encoding/json"
+	"github.com/aoldershaw/proclimit/internal/cgroupfs"
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"github.com/friendsofgo/errors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateDir is the directory in which the metadata of every Cgroup created by New is
+// recorded. This allows proclimit-managed cgroups to be distinguished from cgroups
+// created by other tools. It is /run/proclimit, unless tests point proclimit elsewhere.
+func stateDir() string {
+	return cgroupfs.StateDir()
+}
+
+const metadataExt = ".json"
+
+// Metadata is recorded for every Cgroup created by New, and describes how it was created
+type Metadata struct {
+	Name string `json:"name"`
+	// Creator is the process that created the Cgroup
+	Creator *Creator `json:"creator,omitempty"`
+	// CreatedAt is when the Cgroup was created
+	CreatedAt time.Time `json:"createdAt"`
+	// Resources are the resources the Cgroup was created with. They may since have been
+	// changed by Update - the LinuxResources of a Cgroup returned by Existing are current.
+	Resources *specs.LinuxResources `json:"resources,omitempty"`
+	// CPUTimeBudget is the budget set by WithCPUTimeBudget, if any
+	CPUTimeBudget time.Duration `json:"cpuTimeBudget,omitempty"`
+	// WallTimeout is the timeout set by WithWallTimeout, if any
+	WallTimeout time.Duration `json:"wallTimeout,omitempty"`
+	// Labels are the labels set by WithLabels
+	Labels map[string]string `json:"labels,omitempty"`
+	// Owned is whether the Cgroup is owned by its Creator, which closes it before exiting
+	// (see WithOwned). Only owned Cgroups are collected by CleanupStale.
+	Owned bool `json:"owned,omitempty"`
+}
+
+// Creator identifies the process that created a Cgroup. It is also used to find Cgroups
+// leaked by processes that exited without closing them (see CleanupStale). Metadata is
+// readable by every user, so that they can List Cgroups, and so the command line of the
+// process (which may contain secrets) is not recorded.
+type Creator struct {
+	Pid int `json:"pid"`
+	// StartTime is the time the process started, in clock ticks after boot. It
+	// distinguishes the creator from later processes with the same pid.
+	StartTime uint64 `json:"startTime"`
+	// BootID identifies the boot during which the process ran
+	BootID string `json:"bootId"`
+	// UID is the user ID of the process
+	UID int `json:"uid"`
+}
+
+// bootIDPath contains a random ID that changes on every boot
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+func currentCreator() *Creator {
+	c := &Creator{Pid: os.Getpid(), UID: os.Getuid()}
+	if stat, err := procfs.ReadStat(c.Pid); err == nil {
+		c.StartTime = stat.StartTime
+	}
+	if bootID, err := ioutil.ReadFile(bootIDPath); err == nil {
+		c.BootID = strings.TrimSpace(string(bootID))
+	}
+	return c
+}
+
+// running returns whether the creator is still running
+func (c *Creator) running() bool {
+	if c.BootID != "" {
+		if bootID, err := ioutil.ReadFile(bootIDPath); err == nil && strings.TrimSpace(string(bootID)) != c.BootID {
+			return false
+		}
+	}
+	stat, err := procfs.ReadStat(c.Pid)
+	if err != nil || stat.State == "Z" {
+		// Zombies have exited, but not been reaped yet
+		return false
+	}
+	return c.StartTime == 0 || stat.StartTime == c.StartTime
+}
+
+func metadataPath(name string) string {
+	return filepath.Join(stateDir(), filepath.FromSlash(name)+metadataExt)
+}
+
+// createMetadata records m. If metadata is already recorded for the name (because a Cgroup
+// of the same name exists), it is left untouched, and an error satisfying os.IsExist is
+// returned.
+func createMetadata(m *Metadata) error {
+	path := metadataPath(m.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create state directory")
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that readers never see a partial file, and then
+	// link it into place, which unlike renaming fails if the metadata already exists
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to write metadata")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write metadata")
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if os.IsExist(err) {
+			return err
+		}
+		return errors.Wrap(err, "failed to write metadata")
+	}
+	return nil
+}
+
+func readMetadata(name string) (*Metadata, error) {
+	data, err := ioutil.ReadFile(metadataPath(name))
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "invalid metadata for %s", name)
+	}
+	return m, nil
+}
+
+func removeMetadata(name string) error {
+	err := os.Remove(metadataPath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove metadata")
+	}
+	return nil
+}
+
+// List returns the names of all cgroups created by New that have not been closed, and
+// whose labels match all of the selectors.
+func List(selectors ...Selector) ([]string, error) {
+	requirements, err := parseSelectors(selectors)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	dir := stateDir()
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, metadataExt) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, metadataExt))
+		if len(requirements) > 0 {
+			m, err := readMetadata(name)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// Closed while listing
+					return nil
+				}
+				return err
+			}
+			if !matchesAll(requirements, m.Labels) {
+				return nil
+			}
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list cgroups")
+	}
+	sort.Strings(names)
+	return names, nil
+}
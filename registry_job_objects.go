@@ -0,0 +1,12 @@
+// +build windows
+
+package proclimit
+
+import (
+	"github.com/friendsofgo/errors"
+)
+
+// List is not supported on Windows, as job objects cannot be enumerated.
+func List(selectors ...Selector) ([]string, error) {
+	return nil, errors.New("listing job objects is not supported on windows")
+}
@@ -0,0 +1,210 @@
+// +build linux
+
+package proclimit
+
+import (
+	"github.com/containerd/cgroups"
+	"github.com/friendsofgo/errors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readResources reconstructs the LinuxResources of the Cgroup from its controller files.
+// Only the resources that can be set by Options are read, and values that are the
+// defaults of the kernel (e.g. no memory limit) are left unset.
+func (c *Cgroup) readResources() (*specs.LinuxResources, error) {
+	resources := &specs.LinuxResources{}
+	for _, read := range []func(*specs.LinuxResources) error{
+		c.readCPUResources,
+		c.readCPUSetResources,
+		c.readMemoryResources,
+		c.readPidsResources,
+		c.readBlockIOResources,
+	} {
+		if err := read(resources); err != nil {
+			return nil, errors.Wrap(err, "failed to read cgroup resources")
+		}
+	}
+	return resources, nil
+}
+
+// readControllerFile returns the trimmed contents of a controller file of the Cgroup. It
+// returns "" if the subsystem is not mounted or the file does not exist.
+func (c *Cgroup) readControllerFile(subsystem cgroups.Name, file string) (string, error) {
+	dir, ok := c.subsystemPath(subsystem)
+	if !ok {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readControllerInt reads a controller file containing a single integer. ok is false if
+// the file does not exist.
+func (c *Cgroup) readControllerInt(subsystem cgroups.Name, file string) (value int64, ok bool, err error) {
+	data, err := c.readControllerFile(subsystem, file)
+	if err != nil || data == "" {
+		return 0, false, err
+	}
+	value, err = strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "invalid %s", file)
+	}
+	return value, true, nil
+}
+
+func (c *Cgroup) readCPUResources(resources *specs.LinuxResources) error {
+	quota, ok, err := c.readControllerInt(cgroups.Cpu, "cpu.cfs_quota_us")
+	if err != nil || !ok || quota <= 0 {
+		return err
+	}
+	period, ok, err := c.readControllerInt(cgroups.Cpu, "cpu.cfs_period_us")
+	if err != nil || !ok {
+		return err
+	}
+	if resources.CPU == nil {
+		resources.CPU = &specs.LinuxCPU{}
+	}
+	resources.CPU.Quota = &quota
+	resources.CPU.Period = new(uint64)
+	*resources.CPU.Period = uint64(period)
+	return nil
+}
+
+func (c *Cgroup) readCPUSetResources(resources *specs.LinuxResources) error {
+	dir, ok := c.subsystemPath(cgroups.Cpuset)
+	if !ok {
+		return nil
+	}
+	// cpusets are inherited from the parent, so they are only set if they differ
+	read := func(file string) (own string, err error) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return "", err
+		}
+		parent, err := ioutil.ReadFile(filepath.Join(filepath.Dir(dir), file))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if own = strings.TrimSpace(string(data)); own == strings.TrimSpace(string(parent)) {
+			return "", nil
+		}
+		return own, nil
+	}
+	cpus, err := read("cpuset.cpus")
+	if err != nil {
+		return err
+	}
+	mems, err := read("cpuset.mems")
+	if err != nil {
+		return err
+	}
+	if cpus == "" && mems == "" {
+		return nil
+	}
+	if resources.CPU == nil {
+		resources.CPU = &specs.LinuxCPU{}
+	}
+	resources.CPU.Cpus = cpus
+	resources.CPU.Mems = mems
+	return nil
+}
+
+func (c *Cgroup) readMemoryResources(resources *specs.LinuxResources) error {
+	memory := &specs.LinuxMemory{}
+	for _, f := range []struct {
+		file  string
+		value **int64
+	}{
+		{"memory.limit_in_bytes", &memory.Limit},
+		{"memory.soft_limit_in_bytes", &memory.Reservation},
+		{"memory.memsw.limit_in_bytes", &memory.Swap},
+	} {
+		value, ok, err := c.readControllerInt(cgroups.Memory, f.file)
+		if err != nil {
+			return err
+		}
+		if ok && value >= 0 && value < unlimitedMemoryThreshold {
+			*f.value = &value
+		}
+	}
+	if memory.Limit != nil || memory.Reservation != nil || memory.Swap != nil {
+		resources.Memory = memory
+	}
+	return nil
+}
+
+func (c *Cgroup) readPidsResources(resources *specs.LinuxResources) error {
+	max, err := c.readControllerFile(cgroups.Pids, "pids.max")
+	if err != nil || max == "" || max == "max" {
+		return err
+	}
+	limit, err := strconv.ParseInt(max, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid pids.max")
+	}
+	resources.Pids = &specs.LinuxPids{Limit: limit}
+	return nil
+}
+
+func (c *Cgroup) readBlockIOResources(resources *specs.LinuxResources) error {
+	blkio := &specs.LinuxBlockIO{}
+	for _, f := range []struct {
+		file    string
+		devices *[]specs.LinuxThrottleDevice
+	}{
+		{"blkio.throttle.read_bps_device", &blkio.ThrottleReadBpsDevice},
+		{"blkio.throttle.write_bps_device", &blkio.ThrottleWriteBpsDevice},
+		{"blkio.throttle.read_iops_device", &blkio.ThrottleReadIOPSDevice},
+		{"blkio.throttle.write_iops_device", &blkio.ThrottleWriteIOPSDevice},
+	} {
+		data, err := c.readControllerFile(cgroups.Blkio, f.file)
+		if err != nil {
+			return err
+		}
+		// Each line is "<major>:<minor> <rate>"
+		for _, line := range strings.Split(data, "\n") {
+			var device specs.LinuxThrottleDevice
+			if line == "" {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return errors.Errorf("invalid %s: %q", f.file, line)
+			}
+			numbers := strings.SplitN(fields[0], ":", 2)
+			if len(numbers) != 2 {
+				return errors.Errorf("invalid %s: %q", f.file, line)
+			}
+			var err error
+			if device.Major, err = strconv.ParseInt(numbers[0], 10, 64); err != nil {
+				return errors.Wrapf(err, "invalid %s", f.file)
+			}
+			if device.Minor, err = strconv.ParseInt(numbers[1], 10, 64); err != nil {
+				return errors.Wrapf(err, "invalid %s", f.file)
+			}
+			if device.Rate, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return errors.Wrapf(err, "invalid %s", f.file)
+			}
+			*f.devices = append(*f.devices, device)
+		}
+	}
+	if len(blkio.ThrottleReadBpsDevice)+len(blkio.ThrottleWriteBpsDevice)+
+		len(blkio.ThrottleReadIOPSDevice)+len(blkio.ThrottleWriteIOPSDevice) > 0 {
+		resources.BlockIO = blkio
+	}
+	return nil
+}
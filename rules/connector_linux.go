@@ -0,0 +1,125 @@
+// +build linux
+
+package rules
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Constants of the proc connector (see linux/connector.h and linux/cn_proc.h)
+const (
+	netlinkConnector  = 11 // NETLINK_CONNECTOR
+	cnIdxProc         = 1
+	cnValProc         = 1
+	procCnMcastListen = 1
+	procCnMcastIgnore = 2
+	procEventExec     = 0x00000002
+	procEventUID      = 0x00000004
+
+	// cnMsgSize is the size of struct cn_msg, which precedes each proc_event
+	cnMsgSize = 20
+	// procEventHeaderSize is the size of the fields of struct proc_event before event_data
+	procEventHeaderSize = 16
+)
+
+// connectorReadTimeout is how long a read from the proc connector blocks, so that Run
+// notices when its context is done
+const connectorReadTimeout = 500 * time.Millisecond
+
+// nativeEndian is the byte order of the host, in which proc events are encoded
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// connector receives events from the Linux proc connector, which notifies listeners
+// whenever a process forks, execs, changes its ids or exits. It requires CAP_NET_ADMIN.
+type connector struct {
+	fd  int
+	buf []byte
+}
+
+func openConnector() (*connector, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, netlinkConnector)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	c := &connector{fd: fd, buf: make([]byte, os.Getpagesize())}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	timeout := syscall.NsecToTimeval(int64(connectorReadTimeout))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := c.send(procCnMcastListen); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("sendto", err)
+	}
+	return c, nil
+}
+
+// send sends a proc connector operation (PROC_CN_MCAST_LISTEN or IGNORE) to the kernel
+func (c *connector) send(op uint32) error {
+	msg := make([]byte, syscall.NLMSG_HDRLEN+cnMsgSize+4)
+	// struct nlmsghdr
+	nativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:], syscall.NLMSG_DONE)
+	nativeEndian.PutUint32(msg[12:], uint32(os.Getpid()))
+	// struct cn_msg
+	cn := msg[syscall.NLMSG_HDRLEN:]
+	nativeEndian.PutUint32(cn[0:], cnIdxProc)
+	nativeEndian.PutUint32(cn[4:], cnValProc)
+	nativeEndian.PutUint16(cn[16:], 4)
+	nativeEndian.PutUint32(cn[cnMsgSize:], op)
+	return syscall.Sendto(c.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+// receive waits for events, and returns the pids of the processes that exec'd or changed
+// their uid. It returns no pids if no events were received before the read timed out.
+// syscall.ENOBUFS is returned if events were dropped because they were not read in time.
+func (c *connector) receive() ([]int, error) {
+	n, from, err := syscall.Recvfrom(c.fd, c.buf, 0)
+	if err != nil {
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// Only the kernel may send proc events
+	if sa, ok := from.(*syscall.SockaddrNetlink); !ok || sa.Pid != 0 {
+		return nil, nil
+	}
+	msgs, err := syscall.ParseNetlinkMessage(c.buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, m := range msgs {
+		if m.Header.Type != syscall.NLMSG_DONE || len(m.Data) < cnMsgSize+procEventHeaderSize+8 {
+			continue
+		}
+		// struct proc_event: what, cpu, timestamp_ns, then (for exec and uid events)
+		// process_pid and process_tgid
+		event := m.Data[cnMsgSize:]
+		switch nativeEndian.Uint32(event[0:]) {
+		case procEventExec, procEventUID:
+			pids = append(pids, int(nativeEndian.Uint32(event[procEventHeaderSize+4:])))
+		}
+	}
+	return pids, nil
+}
+
+func (c *connector) close() error {
+	c.send(procCnMcastIgnore)
+	return syscall.Close(c.fd)
+}
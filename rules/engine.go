@@ -0,0 +1,98 @@
+package rules
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"time"
+)
+
+// defaultPollInterval is how often processes are polled if PollInterval is not set
+const defaultPollInterval = time.Second
+
+// Engine moves processes into limiters according to rules. Run watches for processes that
+// start or exec on the host, using the Linux proc connector if possible, and polling /proc
+// otherwise.
+type Engine struct {
+	// Poll forces /proc to be polled, rather than using the proc connector (which requires
+	// CAP_NET_ADMIN)
+	Poll bool
+	// PollInterval is how often /proc is polled for new processes. Defaults to 1s.
+	PollInterval time.Duration
+	// OnLimit is called, if set, whenever a process matches a rule, with the error limiting
+	// it (if any). Processes that exit before they can be limited are ignored.
+	OnLimit func(p *Process, rule *Rule, err error)
+	// Logf logs problems that do not stop the Engine, such as falling back to polling, if set
+	Logf func(format string, v ...interface{})
+
+	rules    []*Rule
+	limiters map[string]proclimit.Limiter
+}
+
+// NewEngine creates an Engine for the rules of config. limiters must contain every limiter
+// that the rules refer to (see OpenLimiters).
+func NewEngine(config *Config, limiters map[string]proclimit.Limiter) (*Engine, error) {
+	for _, r := range config.Rules {
+		if _, ok := limiters[r.Limiter]; !ok {
+			return nil, errors.Errorf("limiter %s is not open", r.Limiter)
+		}
+	}
+	return &Engine{rules: config.Rules, limiters: limiters}, nil
+}
+
+// Match returns the first rule that matches p, or nil if none does
+func (e *Engine) Match(p *Process) *Rule {
+	for _, r := range e.rules {
+		if r.Matches(p) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Apply moves p into the limiter of the first rule that matches it. It returns the rule,
+// or nil if none matched. If the limiter supports it, the process is checked not to have
+// been replaced by another with the same pid (in which case a *proclimit.ProcessGoneError
+// is returned). Processes found by Run are identified before they are described, and
+// other processes when Apply is called.
+func (e *Engine) Apply(p *Process) (*Rule, error) {
+	rule := e.Match(p)
+	if rule == nil {
+		return nil, nil
+	}
+	h := p.handle
+	if h == nil {
+		var err error
+		if h, err = proclimit.OpenProcess(p.Pid); err != nil {
+			return rule, err
+		}
+		defer h.Release()
+	}
+	return rule, proclimit.LimitProcess(e.limiters[rule.Limiter], h)
+}
+
+// apply applies the rules to p, and reports the outcome
+func (e *Engine) apply(p *Process) {
+	rule, err := e.Apply(p)
+	if rule == nil {
+		return
+	}
+	if goneErr, ok := err.(*proclimit.ProcessGoneError); ok && !goneErr.Reused {
+		return
+	}
+	if e.OnLimit != nil {
+		e.OnLimit(p, rule, err)
+	}
+}
+
+func (e *Engine) logf(format string, v ...interface{}) {
+	if e.Logf != nil {
+		e.Logf(format, v...)
+	}
+}
+
+func (e *Engine) pollInterval() time.Duration {
+	if e.PollInterval > 0 {
+		return e.PollInterval
+	}
+	return defaultPollInterval
+}
@@ -0,0 +1,83 @@
+// +build linux
+
+package rules
+
+import (
+	"bytes"
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readProcess reads the description of the process pid from /proc. Kernel threads have no
+// executable, so an error is returned for them. The process is identified before it is
+// read, so that it is not confused with another process that reuses its pid - the
+// description must be released once it is no longer needed.
+func readProcess(pid int) (*Process, error) {
+	h, err := proclimit.OpenProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	p, err := describeProcess(pid)
+	if err != nil {
+		h.Release()
+		return nil, err
+	}
+	p.handle = h
+	return p, nil
+}
+
+func describeProcess(pid int) (*Process, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	exe, err := os.Readlink(filepath.Join(dir, "exe"))
+	if err != nil {
+		return nil, err
+	}
+	cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	uid, err := effectiveUID(dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Process{
+		Pid: pid,
+		UID: uid,
+		// The executable may have been replaced or removed since the process started
+		Exe: strings.TrimSuffix(exe, " (deleted)"),
+	}
+	// Arguments are separated (and terminated) by NUL bytes
+	for _, arg := range bytes.Split(bytes.TrimSuffix(cmdline, []byte{0}), []byte{0}) {
+		p.Cmdline = append(p.Cmdline, string(arg))
+	}
+	return p, nil
+}
+
+// release releases the handle of a process read by readProcess
+func (p *Process) release() {
+	if p.handle != nil {
+		p.handle.Release()
+	}
+}
+
+// effectiveUID reads the effective uid of a process from its status file
+func effectiveUID(dir string) (uint32, error) {
+	status, err := ioutil.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		// Uid: <real> <effective> <saved> <filesystem>
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "Uid:" {
+			uid, err := strconv.ParseUint(fields[2], 10, 32)
+			return uint32(uid), err
+		}
+	}
+	return 0, errors.New("status does not report a uid")
+}
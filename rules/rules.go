@@ -0,0 +1,208 @@
+// Package rules moves processes into limiters automatically, according to rules that
+// match their executable, command line or user - like cgrulesengd. An Engine watches for
+// processes that start (or exec) on the host, and limits those that match a rule.
+package rules
+
+import (
+	"github.com/aoldershaw/proclimit"
+	"github.com/friendsofgo/errors"
+	"io/ioutil"
+	"os/user"
+	"path"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+// Config describes the limiters that processes are moved into, and the rules that
+// select those processes. It is typically loaded from a JSON or YAML document, e.g.
+//
+//	limiters:
+//	  builds:
+//	    cpu: 400
+//	    memory: 8Gi
+//	  background:
+//	    cpu: 25
+//	rules:
+//	  - exe: /usr/bin/make
+//	    limiter: builds
+//	  - command: "^java .*-jar ci\\.jar"
+//	    limiter: builds
+//	  - user: backup
+//	    limiter: background
+//
+// The rules are evaluated in order, and a process is moved into the limiter of the
+// first rule that matches it.
+type Config struct {
+	// Limiters describes the limits of the limiters that rules refer to. Limiters that
+	// are not described must already exist (e.g. created by proclimit run or New).
+	Limiters map[string]*proclimit.Profile `json:"limiters,omitempty"`
+	Rules    []*Rule                       `json:"rules"`
+}
+
+// Rule selects processes to move into a limiter. Every criterion that is specified must
+// match, and at least one must be specified.
+type Rule struct {
+	// Exe is a glob (see path.Match) that the path of the process' executable must
+	// match. If it does not contain a '/', it is matched against the base name instead.
+	Exe string `json:"exe,omitempty"`
+	// Command is a regular expression that must match the command line of the process,
+	// with its arguments separated by spaces
+	Command string `json:"command,omitempty"`
+	// UID is the effective uid the process must run as
+	UID *uint32 `json:"uid,omitempty"`
+	// User is the name of the user the process must run as
+	User string `json:"user,omitempty"`
+	// Limiter is the name of the limiter that matching processes are moved into
+	Limiter string `json:"limiter"`
+
+	command *regexp.Regexp
+	uid     *uint32
+}
+
+// Process describes a process that rules are matched against
+type Process struct {
+	Pid int
+	// UID is the effective uid of the process
+	UID uint32
+	// Exe is the path of the executable of the process
+	Exe string
+	// Cmdline is the command line of the process
+	Cmdline []string
+
+	// handle identifies the process, if it was opened before the process was described
+	handle *proclimit.ProcessHandle
+}
+
+// ParseConfig parses a JSON or YAML document into a Config, and validates its rules.
+// Unknown keys are treated as errors.
+func ParseConfig(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, errors.Wrap(err, "invalid rules")
+	}
+	for name, profile := range c.Limiters {
+		if profile == nil {
+			return nil, errors.Errorf("invalid rules: limiter %q is empty", name)
+		}
+	}
+	if len(c.Rules) == 0 {
+		return nil, errors.New("invalid rules: no rules specified")
+	}
+	for i, r := range c.Rules {
+		if r == nil {
+			return nil, errors.Errorf("invalid rules: rule %d is empty", i+1)
+		}
+		if err := r.compile(); err != nil {
+			return nil, errors.Wrapf(err, "invalid rules: rule %d", i+1)
+		}
+	}
+	return &c, nil
+}
+
+// ReadConfigFile reads and parses a JSON or YAML rules file
+func ReadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read rules")
+	}
+	return ParseConfig(data)
+}
+
+// compile validates the Rule, and prepares it for matching
+func (r *Rule) compile() error {
+	if r.Limiter == "" {
+		return errors.New("no limiter specified")
+	}
+	if r.Exe == "" && r.Command == "" && r.UID == nil && r.User == "" {
+		return errors.New("at least one of exe, command, uid and user must be specified")
+	}
+	if r.Exe != "" {
+		if _, err := path.Match(r.Exe, ""); err != nil {
+			return errors.Wrapf(err, "invalid exe %q", r.Exe)
+		}
+	}
+	if r.Command != "" {
+		command, err := regexp.Compile(r.Command)
+		if err != nil {
+			return errors.Wrap(err, "invalid command")
+		}
+		r.command = command
+	}
+	r.uid = r.UID
+	if r.User != "" {
+		if r.UID != nil {
+			return errors.New("uid and user cannot be specified together")
+		}
+		u, err := user.Lookup(r.User)
+		if err != nil {
+			return err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return errors.Errorf("user %s does not have a numeric uid", r.User)
+		}
+		r.uid = new(uint32)
+		*r.uid = uint32(uid)
+	}
+	return nil
+}
+
+// Matches returns whether p meets all of the criteria of the Rule
+func (r *Rule) Matches(p *Process) bool {
+	if r.Exe != "" {
+		exe := p.Exe
+		if !strings.Contains(r.Exe, "/") {
+			exe = path.Base(exe)
+		}
+		if matched, _ := path.Match(r.Exe, exe); !matched {
+			return false
+		}
+	}
+	if r.command != nil && !r.command.MatchString(strings.Join(p.Cmdline, " ")) {
+		return false
+	}
+	if r.uid != nil && p.UID != *r.uid {
+		return false
+	}
+	return true
+}
+
+// OpenLimiters opens the limiters that the rules of the Config refer to. Limiters described
+// by the Config are created with its limits, or updated if they already exist. Limiters
+// that are not described must already exist.
+func OpenLimiters(c *Config) (map[string]proclimit.Limiter, error) {
+	limiters := map[string]proclimit.Limiter{}
+	for _, r := range c.Rules {
+		if _, ok := limiters[r.Limiter]; ok {
+			continue
+		}
+		limiter, err := openLimiter(r.Limiter, c.Limiters[r.Limiter])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open limiter %s", r.Limiter)
+		}
+		limiters[r.Limiter] = limiter
+	}
+	return limiters, nil
+}
+
+func openLimiter(name string, profile *proclimit.Profile) (proclimit.Limiter, error) {
+	existing, err := proclimit.Existing(name)
+	if profile == nil {
+		return existing, err
+	}
+	if err != nil {
+		return proclimit.NewFromProfile(profile, proclimit.WithName(name))
+	}
+	opts, err := profile.Options()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts) > 0 {
+		if err := existing.Update(opts...); err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
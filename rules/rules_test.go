@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+limiters:
+  builds:
+    cpu: 400
+    memory: 8Gi
+rules:
+  - exe: /usr/bin/make
+    limiter: builds
+  - command: "^java .*-jar ci\\.jar"
+    uid: 1000
+    limiter: ci
+`))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if len(config.Rules) != 2 {
+		t.Fatalf("expected 2 rules, but got %d", len(config.Rules))
+	}
+	if config.Limiters["builds"] == nil || config.Limiters["builds"].CPU != 400 {
+		t.Errorf("expected the builds limiter to be described, but got %+v", config.Limiters["builds"])
+	}
+	if r := config.Rules[1]; r.Limiter != "ci" || r.UID == nil || *r.UID != 1000 {
+		t.Errorf("expected the second rule to select uid 1000 for ci, but got %+v", r)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		description string
+		config      string
+		error       string
+	}{
+		{"no rules", "limiters: {}", "no rules specified"},
+		{"no limiter", "rules: [{exe: make}]", "no limiter specified"},
+		{"no criteria", "rules: [{limiter: builds}]", "at least one of"},
+		{"invalid glob", "rules: [{exe: '[', limiter: builds}]", "invalid exe"},
+		{"invalid regexp", "rules: [{command: '(', limiter: builds}]", "invalid command"},
+		{"uid and user", "rules: [{uid: 0, user: root, limiter: builds}]", "cannot be specified together"},
+		{"unknown key", "rules: [{exe: make, limiter: builds, group: wheel}]", "unknown field"},
+		{"empty limiter", "limiters: {builds: null}\nrules: [{exe: make, limiter: builds}]", "is empty"},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("expected error containing %q, but got: %v", tt.error, err)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	uid := uint32(1000)
+	p := &Process{Pid: 1, UID: 1000, Exe: "/usr/bin/python3.8", Cmdline: []string{"python3", "train.py", "--epochs=10"}}
+	for _, tt := range []struct {
+		description string
+		rule        Rule
+		expected    bool
+	}{
+		{"exe path", Rule{Exe: "/usr/bin/python*"}, true},
+		{"exe base name", Rule{Exe: "python3*"}, true},
+		{"different exe", Rule{Exe: "/usr/local/bin/python*"}, false},
+		{"command", Rule{Command: `train\.py --epochs`}, true},
+		{"different command", Rule{Command: `^python3 serve\.py`}, false},
+		{"uid", Rule{UID: &uid}, true},
+		{"all criteria", Rule{Exe: "python*", Command: "train", UID: &uid}, true},
+		{"one criterion differs", Rule{Exe: "python*", Command: "serve", UID: &uid}, false},
+	} {
+		t.Run(tt.description, func(t *testing.T) {
+			r := tt.rule
+			r.Limiter = "test"
+			if err := r.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if r.Matches(p) != tt.expected {
+				t.Errorf("expected %v, but got %v", tt.expected, !tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// +build linux
+
+package rules
+
+import (
+	"context"
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Run applies the rules to every running process, and then to every process that execs
+// or changes its uid, until ctx is done. New processes are found with the proc connector,
+// unless Poll is set or it cannot be used, in which case /proc is polled.
+//
+// Processes that start and exit between polls are not limited, and neither are processes
+// that fork without exec'ing - but they inherit the limits of their parent.
+func (e *Engine) Run(ctx context.Context) error {
+	if !e.Poll {
+		c, err := openConnector()
+		if err == nil {
+			defer c.close()
+			return e.watch(ctx, c)
+		}
+		e.logf("failed to open the proc connector, polling /proc instead: %v", err)
+	}
+	return e.poll(ctx)
+}
+
+// watch applies the rules to processes that exec or change their uid, as reported by c
+func (e *Engine) watch(ctx context.Context, c *connector) error {
+	// Processes that exec'd before c was opened are found by scanning
+	e.scan()
+	for ctx.Err() == nil {
+		pids, err := c.receive()
+		if err == syscall.ENOBUFS {
+			e.logf("missed process events, rescanning processes")
+			e.scan()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			// Processes that exit before they are read are ignored
+			if p, err := readProcess(pid); err == nil {
+				e.apply(p)
+				p.release()
+			}
+		}
+	}
+	return nil
+}
+
+// scan applies the rules to every running process
+func (e *Engine) scan() {
+	pids, err := procfs.Pids()
+	if err != nil {
+		e.logf("failed to list processes: %v", err)
+		return
+	}
+	for _, pid := range pids {
+		if p, err := readProcess(pid); err == nil {
+			e.apply(p)
+			p.release()
+		}
+	}
+}
+
+// poll applies the rules to processes that are new or have changed since the previous
+// poll, every PollInterval
+func (e *Engine) poll(ctx context.Context) error {
+	ticker := time.NewTicker(e.pollInterval())
+	defer ticker.Stop()
+	var seen map[int]string
+	for {
+		seen = e.pollOnce(seen)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce applies the rules to the processes whose identity (their start time, executable,
+// command line or uid) differs from seen, and returns the identities of all processes
+func (e *Engine) pollOnce(seen map[int]string) map[int]string {
+	pids, err := procfs.Pids()
+	if err != nil {
+		e.logf("failed to list processes: %v", err)
+		return seen
+	}
+	current := make(map[int]string, len(pids))
+	for _, pid := range pids {
+		stat, err := procfs.ReadStat(pid)
+		if err != nil {
+			continue
+		}
+		p, err := readProcess(pid)
+		if err != nil {
+			continue
+		}
+		id := strings.Join(append([]string{strconv.FormatUint(stat.StartTime, 10), p.Exe, strconv.FormatUint(uint64(p.UID), 10)}, p.Cmdline...), "\x00")
+		current[pid] = id
+		if seen[pid] != id {
+			e.apply(p)
+		}
+		p.release()
+	}
+	return current
+}
@@ -0,0 +1,143 @@
+package rules
+
+import (
+	"context"
+	"github.com/aoldershaw/proclimit"
+	"github.com/aoldershaw/proclimit/proclimittest"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// newTestEngine creates an Engine that moves processes running "sleep <duration>" into a
+// fake limiter
+func newTestEngine(t *testing.T, duration string) (*Engine, *proclimittest.Limiter) {
+	config, err := ParseConfig([]byte(`rules: [{exe: sleep, command: "^sleep ` + duration + `$", limiter: test}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := proclimittest.NewLimiter()
+	e, err := NewEngine(config, map[string]proclimit.Limiter{"test": limiter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.OnLimit = func(p *Process, rule *Rule, err error) {
+		if err != nil {
+			t.Errorf("failed to limit process %d: %v", p.Pid, err)
+		}
+	}
+	return e, limiter
+}
+
+func TestEnginePoll(t *testing.T) {
+	e, limiter := newTestEngine(t, "10.1")
+	seen := e.pollOnce(nil)
+
+	cmd := exec.Command("sleep", "10.1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	// Wait for sleep to exec
+	time.Sleep(100 * time.Millisecond)
+
+	seen = e.pollOnce(seen)
+	if pids, _ := limiter.Processes(); len(pids) != 1 || pids[0] != cmd.Process.Pid {
+		t.Fatalf("expected process %d to be limited, but got %v", cmd.Process.Pid, pids)
+	}
+	// Processes are only limited again once they change
+	e.pollOnce(seen)
+	if pids, _ := limiter.Processes(); len(pids) != 1 {
+		t.Errorf("expected the process to be limited once, but got %v", pids)
+	}
+}
+
+func TestEngineConnector(t *testing.T) {
+	c, err := openConnector()
+	if err != nil {
+		t.Skipf("the proc connector cannot be used: %v", err)
+	}
+	c.close()
+
+	e, limiter := newTestEngine(t, "10.2")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("expected no error, but got: %v", err)
+		}
+	}()
+	// Allow the Engine to start listening
+	time.Sleep(100 * time.Millisecond)
+
+	cmd := exec.Command("sleep", "10.2")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	for i := 0; i < 100; i++ {
+		if pids, _ := limiter.Processes(); len(pids) > 0 {
+			if pids[0] != cmd.Process.Pid {
+				t.Errorf("expected process %d to be limited, but got %v", cmd.Process.Pid, pids)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected process %d to be limited", cmd.Process.Pid)
+}
+
+func TestEngineApplyIdentifiesProcess(t *testing.T) {
+	fs, err := proclimittest.NewCgroupFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	cgroup, err := proclimit.New(proclimit.WithName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cgroup.Close()
+	config, err := ParseConfig([]byte(`rules: [{exe: sleep, limiter: test}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(config, map[string]proclimit.Limiter{"test": cgroup})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	// Wait for sleep to exec
+	time.Sleep(100 * time.Millisecond)
+	p, err := readProcess(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.release()
+	if _, err := e.Apply(p); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if pids, _ := fs.Processes("test"); len(pids) != 1 || pids[0] != cmd.Process.Pid {
+		t.Fatalf("expected process %d to be limited, but got %v", cmd.Process.Pid, pids)
+	}
+
+	// The process is identified when it is read, so once it exits it is not limited
+	cmd.Process.Kill()
+	cmd.Wait()
+	_, err = e.Apply(p)
+	if _, ok := err.(*proclimit.ProcessGoneError); !ok {
+		t.Errorf("expected a *proclimit.ProcessGoneError, but got: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+// +build windows
+
+package rules
+
+import (
+	"context"
+	"github.com/friendsofgo/errors"
+)
+
+// Run is not supported on Windows
+func (e *Engine) Run(ctx context.Context) error {
+	return errors.New("watching processes is not supported on windows")
+}
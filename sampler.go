@@ -0,0 +1,166 @@
+package proclimit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// defaultSampleInterval is the interval between samples if none is specified
+const defaultSampleInterval = time.Second
+
+// Sample is a snapshot of the Stats of a limiter
+type Sample struct {
+	// Time is when the sample was taken
+	Time time.Time
+	// Elapsed is the time since the first sample
+	Elapsed time.Duration
+	// CPUPercent is the CPU usage since the previous sample, relative to a single core
+	// (100 = 1 core). It is 0 for the first sample.
+	CPUPercent float64
+	Stats
+}
+
+// SampleWriter writes Samples, e.g. to a file
+type SampleWriter interface {
+	WriteSample(s *Sample) error
+}
+
+// Sampler periodically records the Stats of a limiter, to see how its usage evolves
+type Sampler struct {
+	// Stats returns the current Stats of the limiter, e.g. (*Cgroup).Stats
+	Stats func() (*Stats, error)
+	// Interval is the time between samples. Defaults to 1s.
+	Interval time.Duration
+	// Writer receives the samples
+	Writer SampleWriter
+}
+
+// Run takes a sample immediately, and then every Interval until ctx is done, at which
+// point a final sample is taken. Samples for which the Stats cannot be read are skipped.
+// Run returns the first error returned by the Writer.
+func (s *Sampler) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultSampleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var start, prev *Sample
+	for {
+		if sample := s.sample(start, prev); sample != nil {
+			if err := s.Writer.WriteSample(sample); err != nil {
+				return err
+			}
+			if start == nil {
+				start = sample
+			}
+			prev = sample
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if sample := s.sample(start, prev); sample != nil {
+				return s.Writer.WriteSample(sample)
+			}
+			return nil
+		}
+	}
+}
+
+func (s *Sampler) sample(start, prev *Sample) *Sample {
+	stats, err := s.Stats()
+	if err != nil {
+		return nil
+	}
+	sample := &Sample{Time: time.Now(), Stats: *stats}
+	if start != nil {
+		sample.Elapsed = sample.Time.Sub(start.Time)
+	}
+	if prev != nil {
+		if wall := sample.Time.Sub(prev.Time); wall > 0 {
+			sample.CPUPercent = 100 * float64(stats.CPUUsage-prev.CPUUsage) / float64(wall)
+		}
+	}
+	return sample
+}
+
+// sampleFields are the names of the fields of a sample, in the order written by the CSV writer
+var sampleFields = []string{
+	"time", "elapsed_seconds", "cpu_percent", "cpu_usage_seconds", "user_cpu_seconds", "system_cpu_seconds",
+	"throttled_seconds", "memory_usage_bytes", "memory_max_usage_bytes", "io_read_bytes", "io_write_bytes",
+	"oom_kills", "processes",
+}
+
+type csvSampleWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+// NewCSVSampleWriter returns a SampleWriter that writes samples to w as CSV, preceded by a header
+func NewCSVSampleWriter(w io.Writer) SampleWriter {
+	return &csvSampleWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvSampleWriter) WriteSample(s *Sample) error {
+	if !c.headerWritten {
+		if err := c.w.Write(sampleFields); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	formatSeconds := func(d time.Duration) string {
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	}
+	c.w.Write([]string{
+		s.Time.Format(time.RFC3339Nano),
+		formatSeconds(s.Elapsed),
+		strconv.FormatFloat(s.CPUPercent, 'f', 2, 64),
+		formatSeconds(s.CPUUsage),
+		formatSeconds(s.UserCPU),
+		formatSeconds(s.SystemCPU),
+		formatSeconds(s.ThrottledTime),
+		strconv.FormatUint(uint64(s.MemoryUsage), 10),
+		strconv.FormatUint(uint64(s.MemoryMaxUsage), 10),
+		strconv.FormatUint(s.IOReadBytes, 10),
+		strconv.FormatUint(s.IOWriteBytes, 10),
+		strconv.FormatUint(s.OOMKills, 10),
+		strconv.Itoa(s.Processes),
+	})
+	// Flush every sample, so that the file is useful while the command is running
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonSampleWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONSampleWriter returns a SampleWriter that writes samples to w as JSON, one per line
+func NewJSONSampleWriter(w io.Writer) SampleWriter {
+	return &jsonSampleWriter{enc: json.NewEncoder(w)}
+}
+
+// MarshalJSON writes the Sample as a single object: its time, the elapsed time and CPU
+// percentage, and the fields of its Stats (see Stats.MarshalJSON)
+func (s Sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Time           time.Time `json:"time"`
+		ElapsedSeconds float64   `json:"elapsedSeconds"`
+		CPUPercent     float64   `json:"cpuPercent"`
+		statsJSON
+	}{
+		Time:           s.Time,
+		ElapsedSeconds: s.Elapsed.Seconds(),
+		CPUPercent:     s.CPUPercent,
+		statsJSON:      newStatsJSON(&s.Stats),
+	})
+}
+
+func (j *jsonSampleWriter) WriteSample(s *Sample) error {
+	return j.enc.Encode(s)
+}
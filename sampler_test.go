@@ -0,0 +1,96 @@
+package proclimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSampleWriter struct {
+	mu      sync.Mutex
+	samples []*Sample
+}
+
+func (r *recordingSampleWriter) WriteSample(s *Sample) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, s)
+	return nil
+}
+
+func TestSamplerRun(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		cpu time.Duration
+	)
+	stats := func() (*Stats, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Consume CPU time at a rate of 2 cores
+		cpu += 20 * time.Millisecond
+		return &Stats{CPUUsage: cpu, Processes: 1}, nil
+	}
+	w := &recordingSampleWriter{}
+	s := &Sampler{Stats: stats, Interval: 10 * time.Millisecond, Writer: w}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if len(w.samples) < 3 {
+		t.Fatalf("expected at least 3 samples, but got %d", len(w.samples))
+	}
+	if w.samples[0].CPUPercent != 0 || w.samples[0].Elapsed != 0 {
+		t.Errorf("expected the first sample to have no CPU%% or elapsed time, but got %+v", w.samples[0])
+	}
+	for i, sample := range w.samples[1:] {
+		if sample.Elapsed <= w.samples[i].Elapsed {
+			t.Errorf("expected elapsed time to increase, but got %s after %s", sample.Elapsed, w.samples[i].Elapsed)
+		}
+		if sample.CPUPercent <= 0 {
+			t.Errorf("expected a positive CPU%%, but got %f", sample.CPUPercent)
+		}
+	}
+}
+
+func TestCSVSampleWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewCSVSampleWriter(&out)
+	sampleTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	w.WriteSample(&Sample{Time: sampleTime, Stats: Stats{CPUUsage: time.Second, MemoryUsage: Megabyte, Processes: 2}})
+	w.WriteSample(&Sample{Time: sampleTime.Add(time.Second), Elapsed: time.Second, CPUPercent: 150, Stats: Stats{CPUUsage: 2500 * time.Millisecond}})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{
+		"time,elapsed_seconds,cpu_percent,cpu_usage_seconds,user_cpu_seconds,system_cpu_seconds,throttled_seconds,memory_usage_bytes,memory_max_usage_bytes,io_read_bytes,io_write_bytes,oom_kills,processes",
+		"2020-01-02T03:04:05Z,0,0.00,1,0,0,0,1048576,0,0,0,0,2",
+		"2020-01-02T03:04:06Z,1,150.00,2.5,0,0,0,0,0,0,0,0,0",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, but got:\n%s", len(expected), out.String())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("expected line %d to be %q, but got %q", i, expected[i], lines[i])
+		}
+	}
+}
+
+func TestJSONSampleWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewJSONSampleWriter(&out)
+	w.WriteSample(&Sample{CPUPercent: 50, Stats: Stats{MemoryMaxUsage: Kilobyte}})
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected a JSON line, but got %q: %v", out.String(), err)
+	}
+	if decoded["cpuPercent"] != 50.0 || decoded["memoryMaxUsageBytes"] != 1024.0 {
+		t.Errorf("unexpected sample: %s", out.String())
+	}
+}
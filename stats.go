@@ -0,0 +1,188 @@
+package proclimit
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Stats describes the combined resource usage of all processes within a limiter.
+// Counters are cumulative since the limiter was created. Fields that are not
+// supported by a platform are left as 0.
+type Stats struct {
+	// CPUUsage is the total CPU time consumed
+	CPUUsage time.Duration
+	// UserCPU is the CPU time consumed in user mode
+	UserCPU time.Duration
+	// SystemCPU is the CPU time consumed in kernel mode
+	SystemCPU time.Duration
+	// ThrottledTime is the total time processes were throttled by the CPU limit (Linux only)
+	ThrottledTime time.Duration
+	// CPUPeriods is the number of CPU limit enforcement periods that have elapsed (Linux only)
+	CPUPeriods uint64
+	// ThrottledPeriods is the number of CPU limit enforcement periods in which processes were
+	// throttled (Linux only)
+	ThrottledPeriods uint64
+	// MemoryUsage is the current memory usage (Linux only)
+	MemoryUsage Memory
+	// MemoryMaxUsage is the peak memory usage
+	MemoryMaxUsage Memory
+	// MemoryLimit is the memory limit, or 0 if memory is not limited. On Windows, this is
+	// the limit of each process.
+	MemoryLimit Memory
+	// IOReadBytes is the number of bytes read
+	IOReadBytes uint64
+	// IOWriteBytes is the number of bytes written
+	IOWriteBytes uint64
+	// OOMKills is the number of processes killed for exceeding the memory limit (Linux only)
+	OOMKills uint64
+	// Processes is the number of processes currently running
+	Processes int
+}
+
+// statsJSON is the JSON representation of Stats, used wherever Stats are written as JSON (by
+// the daemon, the CLI and JSON sample writers). Durations are in seconds, and sizes in bytes.
+type statsJSON struct {
+	CPUUsageSeconds      float64 `json:"cpuUsageSeconds"`
+	UserCPUSeconds       float64 `json:"userCpuSeconds"`
+	SystemCPUSeconds     float64 `json:"systemCpuSeconds"`
+	ThrottledTimeSeconds float64 `json:"throttledTimeSeconds"`
+	CPUPeriods           uint64  `json:"cpuPeriods"`
+	ThrottledPeriods     uint64  `json:"throttledPeriods"`
+	MemoryUsageBytes     uint64  `json:"memoryUsageBytes"`
+	MemoryMaxUsageBytes  uint64  `json:"memoryMaxUsageBytes"`
+	MemoryLimitBytes     uint64  `json:"memoryLimitBytes"`
+	IOReadBytes          uint64  `json:"ioReadBytes"`
+	IOWriteBytes         uint64  `json:"ioWriteBytes"`
+	OOMKills             uint64  `json:"oomKills"`
+	Processes            int     `json:"processes"`
+}
+
+func newStatsJSON(s *Stats) statsJSON {
+	return statsJSON{
+		CPUUsageSeconds:      s.CPUUsage.Seconds(),
+		UserCPUSeconds:       s.UserCPU.Seconds(),
+		SystemCPUSeconds:     s.SystemCPU.Seconds(),
+		ThrottledTimeSeconds: s.ThrottledTime.Seconds(),
+		CPUPeriods:           s.CPUPeriods,
+		ThrottledPeriods:     s.ThrottledPeriods,
+		MemoryUsageBytes:     uint64(s.MemoryUsage),
+		MemoryMaxUsageBytes:  uint64(s.MemoryMaxUsage),
+		MemoryLimitBytes:     uint64(s.MemoryLimit),
+		IOReadBytes:          s.IOReadBytes,
+		IOWriteBytes:         s.IOWriteBytes,
+		OOMKills:             s.OOMKills,
+		Processes:            s.Processes,
+	}
+}
+
+// seconds converts a number of seconds read from JSON back into a Duration
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s * float64(time.Second)))
+}
+
+// MarshalJSON writes the Stats with durations in seconds and sizes in bytes, e.g.
+// {"cpuUsageSeconds": 1.5, "memoryUsageBytes": 1048576, ...}
+func (s Stats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newStatsJSON(&s))
+}
+
+// UnmarshalJSON reads Stats written by MarshalJSON
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	var j statsJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*s = Stats{
+		CPUUsage:         seconds(j.CPUUsageSeconds),
+		UserCPU:          seconds(j.UserCPUSeconds),
+		SystemCPU:        seconds(j.SystemCPUSeconds),
+		ThrottledTime:    seconds(j.ThrottledTimeSeconds),
+		CPUPeriods:       j.CPUPeriods,
+		ThrottledPeriods: j.ThrottledPeriods,
+		MemoryUsage:      Memory(j.MemoryUsageBytes),
+		MemoryMaxUsage:   Memory(j.MemoryMaxUsageBytes),
+		MemoryLimit:      Memory(j.MemoryLimitBytes),
+		IOReadBytes:      j.IOReadBytes,
+		IOWriteBytes:     j.IOWriteBytes,
+		OOMKills:         j.OOMKills,
+		Processes:        j.Processes,
+	}
+	return nil
+}
+
+// statser is implemented by Limiters that can report their resource usage
+type statser interface {
+	Stats() (*Stats, error)
+}
+
+// Usage describes the resources consumed by a Cmd.
+//
+// WallTime, UserTime and SystemTime are specific to the command (including any
+// descendants it has waited for). The remaining fields are measured across the
+// whole Limiter, and are only available if the Limiter can report its Stats.
+// If other processes run within the same Limiter, their usage is included.
+type Usage struct {
+	// WallTime is the time between starting the command and it exiting
+	WallTime time.Duration
+	// UserTime is the user CPU time of the command
+	UserTime time.Duration
+	// SystemTime is the system CPU time of the command
+	SystemTime time.Duration
+	// PeakMemory is the peak memory usage of the Limiter
+	PeakMemory Memory
+	// ThrottledTime is the time the Limiter was throttled while the command ran
+	ThrottledTime time.Duration
+	// IOReadBytes is the number of bytes read within the Limiter while the command ran
+	IOReadBytes uint64
+	// IOWriteBytes is the number of bytes written within the Limiter while the command ran
+	IOWriteBytes uint64
+	// OOMKills is the number of processes in the Limiter killed for exceeding the memory
+	// limit while the command ran
+	OOMKills uint64
+}
+
+// newUsage computes the Usage of a command given the Limiter Stats at the time
+// the command started and exited. Either of the Stats may be nil.
+func newUsage(wallTime, userTime, systemTime time.Duration, start, end *Stats) *Usage {
+	u := &Usage{
+		WallTime:   wallTime,
+		UserTime:   userTime,
+		SystemTime: systemTime,
+	}
+	if end == nil {
+		return u
+	}
+	if start == nil {
+		start = &Stats{}
+	}
+	u.PeakMemory = end.MemoryMaxUsage
+	u.ThrottledTime = end.ThrottledTime - start.ThrottledTime
+	u.IOReadBytes = end.IOReadBytes - start.IOReadBytes
+	u.IOWriteBytes = end.IOWriteBytes - start.IOWriteBytes
+	u.OOMKills = end.OOMKills - start.OOMKills
+	return u
+}
+
+// MarshalJSON writes the Usage with durations in seconds and sizes in bytes, like Stats
+func (u Usage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		WallTimeSeconds      float64 `json:"wallTimeSeconds"`
+		UserTimeSeconds      float64 `json:"userTimeSeconds"`
+		SystemTimeSeconds    float64 `json:"systemTimeSeconds"`
+		PeakMemoryBytes      uint64  `json:"peakMemoryBytes"`
+		ThrottledTimeSeconds float64 `json:"throttledTimeSeconds"`
+		IOReadBytes          uint64  `json:"ioReadBytes"`
+		IOWriteBytes         uint64  `json:"ioWriteBytes"`
+		OOMKills             uint64  `json:"oomKills"`
+	}{
+		WallTimeSeconds:      u.WallTime.Seconds(),
+		UserTimeSeconds:      u.UserTime.Seconds(),
+		SystemTimeSeconds:    u.SystemTime.Seconds(),
+		PeakMemoryBytes:      uint64(u.PeakMemory),
+		ThrottledTimeSeconds: u.ThrottledTime.Seconds(),
+		IOReadBytes:          u.IOReadBytes,
+		IOWriteBytes:         u.IOWriteBytes,
+		OOMKills:             u.OOMKills,
+	})
+}
@@ -0,0 +1,44 @@
+package proclimit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatsJSON(t *testing.T) {
+	stats := Stats{
+		CPUUsage:         1500 * time.Millisecond,
+		UserCPU:          time.Second,
+		SystemCPU:        500 * time.Millisecond,
+		ThrottledTime:    time.Nanosecond,
+		CPUPeriods:       10,
+		ThrottledPeriods: 2,
+		MemoryUsage:      Megabyte,
+		MemoryMaxUsage:   2 * Megabyte,
+		MemoryLimit:      Gigabyte,
+		IOReadBytes:      3,
+		IOWriteBytes:     4,
+		OOMKills:         1,
+		Processes:        5,
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["cpuUsageSeconds"] != 1.5 || fields["memoryLimitBytes"] != float64(Gigabyte) || fields["throttledPeriods"] != 2.0 {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	var decoded Stats
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != stats {
+		t.Errorf("expected %+v, but got %+v", stats, decoded)
+	}
+}
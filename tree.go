@@ -0,0 +1,94 @@
+package proclimit
+
+import (
+	"github.com/friendsofgo/errors"
+)
+
+// processLimiter is implemented by Limiters that can check the identity of the processes
+// they limit (see Cgroup.LimitProcess)
+type processLimiter interface {
+	LimitProcess(h *ProcessHandle) error
+}
+
+// LimitProcess applies the limits of limiter to the process of h. If limiter has a
+// LimitProcess method (as Cgroup and JobObject do), it is used to check that the process
+// has not been confused with another process that reused its pid. Otherwise, the process
+// is limited by its pid.
+func LimitProcess(limiter Limiter, h *ProcessHandle) error {
+	if pl, ok := limiter.(processLimiter); ok {
+		return pl.LimitProcess(h)
+	}
+	return limiter.Limit(h.Pid)
+}
+
+// limitPid limits the process pid, checking that it is not confused with another process
+// if limiter supports it
+func limitPid(limiter Limiter, pid int) error {
+	h, err := OpenProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer h.Release()
+	return LimitProcess(limiter, h)
+}
+
+// limitChild limits the process child, which was listed as a child of parent. The listed
+// child may have exited and its pid been reused by an unrelated process before it was
+// opened, so its parent is checked again once it has been: if it is no longer parent,
+// the process is not limited, and a *ProcessGoneError is returned.
+func limitChild(limiter Limiter, parent, child int) error {
+	h, err := OpenProcess(child)
+	if err != nil {
+		return err
+	}
+	defer h.Release()
+	if ppid, err := parentPid(child); err != nil || ppid != parent {
+		return &ProcessGoneError{Pid: child}
+	}
+	return LimitProcess(limiter, h)
+}
+
+// LimitTree applies the limits of limiter to the running process pid, and all of its
+// existing descendants.
+//
+// Each process is limited before its children are listed. Any process it forks after
+// it has been limited inherits its limits, and any process forked before that is
+// found when its children are listed - so processes that appear while the tree is
+// being walked are also limited. Descendants that exit during the walk are ignored.
+//
+// If limiter has a LimitProcess method (as Cgroup and JobObject do), it is used to check
+// that each process has not been confused with another process that reused its pid.
+// Processes that are no longer the children of the process they were listed under (e.g.
+// because they reused the pid of an exited child) are not limited.
+func LimitTree(limiter Limiter, pid int) error {
+	if err := limitPid(limiter, pid); err != nil {
+		return err
+	}
+	limited := map[int]bool{pid: true}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		children, err := childPids(parent)
+		if err != nil {
+			if !processExists(parent) {
+				continue
+			}
+			return errors.Wrapf(err, "failed to list children of process %d", parent)
+		}
+		for _, child := range children {
+			if limited[child] {
+				continue
+			}
+			if err := limitChild(limiter, parent, child); err != nil {
+				if goneErr, ok := err.(*ProcessGoneError); (ok && !goneErr.Reused) || !processExists(child) {
+					continue
+				}
+				return errors.Wrapf(err, "failed to limit process %d", child)
+			}
+			limited[child] = true
+			queue = append(queue, child)
+		}
+	}
+	return nil
+}
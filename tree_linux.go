@@ -0,0 +1,102 @@
+// +build linux
+
+package proclimit
+
+import (
+	"github.com/aoldershaw/proclimit/internal/procfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// childPids returns the pids of the children of the process pid. It reads
+// /proc/<pid>/task/*/children if the kernel supports it, and otherwise scans
+// the parent pid of every process.
+func childPids(pid int) ([]int, error) {
+	taskDirs, err := filepath.Glob(filepath.Join("/proc", strconv.Itoa(pid), "task", "*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(taskDirs) == 0 {
+		return nil, syscall.ESRCH
+	}
+	var children []int
+	for _, taskDir := range taskDirs {
+		data, err := ioutil.ReadFile(filepath.Join(taskDir, "children"))
+		if os.IsNotExist(err) {
+			if _, statErr := os.Stat(taskDir); statErr == nil {
+				// CONFIG_PROC_CHILDREN is not enabled
+				return scanChildPids(pid)
+			}
+			// The thread has exited
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range strings.Fields(string(data)) {
+			if child, err := strconv.Atoi(field); err == nil {
+				children = append(children, child)
+			}
+		}
+	}
+	return children, nil
+}
+
+// scanChildPids finds the children of pid by reading the parent pid of every process
+func scanChildPids(pid int) ([]int, error) {
+	pids, err := procfs.Pids()
+	if err != nil {
+		return nil, err
+	}
+	var children []int
+	for _, p := range pids {
+		if stat, err := procfs.ReadStat(p); err == nil && stat.PPid == pid {
+			children = append(children, p)
+		}
+	}
+	return children, nil
+}
+
+// parentPid returns the pid of the parent of the process pid
+func parentPid(pid int) (int, error) {
+	stat, err := procfs.ReadStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return stat.PPid, nil
+}
+
+// killProcessTree kills the process pid and all of its descendants. Each process is stopped
+// before its children are listed, so that it cannot start more, and the processes are only
+// killed once all have been found - as the children of a killed process are reparented.
+func killProcessTree(pid int) {
+	var pids []int
+	seen := map[int]bool{}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if err := syscall.Kill(p, syscall.SIGSTOP); err != nil {
+			continue
+		}
+		pids = append(pids, p)
+		if children, err := childPids(p); err == nil {
+			queue = append(queue, children...)
+		}
+	}
+	for _, p := range pids {
+		syscall.Kill(p, syscall.SIGKILL)
+	}
+}
+
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
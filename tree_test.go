@@ -0,0 +1,96 @@
+// +build linux
+
+package proclimit
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingLimiter struct {
+	mu   sync.Mutex
+	pids []int
+}
+
+func (r *recordingLimiter) Limit(pid int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pids = append(r.pids, pid)
+	return nil
+}
+
+func TestLimitTree(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sh -c 'sleep 10 & sleep 10 & wait' & sleep 10 & wait")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start command: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// Wait for the tree to be created: sh -> (sh -> (sleep, sleep), sleep)
+	var descendants []int
+	for i := 0; i < 100 && len(descendants) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+		descendants = nil
+		queue := []int{cmd.Process.Pid}
+		for len(queue) > 0 {
+			children, _ := childPids(queue[0])
+			queue = append(queue[1:], children...)
+			descendants = append(descendants, children...)
+		}
+	}
+	if len(descendants) != 4 {
+		t.Fatalf("expected 4 descendants, but got %v", descendants)
+	}
+
+	limiter := &recordingLimiter{}
+	if err := LimitTree(limiter, cmd.Process.Pid); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	expected := append([]int{cmd.Process.Pid}, descendants...)
+	sort.Ints(expected)
+	sort.Ints(limiter.pids)
+	if len(limiter.pids) != len(expected) {
+		t.Fatalf("expected pids %v to be limited, but got %v", expected, limiter.pids)
+	}
+	for i := range expected {
+		if limiter.pids[i] != expected[i] {
+			t.Fatalf("expected pids %v to be limited, but got %v", expected, limiter.pids)
+		}
+	}
+}
+
+func TestLimitChildChecksParent(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start command: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// A process listed under another parent (e.g. because it reused the pid of an exited
+	// child) is not limited
+	limiter := &recordingLimiter{}
+	err := limitChild(limiter, os.Getppid(), cmd.Process.Pid)
+	if _, ok := err.(*ProcessGoneError); !ok {
+		t.Errorf("expected a *ProcessGoneError, but got: %v", err)
+	}
+	if len(limiter.pids) != 0 {
+		t.Errorf("expected no pids to be limited, but got %v", limiter.pids)
+	}
+
+	if err := limitChild(limiter, os.Getpid(), cmd.Process.Pid); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if len(limiter.pids) != 1 || limiter.pids[0] != cmd.Process.Pid {
+		t.Errorf("expected pid %d to be limited, but got %v", cmd.Process.Pid, limiter.pids)
+	}
+}
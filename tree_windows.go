@@ -0,0 +1,102 @@
+// +build windows
+
+package proclimit
+
+import (
+	"github.com/aoldershaw/proclimit/internal/win32"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// childPids returns the pids of the children of the process pid, using a snapshot
+// of all processes.
+func childPids(pid int) ([]int, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateToolhelp32Snapshot", err)
+	}
+	defer syscall.CloseHandle(snapshot)
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	if err := syscall.Process32First(snapshot, &entry); err != nil {
+		return nil, os.NewSyscallError("Process32First", err)
+	}
+	var children []int
+	for {
+		if int(entry.ParentProcessID) == pid && int(entry.ProcessID) != pid {
+			children = append(children, int(entry.ProcessID))
+		}
+		if err := syscall.Process32Next(snapshot, &entry); err != nil {
+			if err == syscall.ERROR_NO_MORE_FILES {
+				return children, nil
+			}
+			return nil, os.NewSyscallError("Process32Next", err)
+		}
+	}
+}
+
+// parentPid returns the pid of the parent of the process pid, using a snapshot of all
+// processes
+func parentPid(pid int) (int, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return 0, os.NewSyscallError("CreateToolhelp32Snapshot", err)
+	}
+	defer syscall.CloseHandle(snapshot)
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	if err := syscall.Process32First(snapshot, &entry); err != nil {
+		return 0, os.NewSyscallError("Process32First", err)
+	}
+	for {
+		if int(entry.ProcessID) == pid {
+			return int(entry.ParentProcessID), nil
+		}
+		if err := syscall.Process32Next(snapshot, &entry); err != nil {
+			if err == syscall.ERROR_NO_MORE_FILES {
+				return 0, syscall.ESRCH
+			}
+			return 0, os.NewSyscallError("Process32Next", err)
+		}
+	}
+}
+
+// killProcessTree kills the process pid and all of its descendants. The descendants are
+// found before any process is killed, as they cannot be found once their parent has exited.
+func killProcessTree(pid int) {
+	pids := []int{pid}
+	seen := map[int]bool{pid: true}
+	for i := 0; i < len(pids); i++ {
+		children, err := childPids(pids[i])
+		if err != nil {
+			continue
+		}
+		for _, child := range children {
+			if !seen[child] {
+				seen[child] = true
+				pids = append(pids, child)
+			}
+		}
+	}
+	for _, p := range pids {
+		if process, err := os.FindProcess(p); err == nil {
+			process.Kill()
+			process.Release()
+		}
+	}
+}
+
+func processExists(pid int) bool {
+	handle, err := win32.OpenProcess(win32.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer win32.CloseHandle(handle)
+	var exitCode uint32
+	if err := syscall.GetExitCodeProcess(handle, &exitCode); err != nil {
+		return false
+	}
+	const stillActive = 259
+	return exitCode == stillActive
+}
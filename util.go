@@ -5,11 +5,14 @@ import (
 	"github.com/google/uuid"
 )
 
-// randomName generates a random UUID (v4)
+// namePrefix is the prefix of generated names, which identifies limiters created by proclimit
+const namePrefix = "proclimit-"
+
+// randomName generates a name from namePrefix and a random UUID (v4)
 func randomName() (string, error) {
 	u, err := uuid.NewRandom()
 	if err != nil {
 		return "", errors.New("failed to generate uuid")
 	}
-	return u.String(), nil
+	return namePrefix + u.String(), nil
 }